@@ -0,0 +1,141 @@
+// Codec server decodes encrypted workflow payloads for the Temporal Web UI and CLI.
+//
+// See https://docs.temporal.io/production-deployment/data-encryption
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"go.temporal.io/sdk/converter"
+
+	"github.com/sagikazarmark/thesis/worker/encryption"
+)
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	var handler http.Handler = converter.NewPayloadCodecHTTPHandler(loadCodec(logger))
+
+	if token := lookupEnv("CODEC_SERVER_AUTH_TOKEN", ""); token != "" {
+		handler = authorize(handler, token)
+	} else {
+		logger.Warn("codec server authorization is disabled: anyone with access to the server can decode payloads")
+	}
+
+	if origins := lookupEnv("CODEC_SERVER_CORS_ORIGINS", ""); origins != "" {
+		handler = cors(handler, strings.Split(origins, ","))
+	}
+
+	server := &http.Server{
+		Addr:              lookupEnv("CODEC_SERVER_ADDRESS", ":8081"),
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		<-ctx.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		_ = server.Shutdown(ctx)
+	}()
+
+	logger.Info("starting codec server", slog.String("address", server.Addr))
+
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("unable to start codec server", slog.Any("error", err))
+
+		os.Exit(1)
+	}
+}
+
+// authorize only lets requests through that carry the expected bearer token.
+//
+// The Temporal Web UI can be configured to forward the user's access token to the codec server.
+func authorize(next http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// CORS preflight requests never carry credentials
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+
+			return
+		}
+
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// cors allows the Temporal Web UI (running on a different origin) to call the codec server.
+func cors(next http.Handler, origins []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+
+		if slices.Contains(origins, origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-Namespace")
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func lookupEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
+
+func loadCodec(logger *slog.Logger) *encryption.Codec {
+	keysDir := lookupEnv("ENCRYPTION_KEYS_DIR", "")
+	keyID := lookupEnv("ENCRYPTION_KEY_ID", "")
+
+	keys, err := encryption.LoadKeys(keysDir)
+	if err != nil {
+		logger.Error("unable to load encryption keys", slog.Any("error", err), slog.String("dir", keysDir))
+
+		os.Exit(1)
+	}
+
+	codec, err := encryption.NewCodec(keyID, keys)
+	if err != nil {
+		logger.Error("unable to create encryption codec", slog.Any("error", err))
+
+		os.Exit(1)
+	}
+
+	return codec
+}
@@ -0,0 +1,125 @@
+// Replayer replays workflow histories against the current workflow code to detect non-deterministic changes.
+//
+// Export histories from production using the Temporal CLI:
+//
+//	temporal workflow show --workflow-id WORKFLOW_ID --output json > histories/WORKFLOW_ID.json
+//
+// Then run the replayer with the exported files (or directories containing them):
+//
+//	go run ./cmd/replayer histories/
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/worker"
+
+	"github.com/sagikazarmark/thesis/worker/encryption"
+	"github.com/sagikazarmark/thesis/worker/workflows"
+)
+
+func main() {
+	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
+
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: replayer HISTORY_FILE_OR_DIR...")
+
+		os.Exit(2)
+	}
+
+	var options worker.WorkflowReplayerOptions
+
+	// Histories of encrypted workflows can only be replayed with the keys they were encrypted with
+	if keysDir := lookupEnv("ENCRYPTION_KEYS_DIR", ""); keysDir != "" {
+		keys, err := encryption.LoadKeys(keysDir)
+		if err != nil {
+			logger.Error("unable to load encryption keys", slog.Any("error", err), slog.String("dir", keysDir))
+
+			os.Exit(2)
+		}
+
+		codec, err := encryption.NewCodec(lookupEnv("ENCRYPTION_KEY_ID", ""), keys)
+		if err != nil {
+			logger.Error("unable to create encryption codec", slog.Any("error", err))
+
+			os.Exit(2)
+		}
+
+		options.DataConverter = converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec)
+	}
+
+	replayer, err := worker.NewWorkflowReplayerWithOptions(options)
+	if err != nil {
+		logger.Error("unable to create replayer", slog.Any("error", err))
+
+		os.Exit(2)
+	}
+
+	workflows.RegisterWorkflows(replayer)
+
+	files, err := historyFiles(os.Args[1:])
+	if err != nil {
+		logger.Error("unable to find history files", slog.Any("error", err))
+
+		os.Exit(2)
+	}
+
+	var failed int
+
+	for _, file := range files {
+		err := replayer.ReplayWorkflowHistoryFromJSONFile(log.NewStructuredLogger(logger.With(slog.String("file", file))), file)
+		if err != nil {
+			logger.Error("replay failed", slog.String("file", file), slog.Any("error", err))
+
+			failed++
+
+			continue
+		}
+
+		logger.Info("replay succeeded", slog.String("file", file))
+	}
+
+	logger.Info("replay finished", slog.Int("total", len(files)), slog.Int("failed", failed))
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func historyFiles(paths []string) ([]string, error) {
+	var files []string
+
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.IsDir() && strings.HasSuffix(path, ".json") {
+				files = append(files, path)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+func lookupEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
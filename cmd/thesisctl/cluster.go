@@ -0,0 +1,309 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"go.temporal.io/sdk/client"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+	"github.com/sagikazarmark/thesis/worker/spec"
+	"github.com/sagikazarmark/thesis/worker/workflows"
+)
+
+type clientFactory func() (client.Client, error)
+
+type clusterOptions struct {
+	file   string
+	detach bool
+}
+
+func (o *clusterOptions) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&o.file, "file", "f", "", "Cluster spec file")
+	cmd.Flags().BoolVarP(&o.detach, "detach", "d", false, "Do not wait for the operation to complete")
+
+	_ = cmd.MarkFlagRequired("file")
+}
+
+type rolloutOptions struct {
+	workflows.RolloutOptions
+}
+
+func (o *rolloutOptions) addFlags(cmd *cobra.Command, nodeGroups bool) {
+	cmd.Flags().BoolVar(&o.Canary, "canary", false, "Rotate a single node first and wait for the node group to become healthy")
+	cmd.Flags().DurationVar(&o.CanaryTimeout, "canary-timeout", workflows.DefaultCanaryTimeout, "Time the node group has to become healthy after rotating the canary")
+	cmd.Flags().BoolVar(&o.ApproveCanary, "approve-canary", false, "Wait for an approval after the canary proved healthy (implies --canary)")
+	cmd.Flags().DurationVar(&o.ApprovalTimeout, "approval-timeout", workflows.DefaultApprovalTimeout, "Time to wait for an approval before failing")
+
+	if nodeGroups {
+		cmd.Flags().BoolVar(&o.ApproveNodeGroups, "approve-nodegroups", false, "Wait for an approval before updating every node group but the first")
+	}
+
+	addHealthCheckFlags(cmd, &o.HealthCheck)
+	addSchedulingCheckFlags(cmd, &o.SchedulingCheck)
+
+	cmd.Flags().StringVar((*string)(&o.Order), "order", "", "Order nodes are rotated in (zone-round-robin, oldest-first or fewest-pods-first)")
+	cmd.Flags().BoolVar(&o.CordonOutdated, "cordon-outdated", false, "Taint outdated nodes up front and cordon them once there is enough replacement capacity")
+	cmd.Flags().IntVar(&o.MaxUnavailablePerZone, "max-unavailable-per-zone", 0, "Maximum number of unavailable nodes per availability zone across the cluster (0 means no limit)")
+
+	cmd.Flags().BoolVar(&o.RollbackOnFailure, "rollback-on-failure", false, "Roll node group updates back if workloads do not recover or the canary fails or is rejected")
+}
+
+func addSchedulingCheckFlags(cmd *cobra.Command, o *workflows.SchedulingCheckOptions) {
+	cmd.Flags().BoolVar(&o.Enabled, "scheduling-check", false, "Make sure the pods of a node fit on the remaining nodes before draining it")
+	cmd.Flags().BoolVar(&o.Surge, "surge", false, "Add a node to the node group if the pods of a node would not fit (implies --scheduling-check)")
+	cmd.Flags().DurationVar(&o.Timeout, "scheduling-check-timeout", workflows.DefaultSchedulingCheckTimeout, "Time the pods of a node have to fit on the remaining nodes")
+}
+
+func addHealthCheckFlags(cmd *cobra.Command, o *workflows.HealthCheckOptions) {
+	cmd.Flags().BoolVar(&o.Enabled, "health-check", false, "Wait for workloads to recover after every node replacement")
+	cmd.Flags().DurationVar(&o.Timeout, "health-check-timeout", workflows.DefaultHealthCheckTimeout, "Time workloads have to recover after a node replacement")
+	cmd.Flags().DurationVar(&o.StuckPodThreshold, "stuck-pod-threshold", workflows.DefaultStuckPodThreshold, "Time after which Pending and crash looping pods are considered unhealthy")
+	cmd.Flags().BoolVar(&o.PauseOnFailure, "pause-on-unhealthy", false, "Pause instead of failing if workloads do not recover in time")
+}
+
+func (o rolloutOptions) options() workflows.RolloutOptions {
+	options := o.RolloutOptions
+
+	if options.ApproveCanary {
+		options.Canary = true
+	}
+
+	if options.SchedulingCheck.Surge {
+		options.SchedulingCheck.Enabled = true
+	}
+
+	return options
+}
+
+func newCreateCommand(newClient clientFactory) *cobra.Command {
+	var options clusterOptions
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			spec, err := loadClusterSpec(options.file)
+			if err != nil {
+				return err
+			}
+
+			input := workflows.CreateClusterInput{
+				Cluster: spec,
+			}
+
+			return startWorkflow(cmd, newClient, workflows.WorkflowID(spec.Name, "create"), workflows.CreateCluster, input, options.detach, clusterConflicts(spec.Name, "delete")...)
+		},
+	}
+
+	options.addFlags(cmd)
+
+	return cmd
+}
+
+func newDeleteCommand(newClient clientFactory) *cobra.Command {
+	var options clusterOptions
+
+	cmd := &cobra.Command{
+		Use:   "delete",
+		Short: "Delete a cluster",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			spec, err := loadClusterSpec(options.file)
+			if err != nil {
+				return err
+			}
+
+			input := workflows.DeleteClusterInput{
+				Cluster: spec,
+			}
+
+			conflicts := clusterConflicts(spec.Name, "create", "upgrade", "reconcile")
+
+			for _, ng := range spec.NodeGroups {
+				conflicts = append(conflicts, workflows.NodeGroupRotationID(spec.Name, ng.Name))
+			}
+
+			return startWorkflow(cmd, newClient, workflows.WorkflowID(spec.Name, "delete"), workflows.DeleteCluster, input, options.detach, conflicts...)
+		},
+	}
+
+	options.addFlags(cmd)
+
+	return cmd
+}
+
+func newUpgradeCommand(newClient clientFactory) *cobra.Command {
+	var (
+		options clusterOptions
+		rollout rolloutOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Upgrade the control plane and node groups of a cluster to the versions in the spec",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			spec, err := loadClusterSpec(options.file)
+			if err != nil {
+				return err
+			}
+
+			input := workflows.UpgradeClusterInput{
+				Cluster: spec,
+				Rollout: rollout.options(),
+			}
+
+			return startWorkflow(cmd, newClient, workflows.WorkflowID(spec.Name, "upgrade"), workflows.UpgradeCluster, input, options.detach, clusterConflicts(spec.Name, "delete", "reconcile")...)
+		},
+	}
+
+	options.addFlags(cmd)
+	rollout.addFlags(cmd, true)
+
+	return cmd
+}
+
+func newNodeGroupCommand(newClient clientFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "nodegroup",
+		Short: "Manage node groups",
+	}
+
+	var (
+		options clusterOptions
+		rollout rolloutOptions
+	)
+
+	updateCmd := &cobra.Command{
+		Use:   "update NAME",
+		Short: "Update a node group to the state described in the spec",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spec, err := loadClusterSpec(options.file)
+			if err != nil {
+				return err
+			}
+
+			for _, ng := range spec.NodeGroups {
+				if ng.Name != args[0] {
+					continue
+				}
+
+				input := workflows.UpdateNodeGroupInput{
+					ClusterName: spec.Name,
+					NodeGroup:   ng,
+					Maintenance: spec.Maintenance,
+					Rollout:     rollout.options(),
+				}
+
+				return startWorkflow(cmd, newClient, workflows.NodeGroupRotationID(spec.Name, ng.Name), workflows.UpdateNodeGroup, input, options.detach, clusterConflicts(spec.Name, "delete")...)
+			}
+
+			return fmt.Errorf("node group %q not found in cluster spec", args[0])
+		},
+	}
+
+	options.addFlags(updateCmd)
+	rollout.addFlags(updateCmd, false)
+
+	cmd.AddCommand(updateCmd, newNodeGroupRecycleCommand(newClient))
+
+	return cmd
+}
+
+func newNodeGroupRecycleCommand(newClient clientFactory) *cobra.Command {
+	var (
+		options         clusterOptions
+		maxAge          time.Duration
+		maxNodes        int
+		healthCheck     workflows.HealthCheckOptions
+		schedulingCheck workflows.SchedulingCheckOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "recycle NAME",
+		Short: "Replace the nodes of a node group older than a maximum age",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spec, err := loadClusterSpec(options.file)
+			if err != nil {
+				return err
+			}
+
+			if !hasNodeGroup(spec, args[0]) {
+				return fmt.Errorf("node group %q not found in cluster spec", args[0])
+			}
+
+			if schedulingCheck.Surge {
+				schedulingCheck.Enabled = true
+			}
+
+			input := workflows.RecycleNodesInput{
+				ClusterName:     spec.Name,
+				NodeGroupName:   args[0],
+				MaxAge:          maxAge,
+				Maintenance:     spec.Maintenance,
+				HealthCheck:     healthCheck,
+				SchedulingCheck: schedulingCheck,
+				MaxNodes:        maxNodes,
+			}
+
+			return startWorkflow(cmd, newClient, workflows.NodeGroupRotationID(spec.Name, args[0]), workflows.RecycleNodes, input, options.detach, clusterConflicts(spec.Name, "delete")...)
+		},
+	}
+
+	options.addFlags(cmd)
+
+	cmd.Flags().DurationVar(&maxAge, "max-age", defaultMaxNodeAge, "Age after which nodes are recycled")
+	cmd.Flags().IntVar(&maxNodes, "max-nodes", workflows.DefaultMaxRecycledNodes, "Maximum number of nodes to recycle")
+
+	addHealthCheckFlags(cmd, &healthCheck)
+	addSchedulingCheckFlags(cmd, &schedulingCheck)
+
+	return cmd
+}
+
+// defaultMaxNodeAge is the default age after which nodes are recycled (30 days).
+const defaultMaxNodeAge = 30 * 24 * time.Hour
+
+func hasNodeGroup(spec cluster.Cluster, name string) bool {
+	for _, ng := range spec.NodeGroups {
+		if ng.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// loadClusterSpec reads and validates a cluster spec file.
+func loadClusterSpec(file string) (cluster.Cluster, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return cluster.Cluster{}, err
+	}
+	defer f.Close()
+
+	c, err := spec.Decode(f)
+	if err != nil {
+		return cluster.Cluster{}, fmt.Errorf("invalid cluster spec: %w", err)
+	}
+
+	return c, nil
+}
+
+// clusterConflicts returns the workflows that must not run next to a standalone operation on a cluster:
+// the entity of the cluster (clusters managed by an entity accept operations through the entity only) and the given operations.
+func clusterConflicts(clusterName string, operations ...string) []string {
+	conflicts := []string{workflows.ClusterEntityID(clusterName)}
+
+	for _, operation := range operations {
+		conflicts = append(conflicts, workflows.WorkflowID(clusterName, operation))
+	}
+
+	return conflicts
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+	"github.com/sagikazarmark/thesis/worker/workflows"
+)
+
+// versionDriftWorkflowID is the ID of the version drift check (and its schedule).
+const versionDriftWorkflowID = "version-drift"
+
+func newDriftCommand(newClient clientFactory) *cobra.Command {
+	var (
+		files         []string
+		upgradeBefore time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Compare the Kubernetes versions of managed clusters with the versions supported by EKS",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			specs, err := loadClusterSpecs(files)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+
+			workflowOptions := client.StartWorkflowOptions{
+				ID:                    versionDriftWorkflowID,
+				TaskQueue:             workflows.TaskQueue,
+				WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+			}
+
+			input := workflows.DetectVersionDriftInput{
+				Clusters:      specs,
+				UpgradeBefore: upgradeBefore,
+			}
+
+			run, err := c.ExecuteWorkflow(ctx, workflowOptions, workflows.DetectVersionDrift, input)
+			if err != nil {
+				return err
+			}
+
+			var output workflows.DetectVersionDriftOutput
+
+			if err := run.Get(ctx, &output); err != nil {
+				return err
+			}
+
+			return printVersionDriftReport(cmd.OutOrStdout(), output.Report)
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&files, "file", "f", nil, "Cluster spec file (desired versions are included in the report)")
+	cmd.Flags().DurationVar(&upgradeBefore, "upgrade-before", workflows.DefaultUpgradeBefore, "Time before the end of standard support clusters are proposed for an upgrade")
+
+	return cmd
+}
+
+func loadClusterSpecs(files []string) ([]cluster.Cluster, error) {
+	specs := make([]cluster.Cluster, 0, len(files))
+
+	for _, file := range files {
+		spec, err := loadClusterSpec(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+
+		specs = append(specs, spec)
+	}
+
+	return specs, nil
+}
+
+func printVersionDriftReport(out io.Writer, report workflows.VersionDriftReport) error {
+	fmt.Fprintf(out, "Supported versions: %v\n\n", report.SupportedVersions)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(w, "CLUSTER\tVERSION\tDESIRED\tSUPPORT\tSTANDARD SUPPORT ENDS\tPROPOSED\tUPGRADE\tERROR")
+
+	for _, drift := range report.Clusters {
+		standardSupportEnd := "-"
+		if !drift.StandardSupportEnd.IsZero() {
+			standardSupportEnd = drift.StandardSupportEnd.Format(time.DateOnly)
+		}
+
+		fmt.Fprintf(
+			w,
+			"%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			drift.Name,
+			drift.Version,
+			orDash(drift.DesiredVersion),
+			drift.Support,
+			standardSupportEnd,
+			orDash(drift.ProposedVersion),
+			orDash(drift.UpgradeWorkflowID),
+			orDash(drift.Error),
+		)
+
+		for _, ng := range drift.NodeGroups {
+			fmt.Fprintf(w, "  %s\t%s\t\t%s\t\t\t\t\n", ng.Name, ng.Version, ng.Support)
+		}
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if fallback := report.CalendarFallback; fallback != nil {
+		fmt.Fprintf(
+			out,
+			"\nSupport dates of %v come from the built-in release calendar (updated %s, %d days ago)\n",
+			fallback.Versions,
+			fallback.UpdatedAt.Format(time.DateOnly),
+			int(fallback.Age.Hours()/24),
+		)
+	}
+
+	return nil
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+
+	return s
+}
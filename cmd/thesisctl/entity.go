@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/spf13/cobra"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+
+	"github.com/sagikazarmark/thesis/worker/workflows"
+)
+
+func newEntityCommand(newClient clientFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "entity",
+		Short: "Manage clusters through their long-running entity workflow",
+	}
+
+	cmd.AddCommand(
+		newEntityApplyCommand(newClient),
+		newEntityDeleteCommand(newClient),
+		newEntityRecycleCommand(newClient),
+		newEntityStateCommand(newClient),
+	)
+
+	return cmd
+}
+
+func newEntityApplyCommand(newClient clientFactory) *cobra.Command {
+	var options clusterOptions
+
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Start the entity of a cluster (if necessary) and reconcile the cluster with the spec",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			spec, err := loadClusterSpec(options.file)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+
+			id := workflows.ClusterEntityID(spec.Name)
+
+			// Returns the running entity if there is one
+			workflowOptions := client.StartWorkflowOptions{
+				ID:                    id,
+				TaskQueue:             workflows.TaskQueue,
+				WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+			}
+
+			_, err = c.ExecuteWorkflow(ctx, workflowOptions, workflows.ClusterEntity, workflows.ClusterEntityInput{Cluster: spec})
+			if err != nil {
+				return err
+			}
+
+			return requestOperation(ctx, cmd, c, id, workflows.UpdateApplySpec, spec, options.detach)
+		},
+	}
+
+	options.addFlags(cmd)
+
+	return cmd
+}
+
+func newEntityDeleteCommand(newClient clientFactory) *cobra.Command {
+	var detach bool
+
+	cmd := &cobra.Command{
+		Use:   "delete CLUSTER",
+		Short: "Delete a cluster after the operations queued before",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+
+			op := workflows.ClusterOperation{
+				Type: workflows.ClusterOperationDelete,
+			}
+
+			return requestOperation(ctx, cmd, c, workflows.ClusterEntityID(args[0]), workflows.UpdateRequestOperation, op, detach)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Do not wait for the operation to complete")
+
+	return cmd
+}
+
+func newEntityRecycleCommand(newClient clientFactory) *cobra.Command {
+	var (
+		detach   bool
+		maxAge   time.Duration
+		maxNodes int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "recycle CLUSTER NODEGROUP",
+		Short: "Replace the nodes of a node group older than a maximum age",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+
+			op := workflows.ClusterOperation{
+				Type:      workflows.ClusterOperationRecycleNodes,
+				NodeGroup: args[1],
+				MaxAge:    maxAge,
+				MaxNodes:  maxNodes,
+			}
+
+			return requestOperation(ctx, cmd, c, workflows.ClusterEntityID(args[0]), workflows.UpdateRequestOperation, op, detach)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&detach, "detach", "d", false, "Do not wait for the operation to complete")
+	cmd.Flags().DurationVar(&maxAge, "max-age", defaultMaxNodeAge, "Age after which nodes are recycled")
+	cmd.Flags().IntVar(&maxNodes, "max-nodes", workflows.DefaultMaxRecycledNodes, "Maximum number of nodes to recycle")
+
+	return cmd
+}
+
+func newEntityStateCommand(newClient clientFactory) *cobra.Command {
+	return &cobra.Command{
+		Use:   "state CLUSTER",
+		Short: "Print the desired spec and the operations of a cluster",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			state, err := queryClusterState(cmd.Context(), c, workflows.ClusterEntityID(args[0]))
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+
+			return encoder.Encode(state)
+		},
+	}
+}
+
+// requestOperation submits an update to a cluster entity and (unless detached) waits for the resulting operation to complete.
+func requestOperation(ctx context.Context, cmd *cobra.Command, c client.Client, id string, update string, arg any, detach bool) error {
+	handle, err := c.UpdateWorkflow(ctx, id, "", update, arg)
+	if err != nil {
+		return err
+	}
+
+	var opID int
+
+	// Rejected updates (eg. invalid spec) return an error here
+	if err := handle.Get(ctx, &opID); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "queued operation %d in %s\n", opID, id)
+
+	if detach {
+		return nil
+	}
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var last string
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case <-ticker.C:
+			state, err := queryClusterState(ctx, c, id)
+			if err != nil {
+				// Transient errors should not stop the watch (completed entities can still be queried)
+				continue
+			}
+
+			for _, result := range state.Recent {
+				if result.Operation.ID != opID {
+					continue
+				}
+
+				if result.Error != "" {
+					return fmt.Errorf("operation %d failed: %s", opID, result.Error)
+				}
+
+				fmt.Fprintln(cmd.OutOrStdout(), "completed")
+
+				return nil
+			}
+
+			line := "queued"
+			if state.Running != nil {
+				line = fmt.Sprintf("running operation %d (%s)", state.Running.ID, state.Running.Type)
+			}
+
+			if line != last {
+				fmt.Fprintln(cmd.OutOrStdout(), line)
+
+				last = line
+			}
+		}
+	}
+}
+
+func queryClusterState(ctx context.Context, c client.Client, id string) (workflows.ClusterEntityState, error) {
+	var state workflows.ClusterEntityState
+
+	value, err := c.QueryWorkflow(ctx, id, "", workflows.QueryClusterState)
+	if err != nil {
+		return state, err
+	}
+
+	if err := value.Get(&state); err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
@@ -0,0 +1,119 @@
+// Thesisctl submits and manages cluster operations.
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/converter"
+
+	"github.com/sagikazarmark/thesis/internal/temporalclient"
+	"github.com/sagikazarmark/thesis/worker/encryption"
+)
+
+func main() {
+	if err := newRootCommand().Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+func newRootCommand() *cobra.Command {
+	options := temporalclient.OptionsFromEnv()
+
+	cmd := &cobra.Command{
+		Use:          "thesisctl",
+		Short:        "Submit and manage cluster operations",
+		SilenceUsage: true,
+	}
+
+	flags := cmd.PersistentFlags()
+
+	flags.StringVar(&options.Address, "address", options.Address, "Temporal frontend address")
+	flags.StringVar(&options.Namespace, "namespace", options.Namespace, "Temporal namespace")
+	flags.StringVar(&options.APIKey, "api-key", options.APIKey, "Temporal Cloud API key")
+	flags.BoolVar(&options.TLS, "tls", options.TLS, "Enable TLS (default: true when an API key is set)")
+	flags.StringVar(&options.TLSOptions.CAFile, "tls-ca-file", options.TLSOptions.CAFile, "CA bundle used to verify the server")
+	flags.StringVar(&options.TLSOptions.CertFile, "tls-cert-file", options.TLSOptions.CertFile, "Client certificate file")
+	flags.StringVar(&options.TLSOptions.KeyFile, "tls-key-file", options.TLSOptions.KeyFile, "Client key file")
+	flags.StringVar(&options.TLSOptions.ServerName, "tls-server-name", options.TLSOptions.ServerName, "Override the server name used for TLS verification")
+
+	newClient := func() (client.Client, error) {
+		// Temporal Cloud requires TLS when using API keys
+		if options.APIKey != "" && !flags.Changed("tls") {
+			if _, ok := os.LookupEnv("TEMPORAL_TLS"); !ok {
+				options.TLS = true
+			}
+		}
+
+		return dialTemporal(options)
+	}
+
+	cmd.AddCommand(
+		newCreateCommand(newClient),
+		newDeleteCommand(newClient),
+		newUpgradeCommand(newClient),
+		newNodeGroupCommand(newClient),
+		newReconcileCommand(newClient),
+		newEntityCommand(newClient),
+		newDriftCommand(newClient),
+		newStatusCommand(newClient),
+		newPauseCommand(newClient),
+		newResumeCommand(newClient),
+		newApproveCommand(newClient),
+		newRollbackCommand(newClient),
+		newAbortCommand(newClient),
+		newHistoryCommand(newClient),
+		newScheduleCommand(newClient),
+		newSpecCommand(),
+	)
+
+	return cmd
+}
+
+func dialTemporal(options temporalclient.Options) (client.Client, error) {
+	// Warnings (eg. failing to reload TLS files) go to stderr, so they don't interfere with the command output
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+	clientOptions, err := options.ClientOptions(logger)
+	if err != nil {
+		return nil, err
+	}
+
+	clientOptions.Logger = nopLogger{}
+
+	// Payloads (eg. query results) are encrypted by the worker
+	if keysDir := lookupEnv("ENCRYPTION_KEYS_DIR", ""); keysDir != "" {
+		keys, err := encryption.LoadKeys(keysDir)
+		if err != nil {
+			return nil, fmt.Errorf("loading encryption keys: %w", err)
+		}
+
+		codec, err := encryption.NewCodec(lookupEnv("ENCRYPTION_KEY_ID", ""), keys)
+		if err != nil {
+			return nil, err
+		}
+
+		clientOptions.DataConverter = converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), codec)
+	}
+
+	return client.Dial(clientOptions)
+}
+
+// nopLogger silences the Temporal client (its logs would interfere with the command output).
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+func lookupEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+
+	"github.com/sagikazarmark/thesis/worker/workflows"
+)
+
+func newReconcileCommand(newClient clientFactory) *cobra.Command {
+	var (
+		options  clusterOptions
+		planOnly bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Converge a cluster to the state described in the spec",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			spec, err := loadClusterSpec(options.file)
+			if err != nil {
+				return err
+			}
+
+			input := workflows.ReconcileClusterInput{
+				Cluster: spec,
+			}
+
+			if !planOnly {
+				return startWorkflow(cmd, newClient, workflows.WorkflowID(spec.Name, "reconcile"), workflows.ReconcileCluster, input, options.detach, clusterConflicts(spec.Name, "create", "delete", "upgrade")...)
+			}
+
+			input.PlanOnly = true
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+
+			// Planning does not change the cluster, so it may run next to other operations
+			workflowOptions := client.StartWorkflowOptions{
+				ID:                    workflows.WorkflowID(spec.Name, "plan"),
+				TaskQueue:             workflows.TaskQueue,
+				WorkflowIDReusePolicy: enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+			}
+
+			run, err := c.ExecuteWorkflow(ctx, workflowOptions, workflows.ReconcileCluster, input)
+			if err != nil {
+				return err
+			}
+
+			var output workflows.ReconcileClusterOutput
+
+			if err := run.Get(ctx, &output); err != nil {
+				return err
+			}
+
+			printPlan(cmd.OutOrStdout(), output.Plan)
+
+			return nil
+		},
+	}
+
+	options.addFlags(cmd)
+
+	cmd.Flags().BoolVar(&planOnly, "plan", false, "Print the plan without applying it")
+
+	return cmd
+}
+
+func printPlan(w io.Writer, plan workflows.ReconcilePlan) {
+	if len(plan.Actions) == 0 {
+		fmt.Fprintln(w, "cluster is up to date")
+
+		return
+	}
+
+	for i, action := range plan.Actions {
+		fmt.Fprintf(w, "%d. %s\n", i+1, action)
+
+		for _, change := range action.Changes {
+			fmt.Fprintf(w, "     %s: %q -> %q\n", change.Key, change.Current, change.Desired)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/sagikazarmark/thesis/worker/workflows"
+)
+
+func newScheduleCommand(newClient clientFactory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schedule",
+		Short: "Manage recurring cluster operations",
+	}
+
+	cmd.AddCommand(
+		newScheduleImageRefreshCommand(newClient),
+		newScheduleNodeRecycleCommand(newClient),
+		newScheduleVersionDriftCommand(newClient),
+		newScheduleDeleteCommand(newClient),
+	)
+
+	return cmd
+}
+
+func newScheduleImageRefreshCommand(newClient clientFactory) *cobra.Command {
+	var (
+		file string
+		cron string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "image-refresh",
+		Short: "Periodically roll out the latest patch release of node group images",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			spec, err := loadClusterSpec(file)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			id := workflows.WorkflowID(spec.Name, "image-refresh")
+
+			scheduleSpec := client.ScheduleSpec{
+				CronExpressions: []string{cron},
+			}
+
+			// Node groups are refreshed based on their current state (rather than this spec) when the schedule runs
+			input := workflows.RefreshNodeGroupImagesInput{
+				ClusterName: spec.Name,
+				Maintenance: spec.Maintenance,
+			}
+
+			action := &client.ScheduleWorkflowAction{
+				ID:        id,
+				Workflow:  workflows.RefreshNodeGroupImages,
+				Args:      []any{input},
+				TaskQueue: workflows.TaskQueue,
+			}
+
+			err = createOrUpdateSchedule(cmd.Context(), c, client.ScheduleOptions{
+				ID:     id,
+				Spec:   scheduleSpec,
+				Action: action,
+
+				// Don't start a new refresh while the previous one is still rotating nodes
+				Overlap: enumspb.SCHEDULE_OVERLAP_POLICY_SKIP,
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "scheduled %s (%s)\n", id, cron)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Cluster spec file")
+	cmd.Flags().StringVar(&cron, "cron", "0 3 * * 1", "Cron expression (UTC) describing when to refresh images")
+
+	_ = cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func newScheduleNodeRecycleCommand(newClient clientFactory) *cobra.Command {
+	var (
+		file     string
+		cron     string
+		maxAge   time.Duration
+		maxNodes int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "node-recycle NAME",
+		Short: "Periodically replace the nodes of a node group older than a maximum age",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			spec, err := loadClusterSpec(file)
+			if err != nil {
+				return err
+			}
+
+			if !hasNodeGroup(spec, args[0]) {
+				return fmt.Errorf("node group %q not found in cluster spec", args[0])
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			id := workflows.WorkflowID(spec.Name, "nodegroup", args[0], "node-recycle")
+
+			scheduleSpec := client.ScheduleSpec{
+				CronExpressions: []string{cron},
+			}
+
+			action := &client.ScheduleWorkflowAction{
+				ID:       id,
+				Workflow: workflows.RecycleNodes,
+				Args: []any{workflows.RecycleNodesInput{
+					ClusterName:   spec.Name,
+					NodeGroupName: args[0],
+					MaxAge:        maxAge,
+					Maintenance:   spec.Maintenance,
+					MaxNodes:      maxNodes,
+				}},
+				TaskQueue: workflows.TaskQueue,
+			}
+
+			err = createOrUpdateSchedule(cmd.Context(), c, client.ScheduleOptions{
+				ID:     id,
+				Spec:   scheduleSpec,
+				Action: action,
+
+				// Don't start recycling nodes while the previous run is still rotating them
+				Overlap: enumspb.SCHEDULE_OVERLAP_POLICY_SKIP,
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "scheduled %s (%s)\n", id, cron)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Cluster spec file")
+	cmd.Flags().StringVar(&cron, "cron", "0 4 * * *", "Cron expression (UTC) describing when to recycle nodes")
+	cmd.Flags().DurationVar(&maxAge, "max-age", defaultMaxNodeAge, "Age after which nodes are recycled")
+	cmd.Flags().IntVar(&maxNodes, "max-nodes", workflows.DefaultMaxRecycledNodes, "Maximum number of nodes to recycle in a single run")
+
+	_ = cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func newScheduleVersionDriftCommand(newClient clientFactory) *cobra.Command {
+	var (
+		files         []string
+		cron          string
+		autoUpgrade   bool
+		upgradeBefore time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "version-drift",
+		Short: "Periodically compare the Kubernetes versions of managed clusters with the versions supported by EKS",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			specs, err := loadClusterSpecs(files)
+			if err != nil {
+				return err
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			scheduleSpec := client.ScheduleSpec{
+				CronExpressions: []string{cron},
+			}
+
+			action := &client.ScheduleWorkflowAction{
+				ID:       versionDriftWorkflowID,
+				Workflow: workflows.DetectVersionDrift,
+				Args: []any{workflows.DetectVersionDriftInput{
+					Clusters:      specs,
+					AutoUpgrade:   autoUpgrade,
+					UpgradeBefore: upgradeBefore,
+				}},
+				TaskQueue: workflows.TaskQueue,
+			}
+
+			err = createOrUpdateSchedule(cmd.Context(), c, client.ScheduleOptions{
+				ID:     versionDriftWorkflowID,
+				Spec:   scheduleSpec,
+				Action: action,
+
+				Overlap: enumspb.SCHEDULE_OVERLAP_POLICY_SKIP,
+			})
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "scheduled %s (%s)\n", versionDriftWorkflowID, cron)
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&files, "file", "f", nil, "Cluster spec file (required for automatic upgrades)")
+	cmd.Flags().StringVar(&cron, "cron", "0 6 * * 1", "Cron expression (UTC) describing when to check versions")
+	cmd.Flags().BoolVar(&autoUpgrade, "auto-upgrade", false, "Upgrade clusters approaching the end of standard support")
+	cmd.Flags().DurationVar(&upgradeBefore, "upgrade-before", workflows.DefaultUpgradeBefore, "Time before the end of standard support clusters are proposed for an upgrade")
+
+	return cmd
+}
+
+// createOrUpdateSchedule creates a schedule or updates its spec and action if it already exists.
+func createOrUpdateSchedule(ctx context.Context, c client.Client, options client.ScheduleOptions) error {
+	_, err := c.ScheduleClient().Create(ctx, options)
+	if errors.Is(err, temporal.ErrScheduleAlreadyRunning) {
+		err = c.ScheduleClient().GetHandle(ctx, options.ID).Update(ctx, client.ScheduleUpdateOptions{
+			DoUpdate: func(input client.ScheduleUpdateInput) (*client.ScheduleUpdate, error) {
+				schedule := input.Description.Schedule
+
+				schedule.Spec = &options.Spec
+				schedule.Action = options.Action
+
+				return &client.ScheduleUpdate{
+					Schedule: &schedule,
+				}, nil
+			},
+		})
+	}
+
+	return err
+}
+
+func newScheduleDeleteCommand(newClient clientFactory) *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete SCHEDULE_ID",
+		Short: "Delete a schedule",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			return c.ScheduleClient().GetHandle(cmd.Context(), args[0]).Delete(cmd.Context())
+		},
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/sagikazarmark/thesis/worker/spec"
+)
+
+func newSpecCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "spec",
+		Short: "Work with cluster spec files",
+	}
+
+	cmd.AddCommand(
+		newSpecConvertCommand(),
+		newSpecSchemaCommand(),
+	)
+
+	return cmd
+}
+
+func newSpecConvertCommand() *cobra.Command {
+	var (
+		file       string
+		apiVersion string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "convert",
+		Short: "Convert a cluster spec to another API version",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			c, err := loadClusterSpec(file)
+			if err != nil {
+				return err
+			}
+
+			return spec.Encode(cmd.OutOrStdout(), c, apiVersion)
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Cluster spec file")
+	cmd.Flags().StringVar(&apiVersion, "api-version", spec.LatestAPIVersion, "Target API version ("+strings.Join(spec.APIVersions(), ", ")+")")
+
+	_ = cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+func newSpecSchemaCommand() *cobra.Command {
+	var (
+		kind       string
+		apiVersion string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "schema",
+		Short: "Print the JSON Schema of a spec kind",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			schema, err := spec.Schema(apiVersion, kind)
+			if err != nil {
+				return err
+			}
+
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+
+			return encoder.Encode(schema)
+		},
+	}
+
+	cmd.Flags().StringVar(&kind, "kind", "Cluster", "Kind to print the schema of")
+	cmd.Flags().StringVar(&apiVersion, "api-version", spec.LatestAPIVersion, "API version ("+strings.Join(spec.APIVersions(), ", ")+")")
+
+	return cmd
+}
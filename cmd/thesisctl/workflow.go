@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+
+	"github.com/sagikazarmark/thesis/worker/workflows"
+)
+
+// startWorkflow starts a cluster operation and (unless detached) waits for it to complete.
+//
+// The operation is not started if any of the conflicting workflows is running.
+func startWorkflow(cmd *cobra.Command, newClient clientFactory, id string, workflow any, input any, detach bool, conflicts ...string) error {
+	c, err := newClient()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+	defer cancel()
+
+	if err := ensureNotRunning(ctx, c, conflicts...); err != nil {
+		return err
+	}
+
+	options := client.StartWorkflowOptions{
+		ID:        id,
+		TaskQueue: workflows.TaskQueue,
+
+		// Only one instance of an operation may run for a cluster at a time
+		WorkflowExecutionErrorWhenAlreadyStarted: true,
+		WorkflowIDReusePolicy:                    enumspb.WORKFLOW_ID_REUSE_POLICY_ALLOW_DUPLICATE,
+	}
+
+	run, err := c.ExecuteWorkflow(ctx, options, workflow, input)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "started %s (run %s)\n", run.GetID(), run.GetRunID())
+
+	if detach {
+		return nil
+	}
+
+	return watchWorkflow(ctx, cmd, c, run.GetID())
+}
+
+// ensureNotRunning returns an error if any of the workflows is running.
+//
+// Operations run under deterministic IDs (see [workflows.WorkflowID]), so conflicting operations can be detected before starting a new one.
+// The check is best effort: a conflicting operation may still start right after it.
+func ensureNotRunning(ctx context.Context, c client.Client, ids ...string) error {
+	for _, id := range ids {
+		description, err := c.DescribeWorkflowExecution(ctx, id, "")
+
+		var notFoundErr *serviceerror.NotFound
+		if errors.As(err, &notFoundErr) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if description.GetWorkflowExecutionInfo().GetStatus() == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING {
+			return fmt.Errorf("conflicting workflow is running: %s", id)
+		}
+	}
+
+	return nil
+}
+
+// watchWorkflow prints the progress of a workflow until it completes.
+//
+// Interrupting the watch does not affect the workflow.
+func watchWorkflow(ctx context.Context, cmd *cobra.Command, c client.Client, id string) error {
+	done := make(chan error, 1)
+
+	go func() {
+		// Follows continue-as-new runs
+		done <- c.GetWorkflow(ctx, id, "").Get(ctx, nil)
+	}()
+
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	var last string
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintln(cmd.OutOrStdout(), "completed")
+
+			return nil
+
+		case <-ticker.C:
+			status, err := queryStatus(ctx, c, id)
+			if err != nil {
+				// The workflow may have completed in the meantime
+				continue
+			}
+
+			if line := formatStatus(status); line != last {
+				fmt.Fprintln(cmd.OutOrStdout(), line)
+
+				last = line
+			}
+		}
+	}
+}
+
+// queryStatus returns the status of a workflow, followed by the status of the child workflows running its current phase.
+func queryStatus(ctx context.Context, c client.Client, id string) ([]workflows.Status, error) {
+	var statuses []workflows.Status
+
+	for id != "" {
+		var status workflows.Status
+
+		value, err := c.QueryWorkflow(ctx, id, "", workflows.QueryStatus)
+		if err != nil {
+			// The child may have completed in the meantime
+			if len(statuses) > 0 {
+				break
+			}
+
+			return nil, err
+		}
+
+		if err := value.Get(&status); err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, status)
+
+		id = status.ChildWorkflowID
+	}
+
+	return statuses, nil
+}
+
+func formatStatus(statuses []workflows.Status) string {
+	lines := make([]string, 0, len(statuses))
+
+	for _, status := range statuses {
+		line := status.Phase
+
+		if status.Total > 0 {
+			line = fmt.Sprintf("%s (%d/%d)", line, status.Completed, status.Total)
+		}
+
+		if status.Paused {
+			line += " [paused]"
+		}
+
+		if status.ApprovalGate != "" {
+			line += fmt.Sprintf(" [waiting for approval at %s]", status.ApprovalGate)
+		}
+
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, " > ")
+}
+
+func newStatusCommand(newClient clientFactory) *cobra.Command {
+	var watch bool
+
+	cmd := &cobra.Command{
+		Use:   "status WORKFLOW_ID",
+		Short: "Show the status of an operation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+
+			if watch {
+				return watchWorkflow(ctx, cmd, c, args[0])
+			}
+
+			description, err := c.DescribeWorkflowExecution(ctx, args[0], "")
+			if err != nil {
+				return err
+			}
+
+			info := description.GetWorkflowExecutionInfo()
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+
+			fmt.Fprintf(w, "Workflow ID:\t%s\n", info.GetExecution().GetWorkflowId())
+			fmt.Fprintf(w, "Run ID:\t%s\n", info.GetExecution().GetRunId())
+			fmt.Fprintf(w, "Type:\t%s\n", info.GetType().GetName())
+			fmt.Fprintf(w, "Started:\t%s\n", info.GetStartTime().Format(time.RFC3339))
+			fmt.Fprintf(w, "Execution status:\t%s\n", info.GetStatus())
+
+			if info.GetStatus() == enumspb.WORKFLOW_EXECUTION_STATUS_RUNNING {
+				status, err := queryStatus(ctx, c, args[0])
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(w, "Status:\t%s\n", formatStatus(status))
+			}
+
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Watch the operation until it completes")
+
+	return cmd
+}
+
+func newPauseCommand(newClient clientFactory) *cobra.Command {
+	return newSignalCommand(newClient, "pause", "Pause an operation before its next step", workflows.SignalPause)
+}
+
+func newResumeCommand(newClient clientFactory) *cobra.Command {
+	return newSignalCommand(newClient, "resume", "Resume a paused operation", workflows.SignalResume)
+}
+
+func newSignalCommand(newClient clientFactory, use string, short string, signalName string) *cobra.Command {
+	return &cobra.Command{
+		Use:   use + " WORKFLOW_ID",
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			return c.SignalWorkflow(cmd.Context(), args[0], "", signalName, nil)
+		},
+	}
+}
+
+func newApproveCommand(newClient clientFactory) *cobra.Command {
+	var (
+		reject   bool
+		approver string
+		comment  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "approve WORKFLOW_ID",
+		Short: "Approve (or reject) an operation waiting for approval",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if approver == "" {
+				return errors.New("approver is required")
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			statuses, err := queryStatus(cmd.Context(), c, args[0])
+			if err != nil {
+				return err
+			}
+
+			// The gate may belong to a child workflow (eg. the canary of a node group rotation during an upgrade)
+			id := args[0]
+
+			for _, status := range statuses {
+				if status.ApprovalGate != "" {
+					approval := workflows.Approval{
+						Gate:     status.ApprovalGate,
+						Approved: !reject,
+						Approver: approver,
+						Comment:  comment,
+					}
+
+					return c.SignalWorkflow(cmd.Context(), id, "", workflows.SignalApproval, approval)
+				}
+
+				id = status.ChildWorkflowID
+			}
+
+			return errors.New("operation is not waiting for approval")
+		},
+	}
+
+	cmd.Flags().BoolVar(&reject, "reject", false, "Reject the change (fails the operation)")
+	cmd.Flags().StringVar(&approver, "approver", os.Getenv("USER"), "Identity of the approver")
+	cmd.Flags().StringVar(&comment, "comment", "", "Comment recorded with the decision")
+
+	return cmd
+}
+
+func newRollbackCommand(newClient clientFactory) *cobra.Command {
+	var (
+		requester string
+		reason    string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "rollback WORKFLOW_ID",
+		Short: "Roll back a node group update",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if requester == "" {
+				return errors.New("requester is required")
+			}
+
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			request := workflows.RollbackRequest{
+				Requester: requester,
+				Reason:    reason,
+			}
+
+			return c.SignalWorkflow(cmd.Context(), args[0], "", workflows.SignalRollback, request)
+		},
+	}
+
+	cmd.Flags().StringVar(&requester, "requester", os.Getenv("USER"), "Identity of the person requesting the rollback")
+	cmd.Flags().StringVar(&reason, "reason", "", "Reason recorded with the rollback")
+
+	return cmd
+}
+
+func newAbortCommand(newClient clientFactory) *cobra.Command {
+	return &cobra.Command{
+		Use:   "abort WORKFLOW_ID",
+		Short: "Abort an operation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			return c.CancelWorkflow(cmd.Context(), args[0], "")
+		},
+	}
+}
+
+func newHistoryCommand(newClient clientFactory) *cobra.Command {
+	return &cobra.Command{
+		Use:   "history WORKFLOW_ID",
+		Short: "Show the event history of an operation",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := newClient()
+			if err != nil {
+				return err
+			}
+			defer c.Close()
+
+			iter := c.GetWorkflowHistory(cmd.Context(), args[0], "", false, enumspb.HISTORY_EVENT_FILTER_TYPE_ALL_EVENT)
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+
+			fmt.Fprintln(w, "ID\tTIME\tTYPE")
+
+			for iter.HasNext() {
+				event, err := iter.Next()
+				if err != nil {
+					return err
+				}
+
+				fmt.Fprintf(w, "%d\t%s\t%s\n", event.GetEventId(), event.GetEventTime().Format(time.RFC3339), event.GetEventType())
+			}
+
+			return w.Flush()
+		},
+	}
+}
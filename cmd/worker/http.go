@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/uber-go/tally/v4"
+	tallyprom "github.com/uber-go/tally/v4/prometheus"
+	"go.temporal.io/sdk/client"
+	sdktally "go.temporal.io/sdk/contrib/tally"
+)
+
+// newMetricsHandler returns a Temporal metrics handler that reports to a Prometheus registry.
+//
+// Both the Temporal SDK metrics and the custom metrics recorded by workflows and activities end up in the registry.
+func newMetricsHandler(logger *slog.Logger, registry *prometheus.Registry) client.MetricsHandler {
+	reporter, err := tallyprom.Configuration{
+		TimerType: "histogram",
+	}.NewReporter(tallyprom.ConfigurationOptions{
+		Registry: registry,
+		OnError: func(err error) {
+			logger.Error("unable to register metric", slog.Any("error", err))
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	scope, _ := tally.NewRootScope(tally.ScopeOptions{
+		CachedReporter:  reporter,
+		Separator:       tallyprom.DefaultSeparator,
+		SanitizeOptions: &sdktally.PrometheusSanitizeOptions,
+	}, time.Second)
+
+	return sdktally.NewMetricsHandler(sdktally.NewPrometheusNamingScope(scope))
+}
+
+// newHTTPServer returns a server exposing metrics and health checks.
+func newHTTPServer(addr string, registry *prometheus.Registry, temporalClient client.Client) *http.Server {
+	mux := http.NewServeMux()
+
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+		// Keep serving the rest of the metrics if a collector (eg. the Temporal visibility query) fails
+		ErrorHandling: promhttp.ContinueOnError,
+	}))
+
+	// The process is alive as long as it can serve requests
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	// The worker is ready when it can reach Temporal
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		_, err := temporalClient.CheckHealth(ctx, &client.CheckHealthRequest{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+
+			return
+		}
+
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	return &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+}
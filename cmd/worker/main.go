@@ -1,112 +1,210 @@
 package main
 
 import (
-	"crypto/tls"
-	"encoding/base64"
+	"context"
+	"errors"
 	"log/slog"
+	"net/http"
 	"os"
-
-	"go.temporal.io/sdk/client"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"go.temporal.io/sdk/contrib/opentelemetry"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/log"
 	"go.temporal.io/sdk/worker"
 
+	"github.com/sagikazarmark/thesis/internal/temporalclient"
 	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
 	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+	"github.com/sagikazarmark/thesis/worker/encryption"
+	"github.com/sagikazarmark/thesis/worker/metrics"
 	"github.com/sagikazarmark/thesis/worker/workflows"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
 
-	logger.Info("starting worker", slog.String("version", version), slog.String("revision", version), slog.String("revisionDate", version))
+	logger.Info("starting worker", slog.String("version", version), slog.String("revision", revision), slog.String("revisionDate", revisionDate))
 
-	var connectionOptions client.ConnectionOptions
+	clientOptions, err := temporalclient.OptionsFromEnv().ClientOptions(logger)
+	if err != nil {
+		logger.Error("invalid Temporal connection options", slog.Any("error", err))
 
-	if lookupEnv("TEMPORAL_TLS", "false") == "true" {
-		connectionOptions.TLS = loadTLS(logger)
+		return exitCodeConfig
 	}
 
-	temporalClient, err := client.Dial(client.Options{
-		HostPort:          lookupEnv("TEMPORAL_ADDRESS", client.DefaultHostPort),
-		Namespace:         lookupEnv("TEMPORAL_NAMESPACE", "default"),
-		Logger:            log.NewStructuredLogger(logger.With(slog.String("subsystem", "temporal"))),
-		ConnectionOptions: connectionOptions,
-	})
+	dialTimeout, err := time.ParseDuration(lookupEnv("TEMPORAL_DIAL_TIMEOUT", "2m"))
 	if err != nil {
-		logger.Error("unable to create Temporal Client", slog.Any("error", err))
+		logger.Error("invalid dial timeout", slog.Any("error", err))
+
+		return exitCodeConfig
 	}
-	defer temporalClient.Close()
 
-	w := worker.New(temporalClient, "thesis", worker.Options{})
+	var dataConverter converter.DataConverter
 
-	workflows.RegisterWorkflows(w)
-	awsactivities.RegisterActivities(w)
-	kubeactivities.RegisterActivities(w)
+	if lookupEnv("ENCRYPTION_KEYS_DIR", "") != "" {
+		dataConverter = converter.NewCodecDataConverter(converter.GetDefaultDataConverter(), loadCodec(logger))
+	} else {
+		logger.Warn("payload encryption is disabled: workflow data is stored in plaintext")
+	}
 
-	err = w.Run(worker.InterruptCh())
-	if err != nil {
-		logger.Error("unable to start Worker", slog.Any("error", err))
+	var interceptors []interceptor.ClientInterceptor
+
+	if lookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT", lookupEnv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")) != "" {
+		tracerProvider, err := newTracerProvider(context.Background())
+		if err != nil {
+			logger.Error("unable to create tracer provider", slog.Any("error", err))
+
+			return exitCodeConfig
+		}
+		defer tracerProvider.Shutdown(context.Background())
+
+		tracingInterceptor, err := opentelemetry.NewTracingInterceptor(opentelemetry.TracerOptions{})
+		if err != nil {
+			logger.Error("unable to create tracing interceptor", slog.Any("error", err))
 
-		os.Exit(1)
+			return exitCodeConfig
+		}
+
+		interceptors = append(interceptors, tracingInterceptor)
 	}
-}
 
-func lookupEnv(key, fallback string) string {
-	if value, ok := os.LookupEnv(key); ok {
-		return value
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	dialCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	clientOptions.Logger = log.NewStructuredLogger(logger.With(slog.String("subsystem", "temporal")))
+	clientOptions.DataConverter = dataConverter
+	clientOptions.MetricsHandler = newMetricsHandler(logger, registry)
+	clientOptions.Interceptors = interceptors
+
+	temporalClient, err := temporalclient.Dial(dialCtx, logger, clientOptions, dialTimeout)
+	stop()
+	if err != nil {
+		logger.Error("unable to create Temporal Client", slog.Any("error", err))
+
+		return exitCodeUnavailable
 	}
+	defer temporalClient.Close()
 
-	return fallback
-}
+	registry.MustRegister(metrics.NewUpgradesCollector(
+		temporalClient,
+		metrics.UpgradeWorkflow{Type: "UpgradeCluster"},
 
-func loadTLS(logger *slog.Logger) *tls.Config {
-	certFile := lookupEnv("TEMPORAL_TLS_CERT_FILE", "")
-	keyFile := lookupEnv("TEMPORAL_TLS_KEY_FILE", "")
+		// Node group updates started by other workflows run as a child under the rotation ID: count the child only
+		metrics.UpgradeWorkflow{Type: "UpdateNodeGroup", Counted: workflows.IsNodeGroupRotationID},
+	))
 
-	certContent := lookupEnv("TEMPORAL_TLS_CERT", "")
-	keyContent := lookupEnv("TEMPORAL_TLS_KEY", "")
+	httpServer := newHTTPServer(lookupEnv("HTTP_ADDRESS", ":8080"), registry, temporalClient)
 
-	if certFile != "" && keyFile != "" {
-		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-		if err != nil {
-			logger.Error("unable to load TLS files", slog.Any("error", err), slog.String("cert", certFile), slog.String("key", keyFile))
+	httpErrCh := make(chan error, 1)
 
-			os.Exit(1)
-		}
+	go func() {
+		logger.Info("starting HTTP server", slog.String("address", httpServer.Addr))
 
-		return &tls.Config{
-			Certificates: []tls.Certificate{cert},
+		err := httpServer.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			httpErrCh <- err
 		}
-	} else if certContent != "" && keyContent != "" {
-		decodedCertContent, err := base64.StdEncoding.DecodeString(certContent)
-		if err != nil {
-			logger.Error("unable to decode certificate", slog.Any("error", err))
+	}()
+	defer httpServer.Shutdown(context.Background())
 
-			os.Exit(1)
-		}
+	workerOptions := worker.Options{
+		BuildID: buildID(),
+	}
 
-		decodedKeyContent, err := base64.StdEncoding.DecodeString(keyContent)
-		if err != nil {
-			logger.Error("unable to decode key", slog.Any("error", err))
+	if lookupEnv("TEMPORAL_WORKER_VERSIONING", "false") == "true" {
+		if workerOptions.BuildID == "unknown" {
+			logger.Error("worker versioning requires a known version or revision")
 
-			os.Exit(1)
+			return exitCodeConfig
 		}
 
-		cert, err := tls.X509KeyPair(decodedCertContent, decodedKeyContent)
+		workerOptions.UseBuildIDForVersioning = true
+
+		err := registerBuildID(logger, temporalClient, workflows.TaskQueue, workerOptions.BuildID, lookupEnv("TEMPORAL_WORKER_COMPATIBLE_BUILD_ID", ""))
 		if err != nil {
-			logger.Error("unable to load TLS key pair", slog.Any("error", err))
+			logger.Error("unable to register build ID", slog.Any("error", err))
 
-			os.Exit(1)
+			return exitCodeUnavailable
 		}
+	}
+
+	w := worker.New(temporalClient, workflows.TaskQueue, workerOptions)
+
+	workflows.RegisterWorkflows(w)
+	awsactivities.RegisterActivities(w)
+	kubeactivities.RegisterActivities(w)
 
-		return &tls.Config{
-			Certificates: []tls.Certificate{cert},
+	// Stop the worker if the process is interrupted or the HTTP server fails
+	// (returning from run instead of exiting, so deferred shutdowns run)
+	stopCh := make(chan any)
+
+	var httpErr error
+
+	go func() {
+		select {
+		case <-worker.InterruptCh():
+		case httpErr = <-httpErrCh:
 		}
+
+		close(stopCh)
+	}()
+
+	err = w.Run(stopCh)
+	if err != nil {
+		logger.Error("unable to start Worker", slog.Any("error", err))
+
+		return exitCodeError
 	}
 
-	logger.Error("TLS is enabled but no certificate is provided")
+	if httpErr != nil {
+		logger.Error("unable to start HTTP server", slog.Any("error", httpErr))
+
+		return exitCodeError
+	}
 
-	os.Exit(1)
+	return 0
+}
+
+func lookupEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
+
+func loadCodec(logger *slog.Logger) *encryption.Codec {
+	keysDir := lookupEnv("ENCRYPTION_KEYS_DIR", "")
+	keyID := lookupEnv("ENCRYPTION_KEY_ID", "")
+
+	keys, err := encryption.LoadKeys(keysDir)
+	if err != nil {
+		logger.Error("unable to load encryption keys", slog.Any("error", err), slog.String("dir", keysDir))
+
+		os.Exit(exitCodeConfig)
+	}
+
+	codec, err := encryption.NewCodec(keyID, keys)
+	if err != nil {
+		logger.Error("unable to create encryption codec", slog.Any("error", err))
+
+		os.Exit(exitCodeConfig)
+	}
 
-	return nil
+	return codec
 }
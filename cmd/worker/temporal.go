@@ -0,0 +1,13 @@
+package main
+
+// Exit codes of the worker.
+const (
+	// exitCodeError is returned when the worker fails at runtime.
+	exitCodeError = 1
+
+	// exitCodeConfig is returned when the worker configuration is invalid.
+	exitCodeConfig = 2
+
+	// exitCodeUnavailable is returned when Temporal cannot be reached.
+	exitCodeUnavailable = 3
+)
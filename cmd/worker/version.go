@@ -32,3 +32,12 @@ func init() {
 		}
 	}
 }
+
+// buildID identifies the worker deployment for Temporal worker versioning.
+func buildID() string {
+	if version != "unknown" {
+		return version
+	}
+
+	return revision
+}
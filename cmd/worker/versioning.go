@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"time"
+
+	"go.temporal.io/sdk/client"
+)
+
+// registerBuildID makes sure the worker's Build ID is known to Temporal.
+//
+// By default, the Build ID is added as the new default set, so new workflow executions are routed to the new workers,
+// while running executions stay on the workers they were started on.
+// If compatibleBuildID is set, the Build ID is added to the set of that Build ID instead,
+// so running executions are also picked up by the new workers (only do this for compatible changes).
+func registerBuildID(logger *slog.Logger, temporalClient client.Client, taskQueue string, buildID string, compatibleBuildID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	sets, err := temporalClient.GetWorkerBuildIdCompatibility(ctx, &client.GetWorkerBuildIdCompatibilityOptions{
+		TaskQueue: taskQueue,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, set := range sets.Sets {
+		if slices.Contains(set.BuildIDs, buildID) {
+			logger.Info("build ID is already registered", slog.String("buildID", buildID))
+
+			return nil
+		}
+	}
+
+	options := &client.UpdateWorkerBuildIdCompatibilityOptions{
+		TaskQueue: taskQueue,
+		Operation: &client.BuildIDOpAddNewIDInNewDefaultSet{
+			BuildID: buildID,
+		},
+	}
+
+	if compatibleBuildID != "" {
+		options.Operation = &client.BuildIDOpAddNewCompatibleVersion{
+			BuildID:                   buildID,
+			ExistingCompatibleBuildID: compatibleBuildID,
+			MakeSetDefault:            true,
+		}
+	}
+
+	logger.Info("registering build ID", slog.String("buildID", buildID), slog.String("compatibleBuildID", compatibleBuildID))
+
+	return temporalClient.UpdateWorkerBuildIdCompatibility(ctx, options)
+}
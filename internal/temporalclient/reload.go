@@ -0,0 +1,96 @@
+package temporalclient
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader reloads a certificate from disk whenever the underlying files change.
+//
+// This allows rotating certificates (eg. by cert-manager) without restarting the process.
+type certReloader struct {
+	logger   *slog.Logger
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(logger *slog.Logger, certFile string, keyFile string) (*certReloader, error) {
+	r := &certReloader{
+		logger:   logger,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// GetClientCertificate implements [tls.Config.GetClientCertificate].
+func (r *certReloader) GetClientCertificate(_ *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	modTime, err := r.latestModTime()
+	if err != nil {
+		r.logger.Warn("unable to check TLS files, using the previous certificate", slog.Any("error", err))
+
+		return r.cert, nil
+	}
+
+	if modTime.After(r.modTime) {
+		if err := r.reload(); err != nil {
+			r.logger.Warn("unable to reload TLS files, using the previous certificate", slog.Any("error", err))
+
+			return r.cert, nil
+		}
+
+		r.logger.Info("reloaded TLS certificate", slog.String("cert", r.certFile), slog.String("key", r.keyFile))
+	}
+
+	return r.cert, nil
+}
+
+func (r *certReloader) reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.cert = &cert
+	r.modTime = modTime
+
+	return nil
+}
+
+func (r *certReloader) latestModTime() (time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+
+	return certInfo.ModTime(), nil
+}
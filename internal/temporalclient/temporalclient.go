@@ -0,0 +1,209 @@
+// Package temporalclient configures connections to Temporal (shared by the worker and thesisctl).
+package temporalclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"go.temporal.io/sdk/client"
+)
+
+// Options configures the connection to Temporal.
+type Options struct {
+	Address   string
+	Namespace string
+
+	// APIKey authenticates requests to Temporal Cloud (see [APIKeyHeadersProvider]).
+	APIKey string
+
+	// TLS enables TLS (Temporal Cloud requires it when using API keys).
+	TLS        bool
+	TLSOptions TLSOptions
+}
+
+// OptionsFromEnv reads the connection options from the environment (TEMPORAL_* variables).
+func OptionsFromEnv() Options {
+	apiKey := lookupEnv("TEMPORAL_API_KEY", "")
+
+	return Options{
+		Address:    lookupEnv("TEMPORAL_ADDRESS", client.DefaultHostPort),
+		Namespace:  lookupEnv("TEMPORAL_NAMESPACE", "default"),
+		APIKey:     apiKey,
+		TLS:        lookupEnv("TEMPORAL_TLS", strconv.FormatBool(apiKey != "")) == "true",
+		TLSOptions: TLSOptionsFromEnv(),
+	}
+}
+
+// ClientOptions returns Temporal client options connecting to Temporal.
+//
+// Only the connection related options are set: the rest are left to the caller.
+func (o Options) ClientOptions(logger *slog.Logger) (client.Options, error) {
+	options := client.Options{
+		HostPort:  o.Address,
+		Namespace: o.Namespace,
+	}
+
+	if o.TLS {
+		tlsConfig, err := NewTLSConfig(logger, o.TLSOptions)
+		if err != nil {
+			return options, err
+		}
+
+		options.ConnectionOptions.TLS = tlsConfig
+	}
+
+	if o.APIKey != "" {
+		options.HeadersProvider = APIKeyHeadersProvider{
+			APIKey:    o.APIKey,
+			Namespace: o.Namespace,
+		}
+	}
+
+	return options, nil
+}
+
+// Dial connects to Temporal, retrying with an exponential backoff until the timeout expires.
+func Dial(ctx context.Context, logger *slog.Logger, options client.Options, timeout time.Duration) (client.Client, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	backoff := time.Second
+
+	for {
+		temporalClient, err := client.Dial(options)
+		if err == nil {
+			return temporalClient, nil
+		}
+
+		logger.Warn("unable to connect to Temporal, retrying", slog.Any("error", err), slog.Duration("backoff", backoff))
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		case <-time.After(backoff):
+		}
+
+		backoff = min(2*backoff, 30*time.Second)
+	}
+}
+
+// APIKeyHeadersProvider authenticates requests to Temporal Cloud using an API key.
+type APIKeyHeadersProvider struct {
+	APIKey    string
+	Namespace string
+}
+
+func (p APIKeyHeadersProvider) GetHeaders(_ context.Context) (map[string]string, error) {
+	return map[string]string{
+		"authorization":      "Bearer " + p.APIKey,
+		"temporal-namespace": p.Namespace,
+	}, nil
+}
+
+// TLSOptions configures TLS connections to Temporal.
+//
+// Files take precedence over base64 encoded content.
+type TLSOptions struct {
+	CAFile string
+	CA     string
+
+	// CertFile and KeyFile are reloaded automatically when rotated.
+	CertFile string
+	KeyFile  string
+
+	Cert string
+	Key  string
+
+	// ServerName overrides the server name used for TLS verification (SNI).
+	ServerName string
+}
+
+// TLSOptionsFromEnv reads the TLS options from the environment (TEMPORAL_TLS_* variables).
+func TLSOptionsFromEnv() TLSOptions {
+	return TLSOptions{
+		CAFile:     lookupEnv("TEMPORAL_TLS_CA_FILE", ""),
+		CA:         lookupEnv("TEMPORAL_TLS_CA", ""),
+		CertFile:   lookupEnv("TEMPORAL_TLS_CERT_FILE", ""),
+		KeyFile:    lookupEnv("TEMPORAL_TLS_KEY_FILE", ""),
+		Cert:       lookupEnv("TEMPORAL_TLS_CERT", ""),
+		Key:        lookupEnv("TEMPORAL_TLS_KEY", ""),
+		ServerName: lookupEnv("TEMPORAL_TLS_SERVER_NAME", ""),
+	}
+}
+
+// NewTLSConfig returns a TLS configuration for connecting to Temporal.
+func NewTLSConfig(logger *slog.Logger, options TLSOptions) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: options.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if options.CAFile != "" || options.CA != "" {
+		var ca []byte
+		var err error
+
+		if options.CAFile != "" {
+			ca, err = os.ReadFile(options.CAFile)
+		} else {
+			ca, err = base64.StdEncoding.DecodeString(options.CA)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("loading CA bundle: %w", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, errors.New("CA bundle does not contain any valid certificates")
+		}
+	}
+
+	switch {
+	case options.CertFile != "" && options.KeyFile != "":
+		reloader, err := newCertReloader(logger, options.CertFile, options.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS files: %w", err)
+		}
+
+		tlsConfig.GetClientCertificate = reloader.GetClientCertificate
+
+	case options.Cert != "" && options.Key != "":
+		cert, err := base64.StdEncoding.DecodeString(options.Cert)
+		if err != nil {
+			return nil, fmt.Errorf("decoding certificate: %w", err)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(options.Key)
+		if err != nil {
+			return nil, fmt.Errorf("decoding key: %w", err)
+		}
+
+		keyPair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("loading TLS key pair: %w", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{keyPair}
+
+	case options.CertFile != "" || options.KeyFile != "" || options.Cert != "" || options.Key != "":
+		return nil, errors.New("TLS client certificate and key must be provided together")
+	}
+
+	return tlsConfig, nil
+}
+
+func lookupEnv(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
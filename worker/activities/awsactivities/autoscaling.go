@@ -13,3 +13,19 @@ type AutoScaling struct {
 func (a AutoScaling) DetachInstances(ctx context.Context, params *autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
 	return a.Client.DetachInstances(ctx, params)
 }
+
+func (a AutoScaling) DescribeAutoScalingGroups(ctx context.Context, params *autoscaling.DescribeAutoScalingGroupsInput) (*autoscaling.DescribeAutoScalingGroupsOutput, error) {
+	return a.Client.DescribeAutoScalingGroups(ctx, params)
+}
+
+func (a AutoScaling) SetDesiredCapacity(ctx context.Context, params *autoscaling.SetDesiredCapacityInput) (*autoscaling.SetDesiredCapacityOutput, error) {
+	return a.Client.SetDesiredCapacity(ctx, params)
+}
+
+func (a AutoScaling) SuspendProcesses(ctx context.Context, params *autoscaling.SuspendProcessesInput) (*autoscaling.SuspendProcessesOutput, error) {
+	return a.Client.SuspendProcesses(ctx, params)
+}
+
+func (a AutoScaling) ResumeProcesses(ctx context.Context, params *autoscaling.ResumeProcessesInput) (*autoscaling.ResumeProcessesOutput, error) {
+	return a.Client.ResumeProcesses(ctx, params)
+}
@@ -2,23 +2,35 @@ package awsactivities
 
 import (
 	"context"
+	"errors"
 
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/smithy-go"
 	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/worker"
+
+	"github.com/sagikazarmark/thesis/worker/metrics"
 )
 
 // RegisterActivities registers AWS related activities in a Temporal Worker.
 func RegisterActivities(w worker.Worker) {
+	apiOptions := APIOptions()
+
 	// CloudFormation
 	{
 
-		cfg, err := config.LoadDefaultConfig(context.Background())
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithAPIOptions(apiOptions))
 		if err != nil {
 			panic(err)
 		}
@@ -35,6 +47,7 @@ func RegisterActivities(w worker.Worker) {
 		w.RegisterActivity(a.WaitForDeleteStack)
 
 		w.RegisterActivity(a.DescribeStacks)
+		w.RegisterActivity(a.DescribeStacksWithPrefix)
 
 		w.RegisterActivity(a.UpdateStack)
 		w.RegisterActivity(a.WaitForUpdateStack)
@@ -43,7 +56,7 @@ func RegisterActivities(w worker.Worker) {
 	// EKS
 	{
 
-		cfg, err := config.LoadDefaultConfig(context.Background())
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithAPIOptions(apiOptions))
 		if err != nil {
 			panic(err)
 		}
@@ -58,12 +71,21 @@ func RegisterActivities(w worker.Worker) {
 
 		w.RegisterActivity(a.DeleteCluster)
 		w.RegisterActivity(a.WaitForClusterDeleted)
+
+		w.RegisterActivity(a.DescribeCluster)
+		w.RegisterActivity(a.DescribeAddonVersions)
+
+		w.RegisterActivity(a.ListClusters)
+		w.RegisterActivity(a.ListClusterVersions)
+
+		w.RegisterActivity(a.UpdateClusterVersion)
+		w.RegisterActivity(a.WaitForUpdate)
 	}
 
 	// AutoScaling
 	{
 
-		cfg, err := config.LoadDefaultConfig(context.Background())
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithAPIOptions(apiOptions))
 		if err != nil {
 			panic(err)
 		}
@@ -74,12 +96,16 @@ func RegisterActivities(w worker.Worker) {
 		}
 
 		w.RegisterActivity(a.DetachInstances)
+		w.RegisterActivity(a.DescribeAutoScalingGroups)
+		w.RegisterActivity(a.SetDesiredCapacity)
+		w.RegisterActivity(a.SuspendProcesses)
+		w.RegisterActivity(a.ResumeProcesses)
 	}
 
 	// EC2
 	{
 
-		cfg, err := config.LoadDefaultConfig(context.Background())
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithAPIOptions(apiOptions))
 		if err != nil {
 			panic(err)
 		}
@@ -89,9 +115,41 @@ func RegisterActivities(w worker.Worker) {
 			Client: client,
 		}
 
+		w.RegisterActivity(a.DescribeInstanceSummaries)
+
 		w.RegisterActivity(a.TerminateInstances)
 		w.RegisterActivity(a.WaitForInstanceTerminated)
 	}
+
+	// SSM
+	{
+
+		cfg, err := config.LoadDefaultConfig(context.Background(), config.WithAPIOptions(apiOptions))
+		if err != nil {
+			panic(err)
+		}
+		client := ssm.NewFromConfig(cfg)
+
+		a := SSM{
+			Client: client,
+		}
+
+		w.RegisterActivity(a.GetParameter)
+	}
+}
+
+// APIOptions returns middleware that should be added to every AWS client used by activities.
+//
+// The middleware records traces and metrics for AWS API calls.
+func APIOptions() []func(*middleware.Stack) error {
+	return []func(*middleware.Stack) error{
+		func(s *middleware.Stack) error {
+			return s.Initialize.Add(tracing{}, middleware.After)
+		},
+		func(s *middleware.Stack) error {
+			return s.Initialize.Add(errorMetrics{}, middleware.After)
+		},
+	}
 }
 
 type heartbeat struct{}
@@ -107,3 +165,66 @@ func (heartbeat) HandleInitialize(ctx context.Context, in middleware.InitializeI
 
 	return next.HandleInitialize(ctx, in)
 }
+
+type errorMetrics struct{}
+
+func (errorMetrics) ID() string {
+	return "TemporalErrorMetrics"
+}
+
+func (errorMetrics) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+	out middleware.InitializeOutput, metadata middleware.Metadata, err error,
+) {
+	out, metadata, err = next.HandleInitialize(ctx, in)
+	if err != nil && activity.IsActivity(ctx) {
+		errorType := "unknown"
+
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			errorType = apiErr.ErrorCode()
+		} else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			errorType = "canceled"
+		}
+
+		activity.GetMetricsHandler(ctx).WithTags(map[string]string{
+			"service":    awsmiddleware.GetServiceID(ctx),
+			"operation":  awsmiddleware.GetOperationName(ctx),
+			"error_type": errorType,
+		}).Counter(metrics.AWSAPIErrors).Inc(1)
+	}
+
+	return out, metadata, err
+}
+
+type tracing struct{}
+
+func (tracing) ID() string {
+	return "OpenTelemetryTracing"
+}
+
+func (tracing) HandleInitialize(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (
+	out middleware.InitializeOutput, metadata middleware.Metadata, err error,
+) {
+	service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+
+	ctx, span := otel.Tracer("github.com/sagikazarmark/thesis/worker/activities/awsactivities").Start(
+		ctx,
+		service+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("rpc.system", "aws-api"),
+			attribute.String("rpc.service", service),
+			attribute.String("rpc.method", operation),
+			attribute.String("cloud.region", awsmiddleware.GetRegion(ctx)),
+		),
+	)
+	defer span.End()
+
+	out, metadata, err = next.HandleInitialize(ctx, in)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	return out, metadata, err
+}
@@ -3,14 +3,22 @@ package awsactivities
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
 	"github.com/aws/smithy-go/middleware"
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
+
+	"github.com/sagikazarmark/thesis/worker/metrics"
 )
 
+// ErrorTypeNoUpdates is the type of the error returned by [CloudFormation.UpdateStack] when the stack is already up to date.
+const ErrorTypeNoUpdates = "NoUpdates"
+
 type CloudFormation struct {
 	Client *cloudformation.Client
 }
@@ -58,6 +66,11 @@ func (cf CloudFormation) WaitForCreateStack(ctx context.Context, stackName strin
 		StackName: aws.String(stackName),
 	}
 
+	start := time.Now()
+	defer func() {
+		activity.GetMetricsHandler(ctx).WithTags(map[string]string{"operation": "create"}).Timer(metrics.StackWaitDuration).Record(time.Since(start))
+	}()
+
 	return waiter.Wait(ctx, params, info.Deadline.Sub(info.StartedTime))
 }
 
@@ -104,6 +117,11 @@ func (cf CloudFormation) WaitForDeleteStack(ctx context.Context, stackName strin
 		StackName: aws.String(stackName),
 	}
 
+	start := time.Now()
+	defer func() {
+		activity.GetMetricsHandler(ctx).WithTags(map[string]string{"operation": "delete"}).Timer(metrics.StackWaitDuration).Record(time.Since(start))
+	}()
+
 	return waiter.Wait(ctx, params, info.Deadline.Sub(info.StartedTime))
 }
 
@@ -112,6 +130,30 @@ func (cf CloudFormation) DescribeStacks(ctx context.Context, params *cloudformat
 	return cf.Client.DescribeStacks(ctx, params)
 }
 
+// DescribeStacksWithPrefix returns the stacks whose name starts with a prefix.
+//
+// Deleted stacks are not returned.
+func (cf CloudFormation) DescribeStacksWithPrefix(ctx context.Context, prefix string) ([]cftypes.Stack, error) {
+	var stacks []cftypes.Stack
+
+	paginator := cloudformation.NewDescribeStacksPaginator(cf.Client, &cloudformation.DescribeStacksInput{})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, stack := range output.Stacks {
+			if strings.HasPrefix(aws.ToString(stack.StackName), prefix) {
+				stacks = append(stacks, stack)
+			}
+		}
+	}
+
+	return stacks, nil
+}
+
 func (cf CloudFormation) UpdateStack(ctx context.Context, params *cloudformation.UpdateStackInput) (*cloudformation.UpdateStackOutput, error) {
 	if params.ClientRequestToken == nil {
 		info := activity.GetInfo(ctx)
@@ -120,7 +162,12 @@ func (cf CloudFormation) UpdateStack(ctx context.Context, params *cloudformation
 	}
 
 	// TODO: retryable errors
-	return cf.Client.UpdateStack(ctx, params)
+	output, err := cf.Client.UpdateStack(ctx, params)
+	if err != nil && strings.Contains(err.Error(), "No updates are to be performed") {
+		return nil, temporal.NewNonRetryableApplicationError("no updates are to be performed", ErrorTypeNoUpdates, err)
+	}
+
+	return output, err
 }
 
 func (cf CloudFormation) WaitForUpdateStack(ctx context.Context, stackName string) error {
@@ -155,5 +202,10 @@ func (cf CloudFormation) WaitForUpdateStack(ctx context.Context, stackName strin
 		StackName: aws.String(stackName),
 	}
 
+	start := time.Now()
+	defer func() {
+		activity.GetMetricsHandler(ctx).WithTags(map[string]string{"operation": "update"}).Timer(metrics.StackWaitDuration).Record(time.Since(start))
+	}()
+
 	return waiter.Wait(ctx, params, info.Deadline.Sub(info.StartedTime))
 }
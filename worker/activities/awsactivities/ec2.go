@@ -4,6 +4,7 @@ import (
 	"context"
 	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/smithy-go/middleware"
 	"go.temporal.io/sdk/activity"
@@ -13,6 +14,39 @@ type EC2 struct {
 	Client *ec2.Client
 }
 
+// InstanceSummary contains the instance details returned by [EC2.DescribeInstanceSummaries].
+type InstanceSummary struct {
+	ImageID    string
+	LaunchTime time.Time
+}
+
+// DescribeInstanceSummaries returns a summary of the instances matching the input (keyed by instance ID).
+//
+// The full DescribeInstances output is not returned, because it would quickly exceed the payload size limits.
+func (e EC2) DescribeInstanceSummaries(ctx context.Context, params *ec2.DescribeInstancesInput) (map[string]InstanceSummary, error) {
+	summaries := make(map[string]InstanceSummary)
+
+	paginator := ec2.NewDescribeInstancesPaginator(e.Client, params)
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reservation := range output.Reservations {
+			for _, instance := range reservation.Instances {
+				summaries[aws.ToString(instance.InstanceId)] = InstanceSummary{
+					ImageID:    aws.ToString(instance.ImageId),
+					LaunchTime: aws.ToTime(instance.LaunchTime),
+				}
+			}
+		}
+	}
+
+	return summaries, nil
+}
+
 func (e EC2) TerminateInstances(ctx context.Context, params *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
 	return e.Client.TerminateInstances(ctx, params)
 }
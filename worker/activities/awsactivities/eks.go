@@ -2,15 +2,22 @@ package awsactivities
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
 	"github.com/aws/smithy-go/middleware"
 	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/temporal"
 )
 
+// ErrorTypeNotFound is the type of the error returned by [EKS.DescribeCluster] when the cluster does not exist.
+const ErrorTypeNotFound = "NotFound"
+
 type EKS struct {
 	Client *eks.Client
 }
@@ -102,3 +109,164 @@ func (e EKS) WaitForClusterDeleted(ctx context.Context, clusterName string) erro
 
 	return waiter.Wait(ctx, params, info.Deadline.Sub(info.StartedTime))
 }
+
+func (e EKS) DescribeCluster(ctx context.Context, params *eks.DescribeClusterInput) (*eks.DescribeClusterOutput, error) {
+	output, err := e.Client.DescribeCluster(ctx, params)
+
+	var notFoundErr *ekstypes.ResourceNotFoundException
+	if errors.As(err, &notFoundErr) {
+		return nil, temporal.NewNonRetryableApplicationError("cluster not found", ErrorTypeNotFound, err)
+	}
+
+	return output, err
+}
+
+// DescribeAddonVersions returns the versions of the add-ons installed in a cluster (keyed by add-on name).
+//
+// The full DescribeAddon outputs are not returned, because the workflows only need the versions.
+func (e EKS) DescribeAddonVersions(ctx context.Context, clusterName string) (map[string]string, error) {
+	versions := make(map[string]string)
+
+	paginator := eks.NewListAddonsPaginator(e.Client, &eks.ListAddonsInput{
+		ClusterName: aws.String(clusterName),
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, addon := range output.Addons {
+			describeOutput, err := e.Client.DescribeAddon(ctx, &eks.DescribeAddonInput{
+				ClusterName: aws.String(clusterName),
+				AddonName:   aws.String(addon),
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			versions[addon] = aws.ToString(describeOutput.Addon.AddonVersion)
+		}
+	}
+
+	return versions, nil
+}
+
+// ListClusters returns the names of every EKS cluster in the region.
+func (e EKS) ListClusters(ctx context.Context) ([]string, error) {
+	var clusters []string
+
+	paginator := eks.NewListClustersPaginator(e.Client, &eks.ListClustersInput{})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		clusters = append(clusters, output.Clusters...)
+	}
+
+	return clusters, nil
+}
+
+// ClusterVersion describes a Kubernetes version supported by EKS.
+type ClusterVersion struct {
+	Version string
+
+	// Support dates of the version (zero if EKS did not return them).
+	StandardSupportEnd time.Time
+	ExtendedSupportEnd time.Time
+}
+
+// ListClusterVersions returns the Kubernetes versions EKS currently supports (in no particular order) with their support dates.
+func (e EKS) ListClusterVersions(ctx context.Context) ([]ClusterVersion, error) {
+	seen := make(map[string]bool)
+
+	var versions []ClusterVersion
+
+	paginator := eks.NewDescribeClusterVersionsPaginator(e.Client, &eks.DescribeClusterVersionsInput{
+		ClusterType: aws.String("eks"),
+	})
+
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, info := range output.ClusterVersions {
+			version := aws.ToString(info.ClusterVersion)
+
+			if version == "" || seen[version] || info.Status == ekstypes.ClusterVersionStatusUnsupported {
+				continue
+			}
+
+			seen[version] = true
+			versions = append(versions, ClusterVersion{
+				Version:            version,
+				StandardSupportEnd: aws.ToTime(info.EndOfStandardSupportDate),
+				ExtendedSupportEnd: aws.ToTime(info.EndOfExtendedSupportDate),
+			})
+		}
+	}
+
+	return versions, nil
+}
+
+func (e EKS) UpdateClusterVersion(ctx context.Context, params *eks.UpdateClusterVersionInput) (*eks.UpdateClusterVersionOutput, error) {
+	if params.ClientRequestToken == nil {
+		info := activity.GetInfo(ctx)
+
+		params.ClientRequestToken = aws.String(fmt.Sprintf("%s-%s", info.WorkflowExecution.ID, info.ActivityID))
+	}
+
+	return e.Client.UpdateClusterVersion(ctx, params)
+}
+
+// WaitForUpdate waits for a cluster update to complete.
+//
+// EKS provides no waiter for updates, so this one polls the update status and heartbeats in between.
+func (e EKS) WaitForUpdate(ctx context.Context, params *eks.DescribeUpdateInput) error {
+	info := activity.GetInfo(ctx)
+
+	delay := 30 * time.Second
+	if info.HeartbeatTimeout > 0 {
+		// See the waiters above for an explanation
+		delay = min(delay, info.HeartbeatTimeout-min(time.Duration(float64(info.HeartbeatTimeout)*0.2), 5*time.Second))
+	}
+
+	for {
+		output, err := e.Client.DescribeUpdate(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		switch output.Update.Status {
+		case ekstypes.UpdateStatusSuccessful:
+			return nil
+
+		case ekstypes.UpdateStatusFailed, ekstypes.UpdateStatusCancelled:
+			var messages []string
+
+			for _, updateErr := range output.Update.Errors {
+				messages = append(messages, fmt.Sprintf("%s: %s", updateErr.ErrorCode, aws.ToString(updateErr.ErrorMessage)))
+			}
+
+			return temporal.NewNonRetryableApplicationError(
+				fmt.Sprintf("update %s: %s", output.Update.Status, strings.Join(messages, "; ")),
+				"UpdateFailed",
+				nil,
+			)
+		}
+
+		activity.RecordHeartbeat(ctx)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package awsactivities
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+type SSM struct {
+	Client *ssm.Client
+}
+
+func (s SSM) GetParameter(ctx context.Context, params *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	return s.Client.GetParameter(ctx, params)
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/eks"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -47,6 +49,14 @@ func (f *KubeClientFactory) NewClientset(ctx context.Context, clusterName string
 			TLSClientConfig: rest.TLSClientConfig{
 				CAData: ca,
 			},
+			WrapTransport: func(rt http.RoundTripper) http.RoundTripper {
+				return otelhttp.NewTransport(
+					rt,
+					otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+						return fmt.Sprintf("Kubernetes %s %s", r.Method, r.URL.Path)
+					}),
+				)
+			},
 		},
 	)
 }
@@ -84,6 +94,37 @@ func (s ClusterSetup) CreateAuthConfigMap(ctx context.Context, input CreateAuthC
 	return nil
 }
 
+type UpdateAuthConfigMapInput struct {
+	ClusterName          string
+	NodeInstanceRoleARNs []string
+}
+
+// UpdateAuthConfigMap replaces the node instance roles in the aws-auth ConfigMap (eg. after adding or removing node groups).
+func (s ClusterSetup) UpdateAuthConfigMap(ctx context.Context, input UpdateAuthConfigMapInput) error {
+	clientset, err := s.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return err
+	}
+
+	configMap, err := clientset.CoreV1().ConfigMaps("kube-system").Get(ctx, "aws-auth", metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if configMap.Data == nil {
+		configMap.Data = make(map[string]string)
+	}
+
+	configMap.Data["mapRoles"] = s.createAuthConfigMapRoles(input.NodeInstanceRoleARNs)
+
+	_, err = clientset.CoreV1().ConfigMaps("kube-system").Update(ctx, configMap, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func (s ClusterSetup) createAuthConfigMapRoles(nodeInstanceRoleARNs []string) string {
 	var mapRoles string
 
@@ -0,0 +1,308 @@
+package kubeactivities
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/activity"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Reasons of health problems.
+const (
+	HealthProblemUnavailable      = "Unavailable"
+	HealthProblemPending          = "Pending"
+	HealthProblemCrashLoopBackOff = "CrashLoopBackOff"
+	HealthProblemNotReady         = "NotReady"
+)
+
+type Health struct {
+	KubeClientFactory KubeClientFactory
+}
+
+// HealthProblem describes an unhealthy object in a cluster.
+type HealthProblem struct {
+	// Object is the unhealthy object (eg. "Deployment kube-system/coredns").
+	Object string
+
+	// Owner is the controller of the object (if any).
+	// Pods are recreated with a new name when they are evicted, so pod problems are compared by their owner.
+	Owner string `json:",omitempty"`
+
+	Reason  string
+	Message string
+}
+
+func (p HealthProblem) String() string {
+	return fmt.Sprintf("%s: %s", p.Object, p.Message)
+}
+
+// key identifies the problem when comparing problems at different points in time.
+func (p HealthProblem) key() string {
+	subject := p.Object
+	if p.Owner != "" {
+		subject = p.Owner
+	}
+
+	return subject + ":" + p.Reason
+}
+
+type CheckHealthInput struct {
+	ClusterName string
+
+	// StuckPodThreshold is the time after which Pending and crash looping pods are considered unhealthy.
+	StuckPodThreshold time.Duration
+}
+
+type CheckHealthOutput struct {
+	Problems []HealthProblem
+}
+
+// CheckHealth checks whether Deployments, StatefulSets and DaemonSets are at their desired availability,
+// pods are not stuck in Pending or CrashLoopBackOff and nodes are ready.
+func (h Health) CheckHealth(ctx context.Context, input CheckHealthInput) (*CheckHealthOutput, error) {
+	clientset, err := h.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	problems, err := checkHealth(ctx, clientset, input.StuckPodThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CheckHealthOutput{
+		Problems: problems,
+	}, nil
+}
+
+type WaitForHealthyInput struct {
+	ClusterName string
+
+	// StuckPodThreshold is the time after which Pending and crash looping pods are considered unhealthy.
+	StuckPodThreshold time.Duration
+
+	// Ignored problems (eg. the ones that existed before a change) do not make the cluster unhealthy.
+	Ignored []HealthProblem
+
+	// PollInterval defaults to 10 seconds.
+	PollInterval time.Duration
+}
+
+type WaitForHealthyOutput struct {
+	// Problems are the problems left when the activity gave up (empty if the cluster is healthy).
+	Problems []HealthProblem
+}
+
+// WaitForHealthy checks the health of a cluster (see [Health.CheckHealth]) until there are no problems other than the ignored ones.
+//
+// It gives up shortly before the activity deadline and returns the remaining problems instead of an error,
+// so the workflow can decide what to do with an unhealthy cluster.
+func (h Health) WaitForHealthy(ctx context.Context, input WaitForHealthyInput) (*WaitForHealthyOutput, error) {
+	clientset, err := h.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	pollInterval := input.PollInterval
+	if pollInterval == 0 {
+		pollInterval = 10 * time.Second
+	}
+
+	ignored := make(map[string]bool, len(input.Ignored))
+
+	for _, problem := range input.Ignored {
+		ignored[problem.key()] = true
+	}
+
+	deadline := activity.GetInfo(ctx).Deadline
+
+	for {
+		problems, err := checkHealth(ctx, clientset, input.StuckPodThreshold)
+		if err != nil {
+			return nil, err
+		}
+
+		var regressions []HealthProblem
+
+		for _, problem := range problems {
+			if !ignored[problem.key()] {
+				regressions = append(regressions, problem)
+			}
+		}
+
+		if len(regressions) == 0 {
+			return &WaitForHealthyOutput{}, nil
+		}
+
+		// Leave some time to return the problems before the deadline
+		if time.Now().Add(pollInterval + 5*time.Second).After(deadline) {
+			return &WaitForHealthyOutput{
+				Problems: regressions,
+			}, nil
+		}
+
+		activity.RecordHeartbeat(ctx, len(regressions))
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, clientset kubernetes.Interface, stuckPodThreshold time.Duration) ([]HealthProblem, error) {
+	var problems []HealthProblem
+
+	deployments, err := clientset.AppsV1().Deployments(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, deployment := range deployments.Items {
+		desired := replicas(deployment.Spec.Replicas)
+
+		if deployment.Status.AvailableReplicas < desired {
+			problems = append(problems, HealthProblem{
+				Object:  objectName("Deployment", deployment.ObjectMeta),
+				Reason:  HealthProblemUnavailable,
+				Message: fmt.Sprintf("%d/%d replicas available", deployment.Status.AvailableReplicas, desired),
+			})
+		}
+	}
+
+	statefulSets, err := clientset.AppsV1().StatefulSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, statefulSet := range statefulSets.Items {
+		desired := replicas(statefulSet.Spec.Replicas)
+
+		if statefulSet.Status.AvailableReplicas < desired {
+			problems = append(problems, HealthProblem{
+				Object:  objectName("StatefulSet", statefulSet.ObjectMeta),
+				Reason:  HealthProblemUnavailable,
+				Message: fmt.Sprintf("%d/%d replicas available", statefulSet.Status.AvailableReplicas, desired),
+			})
+		}
+	}
+
+	daemonSets, err := clientset.AppsV1().DaemonSets(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, daemonSet := range daemonSets.Items {
+		if daemonSet.Status.NumberAvailable < daemonSet.Status.DesiredNumberScheduled {
+			problems = append(problems, HealthProblem{
+				Object:  objectName("DaemonSet", daemonSet.ObjectMeta),
+				Reason:  HealthProblemUnavailable,
+				Message: fmt.Sprintf("%d/%d pods available", daemonSet.Status.NumberAvailable, daemonSet.Status.DesiredNumberScheduled),
+			})
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods.Items {
+		if problem, ok := podProblem(pod, stuckPodThreshold); ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes.Items {
+		if !isNodeReady(node) {
+			problems = append(problems, HealthProblem{
+				Object:  "Node " + node.Name,
+				Reason:  HealthProblemNotReady,
+				Message: "not ready",
+			})
+		}
+	}
+
+	return problems, nil
+}
+
+// podProblem reports pods stuck in Pending or CrashLoopBackOff for longer than the threshold.
+func podProblem(pod v1.Pod, stuckPodThreshold time.Duration) (HealthProblem, bool) {
+	// Terminating pods are replaced by their controllers
+	if pod.DeletionTimestamp != nil {
+		return HealthProblem{}, false
+	}
+
+	problem := HealthProblem{
+		Object: objectName("Pod", pod.ObjectMeta),
+	}
+
+	if owner := metav1.GetControllerOf(&pod); owner != nil {
+		problem.Owner = fmt.Sprintf("%s %s/%s", owner.Kind, pod.Namespace, owner.Name)
+	}
+
+	now := time.Now()
+
+	if pod.Status.Phase == v1.PodPending && now.Sub(pod.CreationTimestamp.Time) > stuckPodThreshold {
+		problem.Reason = HealthProblemPending
+		problem.Message = fmt.Sprintf("pending since %s", pod.CreationTimestamp.Format(time.RFC3339))
+
+		return problem, true
+	}
+
+	if pod.Status.Phase != v1.PodRunning {
+		return HealthProblem{}, false
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Waiting == nil || status.State.Waiting.Reason != "CrashLoopBackOff" {
+			continue
+		}
+
+		// The pod has not been ready since the container started crashing
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == v1.PodReady && condition.Status != v1.ConditionTrue && now.Sub(condition.LastTransitionTime.Time) > stuckPodThreshold {
+				problem.Reason = HealthProblemCrashLoopBackOff
+				problem.Message = fmt.Sprintf("container %s is crash looping (%d restarts)", status.Name, status.RestartCount)
+
+				return problem, true
+			}
+		}
+	}
+
+	return HealthProblem{}, false
+}
+
+func isNodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func objectName(kind string, meta metav1.ObjectMeta) string {
+	return fmt.Sprintf("%s %s/%s", kind, meta.Namespace, meta.Name)
+}
+
+// replicas returns the desired number of replicas (defaults to 1 if unset).
+func replicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+
+	return *replicas
+}
@@ -8,11 +8,13 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"go.temporal.io/sdk/worker"
 	"sigs.k8s.io/aws-iam-authenticator/pkg/token"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
 )
 
 // RegisterActivities registers AWS related activities in a Temporal Worker.
 func RegisterActivities(w worker.Worker) {
-	cfg, err := config.LoadDefaultConfig(context.Background())
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithAPIOptions(awsactivities.APIOptions()))
 	if err != nil {
 		panic(err)
 	}
@@ -36,6 +38,7 @@ func RegisterActivities(w worker.Worker) {
 		}
 
 		w.RegisterActivity(a.CreateAuthConfigMap)
+		w.RegisterActivity(a.UpdateAuthConfigMap)
 	}
 
 	// Nodes
@@ -46,7 +49,34 @@ func RegisterActivities(w worker.Worker) {
 		}
 
 		w.RegisterActivity(a.ListNodes)
+		w.RegisterActivity(a.CountPods)
 		w.RegisterActivity(a.DeleteNode)
 		w.RegisterActivity(a.DrainNode)
+		w.RegisterActivity(a.UncordonNode)
+		w.RegisterActivity(a.MarkOutdatedNodes)
+		w.RegisterActivity(a.UnmarkOutdatedNodes)
+		w.RegisterActivity(a.DisableScaleDown)
+		w.RegisterActivity(a.EnableScaleDown)
+	}
+
+	// Health
+	{
+
+		a := Health{
+			KubeClientFactory: kubeClientFactory,
+		}
+
+		w.RegisterActivity(a.CheckHealth)
+		w.RegisterActivity(a.WaitForHealthy)
+	}
+
+	// Scheduling
+	{
+
+		a := Scheduling{
+			KubeClientFactory: kubeClientFactory,
+		}
+
+		w.RegisterActivity(a.SimulateDrain)
 	}
 }
@@ -7,8 +7,13 @@ import (
 
 	"go.temporal.io/sdk/activity"
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/kubectl/pkg/drain"
+
+	"github.com/sagikazarmark/thesis/worker/metrics"
 )
 
 type Nodes struct {
@@ -39,6 +44,285 @@ func (n Nodes) ListNodes(ctx context.Context, input ListNodesInput) (*ListNodesO
 	}, nil
 }
 
+type CountPodsInput struct {
+	ClusterName string
+}
+
+type CountPodsOutput struct {
+	// Pods is the number of pods rescheduled by draining a node (keyed by node name).
+	Pods map[string]int
+}
+
+// CountPods counts the pods on every node that would be rescheduled by draining the node
+// (ie. DaemonSet pods, mirror pods and pods without a controller are not counted).
+func (n Nodes) CountPods(ctx context.Context, input CountPodsInput) (*CountPodsOutput, error) {
+	clientset, err := n.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pods := map[string]int{}
+
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+
+		if pod.Spec.NodeName == "" || !isRescheduled(pod) {
+			continue
+		}
+
+		pods[pod.Spec.NodeName]++
+	}
+
+	return &CountPodsOutput{
+		Pods: pods,
+	}, nil
+}
+
+const (
+	// OutdatedNodeTaintKey is the key of the PreferNoSchedule taint marking nodes that are about to be replaced.
+	OutdatedNodeTaintKey = "thesis/outdated"
+
+	// CordonedAnnotation marks nodes cordoned by [Nodes.MarkOutdatedNodes], so that only those are uncordoned later.
+	CordonedAnnotation = "thesis/cordoned"
+)
+
+type MarkOutdatedNodesInput struct {
+	ClusterName string
+	NodeNames   []string
+
+	// Cordon the nodes as well.
+	Cordon bool
+}
+
+type MarkOutdatedNodesOutput struct{}
+
+// MarkOutdatedNodes taints nodes that are about to be replaced (with a PreferNoSchedule taint) and optionally cordons them,
+// so that pods evicted from other nodes do not land on them.
+//
+// Nodes that do not exist anymore are ignored.
+func (n Nodes) MarkOutdatedNodes(ctx context.Context, input MarkOutdatedNodesInput) (*MarkOutdatedNodesOutput, error) {
+	clientset, err := n.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range input.NodeNames {
+		err := updateNode(ctx, clientset, name, func(node *v1.Node) bool {
+			changed := false
+
+			if !hasTaint(node, OutdatedNodeTaintKey) {
+				node.Spec.Taints = append(node.Spec.Taints, v1.Taint{
+					Key:    OutdatedNodeTaintKey,
+					Effect: v1.TaintEffectPreferNoSchedule,
+				})
+
+				changed = true
+			}
+
+			if input.Cordon && !node.Spec.Unschedulable {
+				node.Spec.Unschedulable = true
+
+				if node.Annotations == nil {
+					node.Annotations = map[string]string{}
+				}
+
+				node.Annotations[CordonedAnnotation] = "true"
+
+				changed = true
+			}
+
+			return changed
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		activity.RecordHeartbeat(ctx, name)
+	}
+
+	return &MarkOutdatedNodesOutput{}, nil
+}
+
+type UnmarkOutdatedNodesInput struct {
+	ClusterName string
+	NodeNames   []string
+}
+
+type UnmarkOutdatedNodesOutput struct{}
+
+// UnmarkOutdatedNodes removes the taint added by [Nodes.MarkOutdatedNodes] and uncordons the nodes it cordoned.
+//
+// Nodes that do not exist anymore are ignored.
+func (n Nodes) UnmarkOutdatedNodes(ctx context.Context, input UnmarkOutdatedNodesInput) (*UnmarkOutdatedNodesOutput, error) {
+	clientset, err := n.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range input.NodeNames {
+		err := updateNode(ctx, clientset, name, func(node *v1.Node) bool {
+			changed := false
+
+			var taints []v1.Taint
+
+			for _, taint := range node.Spec.Taints {
+				if taint.Key == OutdatedNodeTaintKey {
+					changed = true
+
+					continue
+				}
+
+				taints = append(taints, taint)
+			}
+
+			node.Spec.Taints = taints
+
+			if _, ok := node.Annotations[CordonedAnnotation]; ok {
+				node.Spec.Unschedulable = false
+				delete(node.Annotations, CordonedAnnotation)
+
+				changed = true
+			}
+
+			return changed
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		activity.RecordHeartbeat(ctx, name)
+	}
+
+	return &UnmarkOutdatedNodesOutput{}, nil
+}
+
+const (
+	// ScaleDownDisabledAnnotation keeps the cluster-autoscaler from removing a node.
+	ScaleDownDisabledAnnotation = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+
+	// scaleDownDisabledByAnnotation marks nodes annotated by [Nodes.DisableScaleDown], so that only those are restored later.
+	scaleDownDisabledByAnnotation = "thesis/scale-down-disabled"
+)
+
+type DisableScaleDownInput struct {
+	ClusterName string
+	NodeNames   []string
+}
+
+type DisableScaleDownOutput struct{}
+
+// DisableScaleDown keeps the cluster-autoscaler from removing nodes (eg. while a node group is being rotated).
+//
+// Nodes that do not exist anymore are ignored.
+func (n Nodes) DisableScaleDown(ctx context.Context, input DisableScaleDownInput) (*DisableScaleDownOutput, error) {
+	clientset, err := n.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range input.NodeNames {
+		err := updateNode(ctx, clientset, name, func(node *v1.Node) bool {
+			// Scale down is already disabled (by someone else or a previous attempt)
+			if node.Annotations[ScaleDownDisabledAnnotation] == "true" {
+				return false
+			}
+
+			if node.Annotations == nil {
+				node.Annotations = map[string]string{}
+			}
+
+			node.Annotations[ScaleDownDisabledAnnotation] = "true"
+			node.Annotations[scaleDownDisabledByAnnotation] = "true"
+
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		activity.RecordHeartbeat(ctx, name)
+	}
+
+	return &DisableScaleDownOutput{}, nil
+}
+
+type EnableScaleDownInput struct {
+	ClusterName string
+	NodeNames   []string
+}
+
+type EnableScaleDownOutput struct{}
+
+// EnableScaleDown reverts [Nodes.DisableScaleDown] (nodes annotated by someone else are left alone).
+//
+// Nodes that do not exist anymore are ignored.
+func (n Nodes) EnableScaleDown(ctx context.Context, input EnableScaleDownInput) (*EnableScaleDownOutput, error) {
+	clientset, err := n.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range input.NodeNames {
+		err := updateNode(ctx, clientset, name, func(node *v1.Node) bool {
+			if _, ok := node.Annotations[scaleDownDisabledByAnnotation]; !ok {
+				return false
+			}
+
+			delete(node.Annotations, ScaleDownDisabledAnnotation)
+			delete(node.Annotations, scaleDownDisabledByAnnotation)
+
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		activity.RecordHeartbeat(ctx, name)
+	}
+
+	return &EnableScaleDownOutput{}, nil
+}
+
+// updateNode applies a change to a node (retrying on conflicts). The change function reports whether the node changed.
+func updateNode(ctx context.Context, clientset kubernetes.Interface, name string, change func(node *v1.Node) bool) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := clientset.CoreV1().Nodes().Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !change(node) {
+			return nil
+		}
+
+		_, err = clientset.CoreV1().Nodes().Update(ctx, node, metav1.UpdateOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+
+		return err
+	})
+}
+
+func hasTaint(node *v1.Node, key string) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
 type DeleteNodeInput struct {
 	ClusterName string
 	NodeName    string
@@ -46,6 +330,7 @@ type DeleteNodeInput struct {
 
 type DeleteNodeOutput struct{}
 
+// DeleteNode deletes a node. Nodes that do not exist anymore are ignored.
 func (n Nodes) DeleteNode(ctx context.Context, input DeleteNodeInput) (*DeleteNodeOutput, error) {
 	clientset, err := n.KubeClientFactory.NewClientset(ctx, input.ClusterName)
 	if err != nil {
@@ -53,13 +338,45 @@ func (n Nodes) DeleteNode(ctx context.Context, input DeleteNodeInput) (*DeleteNo
 	}
 
 	err = clientset.CoreV1().Nodes().Delete(ctx, input.NodeName, metav1.DeleteOptions{})
-	if err != nil {
+	if err != nil && !apierrors.IsNotFound(err) {
 		return nil, err
 	}
 
 	return &DeleteNodeOutput{}, nil
 }
 
+type UncordonNodeInput struct {
+	ClusterName string
+	NodeName    string
+}
+
+type UncordonNodeOutput struct{}
+
+// UncordonNode marks a node schedulable again (eg. after an interrupted drain).
+//
+// Nodes cordoned by [Nodes.MarkOutdatedNodes] are left cordoned. Nodes that do not exist anymore are ignored.
+func (n Nodes) UncordonNode(ctx context.Context, input UncordonNodeInput) (*UncordonNodeOutput, error) {
+	clientset, err := n.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = updateNode(ctx, clientset, input.NodeName, func(node *v1.Node) bool {
+		if _, ok := node.Annotations[CordonedAnnotation]; ok || !node.Spec.Unschedulable {
+			return false
+		}
+
+		node.Spec.Unschedulable = false
+
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UncordonNodeOutput{}, nil
+}
+
 type DrainNodeInput struct {
 	ClusterName string
 	NodeName    string
@@ -108,10 +425,16 @@ func (n Nodes) DrainNode(ctx context.Context, input DrainNodeInput) (*DrainNodeO
 		},
 	}
 
+	start := time.Now()
+
 	err = drain.RunNodeDrain(drainHelper, input.NodeName)
 	if err != nil {
 		return nil, err
 	}
 
+	metricsHandler := activity.GetMetricsHandler(ctx)
+	metricsHandler.Counter(metrics.NodesDrained).Inc(1)
+	metricsHandler.Timer(metrics.NodeDrainDuration).Record(time.Since(start))
+
 	return &DrainNodeOutput{}, nil
 }
@@ -0,0 +1,540 @@
+package kubeactivities
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+type Scheduling struct {
+	KubeClientFactory KubeClientFactory
+}
+
+type SimulateDrainInput struct {
+	ClusterName string
+	NodeName    string
+
+	// UnschedulableNodes are considered cordoned (eg. because they are about to be cordoned).
+	UnschedulableNodes []string
+}
+
+// UnschedulablePod is a pod that would not fit on any of the remaining nodes.
+type UnschedulablePod struct {
+	Pod    string
+	Reason string
+}
+
+func (p UnschedulablePod) String() string {
+	return fmt.Sprintf("%s: %s", p.Pod, p.Reason)
+}
+
+type SimulateDrainOutput struct {
+	// Pods is the number of pods that would be rescheduled.
+	Pods int
+
+	Unschedulable []UnschedulablePod
+}
+
+// SimulateDrain simulates rescheduling the pods of a node onto the remaining schedulable nodes.
+//
+// The simulation accounts for resource requests, node selectors, required node affinities, taints and tolerations,
+// required pod (anti-)affinities and topology spread constraints (that do not allow scheduling if unsatisfied).
+// Pods are placed on the first fitting node (largest pods first), so the result approximates what the scheduler would do.
+//
+// DaemonSet pods, mirror pods and pods without a controller are not rescheduled by a drain, so they are ignored.
+func (s Scheduling) SimulateDrain(ctx context.Context, input SimulateDrainInput) (*SimulateDrainOutput, error) {
+	clientset, err := s.KubeClientFactory.NewClientset(ctx, input.ClusterName)
+	if err != nil {
+		return nil, err
+	}
+
+	nodeList, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	podList, err := clientset.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "status.phase!=Succeeded,status.phase!=Failed",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	state := newSchedulingState(nodeList.Items, input.NodeName, input.UnschedulableNodes)
+
+	var pods []*v1.Pod
+
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+
+		if pod.Spec.NodeName == input.NodeName {
+			if isRescheduled(pod) {
+				pods = append(pods, pod)
+			}
+
+			continue
+		}
+
+		state.addPod(pod)
+	}
+
+	// Place the largest pods first
+	sort.SliceStable(pods, func(i, j int) bool {
+		ri, rj := podRequests(pods[i]), podRequests(pods[j])
+
+		if c := ri.Cpu().Cmp(*rj.Cpu()); c != 0 {
+			return c > 0
+		}
+
+		return ri.Memory().Cmp(*rj.Memory()) > 0
+	})
+
+	output := &SimulateDrainOutput{
+		Pods: len(pods),
+	}
+
+	for _, pod := range pods {
+		if reason, ok := state.schedule(pod); !ok {
+			output.Unschedulable = append(output.Unschedulable, UnschedulablePod{
+				Pod:    pod.Namespace + "/" + pod.Name,
+				Reason: reason,
+			})
+		}
+	}
+
+	return output, nil
+}
+
+// isRescheduled reports whether a pod is recreated on a different node after draining its node.
+func isRescheduled(pod *v1.Pod) bool {
+	if _, ok := pod.Annotations[v1.MirrorPodAnnotationKey]; ok {
+		return false
+	}
+
+	owner := metav1.GetControllerOf(pod)
+
+	return owner != nil && owner.Kind != "DaemonSet"
+}
+
+// simulatedNode is a node with the pods (and resources) assigned to it during the simulation.
+type simulatedNode struct {
+	node        *v1.Node
+	schedulable bool
+
+	requested v1.ResourceList
+	pods      []*v1.Pod
+}
+
+func (n *simulatedNode) add(pod *v1.Pod) {
+	for name, quantity := range podRequests(pod) {
+		current := n.requested[name]
+		current.Add(quantity)
+		n.requested[name] = current
+	}
+
+	n.pods = append(n.pods, pod)
+}
+
+// schedulingState is the simulated state of the nodes remaining after a drain.
+type schedulingState struct {
+	nodes []*simulatedNode
+}
+
+func newSchedulingState(nodes []v1.Node, drainedNode string, unschedulableNodes []string) *schedulingState {
+	state := &schedulingState{}
+
+	for i := range nodes {
+		node := &nodes[i]
+
+		if node.Name == drainedNode {
+			continue
+		}
+
+		state.nodes = append(state.nodes, &simulatedNode{
+			node:        node,
+			schedulable: !node.Spec.Unschedulable && isNodeReady(*node) && !contains(unschedulableNodes, node.Name),
+			requested:   v1.ResourceList{},
+		})
+	}
+
+	sort.Slice(state.nodes, func(i, j int) bool {
+		return state.nodes[i].node.Name < state.nodes[j].node.Name
+	})
+
+	return state
+}
+
+func (s *schedulingState) addPod(pod *v1.Pod) {
+	for _, node := range s.nodes {
+		if node.node.Name == pod.Spec.NodeName {
+			node.add(pod)
+
+			return
+		}
+	}
+}
+
+// schedule places a pod on the first node it fits on.
+//
+// It returns the reason the pod did not fit on the last node otherwise.
+func (s *schedulingState) schedule(pod *v1.Pod) (string, bool) {
+	reason := "no schedulable nodes"
+
+	for _, node := range s.nodes {
+		if !node.schedulable {
+			continue
+		}
+
+		r, ok := s.fits(pod, node)
+		if !ok {
+			reason = fmt.Sprintf("node %s: %s", node.node.Name, r)
+
+			continue
+		}
+
+		node.add(pod)
+
+		return "", true
+	}
+
+	return reason, false
+}
+
+func (s *schedulingState) fits(pod *v1.Pod, node *simulatedNode) (string, bool) {
+	if reason, ok := fitsResources(pod, node); !ok {
+		return reason, false
+	}
+
+	if !matchesNodeSelector(pod, node.node) {
+		return "node selector or affinity does not match", false
+	}
+
+	if taint, ok := untoleratedTaint(pod, node.node); ok {
+		return fmt.Sprintf("untolerated taint %s", taint.ToString()), false
+	}
+
+	if reason, ok := s.fitsPodAffinity(pod, node); !ok {
+		return reason, false
+	}
+
+	if reason, ok := s.fitsTopologySpread(pod, node); !ok {
+		return reason, false
+	}
+
+	return "", true
+}
+
+func fitsResources(pod *v1.Pod, node *simulatedNode) (string, bool) {
+	allocatable := node.node.Status.Allocatable
+
+	if maxPods, ok := allocatable[v1.ResourcePods]; ok && int64(len(node.pods)) >= maxPods.Value() {
+		return "too many pods", false
+	}
+
+	for name, quantity := range podRequests(pod) {
+		if quantity.IsZero() {
+			continue
+		}
+
+		free := allocatable[name]
+		free.Sub(node.requested[name])
+
+		if free.Cmp(quantity) < 0 {
+			return fmt.Sprintf("insufficient %s", name), false
+		}
+	}
+
+	return "", true
+}
+
+// podRequests returns the resources requested by a pod.
+func podRequests(pod *v1.Pod) v1.ResourceList {
+	requests := v1.ResourceList{}
+
+	for _, container := range pod.Spec.Containers {
+		for name, quantity := range container.Resources.Requests {
+			current := requests[name]
+			current.Add(quantity)
+			requests[name] = current
+		}
+	}
+
+	// Init containers run one by one before the other containers
+	for _, container := range pod.Spec.InitContainers {
+		for name, quantity := range container.Resources.Requests {
+			if current, ok := requests[name]; !ok || quantity.Cmp(current) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+
+	for name, quantity := range pod.Spec.Overhead {
+		current := requests[name]
+		current.Add(quantity)
+		requests[name] = current
+	}
+
+	return requests
+}
+
+func matchesNodeSelector(pod *v1.Pod, node *v1.Node) bool {
+	if !labels.SelectorFromSet(pod.Spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false
+	}
+
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return true
+	}
+
+	// Terms are ORed
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		if matchesNodeSelectorTerm(term, node) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchesNodeSelectorTerm(term v1.NodeSelectorTerm, node *v1.Node) bool {
+	if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+		return false
+	}
+
+	for _, requirement := range term.MatchExpressions {
+		value, ok := node.Labels[requirement.Key]
+
+		if !matchesNodeSelectorRequirement(requirement, value, ok) {
+			return false
+		}
+	}
+
+	for _, requirement := range term.MatchFields {
+		// metadata.name is the only supported field
+		if !matchesNodeSelectorRequirement(requirement, node.Name, requirement.Key == "metadata.name") {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesNodeSelectorRequirement(requirement v1.NodeSelectorRequirement, value string, exists bool) bool {
+	switch requirement.Operator {
+	case v1.NodeSelectorOpIn:
+		return exists && contains(requirement.Values, value)
+
+	case v1.NodeSelectorOpNotIn:
+		return !exists || !contains(requirement.Values, value)
+
+	case v1.NodeSelectorOpExists:
+		return exists
+
+	case v1.NodeSelectorOpDoesNotExist:
+		return !exists
+
+	case v1.NodeSelectorOpGt, v1.NodeSelectorOpLt:
+		if !exists || len(requirement.Values) != 1 {
+			return false
+		}
+
+		actual, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+
+		expected, err := strconv.ParseInt(requirement.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+
+		if requirement.Operator == v1.NodeSelectorOpGt {
+			return actual > expected
+		}
+
+		return actual < expected
+	}
+
+	return false
+}
+
+func untoleratedTaint(pod *v1.Pod, node *v1.Node) (v1.Taint, bool) {
+	for _, taint := range node.Spec.Taints {
+		// PreferNoSchedule taints do not prevent scheduling
+		if taint.Effect != v1.TaintEffectNoSchedule && taint.Effect != v1.TaintEffectNoExecute {
+			continue
+		}
+
+		if !toleratesTaint(pod.Spec.Tolerations, taint) {
+			return taint, true
+		}
+	}
+
+	return v1.Taint{}, false
+}
+
+func toleratesTaint(tolerations []v1.Toleration, taint v1.Taint) bool {
+	for _, toleration := range tolerations {
+		if toleration.ToleratesTaint(&taint) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fitsPodAffinity checks the required pod affinity and anti-affinity terms of the pod
+// and the required anti-affinity terms of the pods already in the topology domains of the node.
+func (s *schedulingState) fitsPodAffinity(pod *v1.Pod, node *simulatedNode) (string, bool) {
+	if affinity := pod.Spec.Affinity; affinity != nil {
+		if affinity.PodAffinity != nil {
+			for _, term := range affinity.PodAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+				if !s.existsInDomain(pod, term, node) {
+					return fmt.Sprintf("no pods matching pod affinity in %s", term.TopologyKey), false
+				}
+			}
+		}
+
+		if affinity.PodAntiAffinity != nil {
+			for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+				if s.existsInDomain(pod, term, node) {
+					return fmt.Sprintf("pods matching pod anti-affinity in %s", term.TopologyKey), false
+				}
+			}
+		}
+	}
+
+	// Anti-affinity is symmetric
+	for _, other := range s.nodes {
+		for _, existing := range other.pods {
+			affinity := existing.Spec.Affinity
+			if affinity == nil || affinity.PodAntiAffinity == nil {
+				continue
+			}
+
+			for _, term := range affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution {
+				if sameDomain(term.TopologyKey, node.node, other.node) && matchesAffinityTerm(existing, term, pod) {
+					return fmt.Sprintf("pod anti-affinity of %s/%s", existing.Namespace, existing.Name), false
+				}
+			}
+		}
+	}
+
+	return "", true
+}
+
+// existsInDomain reports whether a pod matching the term exists in the topology domain of the node.
+func (s *schedulingState) existsInDomain(pod *v1.Pod, term v1.PodAffinityTerm, node *simulatedNode) bool {
+	for _, other := range s.nodes {
+		if !sameDomain(term.TopologyKey, node.node, other.node) {
+			continue
+		}
+
+		for _, existing := range other.pods {
+			if matchesAffinityTerm(pod, term, existing) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func sameDomain(topologyKey string, a *v1.Node, b *v1.Node) bool {
+	value, ok := a.Labels[topologyKey]
+
+	return ok && b.Labels[topologyKey] == value
+}
+
+// matchesAffinityTerm reports whether a pod matches an affinity term of the owner pod.
+//
+// Namespace selectors are not evaluated (namespace labels are not available): an empty selector matches every namespace,
+// any other selector is ignored in favor of the namespaces listed in the term.
+func matchesAffinityTerm(owner *v1.Pod, term v1.PodAffinityTerm, pod *v1.Pod) bool {
+	namespaces := term.Namespaces
+
+	allNamespaces := term.NamespaceSelector != nil && len(term.NamespaceSelector.MatchLabels) == 0 && len(term.NamespaceSelector.MatchExpressions) == 0
+
+	if len(namespaces) == 0 && term.NamespaceSelector == nil {
+		namespaces = []string{owner.Namespace}
+	}
+
+	if !allNamespaces && !contains(namespaces, pod.Namespace) {
+		return false
+	}
+
+	return matchesLabelSelector(term.LabelSelector, pod.Labels)
+}
+
+// fitsTopologySpread checks the topology spread constraints of the pod that do not allow scheduling if unsatisfied.
+func (s *schedulingState) fitsTopologySpread(pod *v1.Pod, node *simulatedNode) (string, bool) {
+	for _, constraint := range pod.Spec.TopologySpreadConstraints {
+		if constraint.WhenUnsatisfiable != v1.DoNotSchedule {
+			continue
+		}
+
+		domain, ok := node.node.Labels[constraint.TopologyKey]
+		if !ok {
+			return fmt.Sprintf("missing topology key %s", constraint.TopologyKey), false
+		}
+
+		counts := map[string]int{}
+
+		for _, other := range s.nodes {
+			otherDomain, ok := other.node.Labels[constraint.TopologyKey]
+			if !ok || !other.schedulable || !matchesNodeSelector(pod, other.node) {
+				continue
+			}
+
+			// Empty domains count as well
+			if _, ok := counts[otherDomain]; !ok {
+				counts[otherDomain] = 0
+			}
+
+			for _, existing := range other.pods {
+				if existing.Namespace == pod.Namespace && matchesLabelSelector(constraint.LabelSelector, existing.Labels) {
+					counts[otherDomain]++
+				}
+			}
+		}
+
+		minCount := -1
+
+		for _, count := range counts {
+			if minCount < 0 || count < minCount {
+				minCount = count
+			}
+		}
+
+		if skew := counts[domain] + 1 - minCount; minCount >= 0 && skew > int(constraint.MaxSkew) {
+			return fmt.Sprintf("topology spread in %s (skew %d > %d)", constraint.TopologyKey, skew, constraint.MaxSkew), false
+		}
+	}
+
+	return "", true
+}
+
+func matchesLabelSelector(selector *metav1.LabelSelector, podLabels map[string]string) bool {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false
+	}
+
+	return s.Matches(labels.Set(podLabels))
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,257 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Supported AMI families.
+const (
+	AMIFamilyAL2          = "AL2"
+	AMIFamilyAL2023       = "AL2023"
+	AMIFamilyBottlerocket = "Bottlerocket"
+)
+
+// Supported architectures.
+const (
+	ArchitectureX86_64 = "x86_64"
+	ArchitectureARM64  = "arm64"
+)
+
+// Supported accelerators.
+const (
+	AcceleratorNone   = ""
+	AcceleratorNvidia = "nvidia"
+	AcceleratorNeuron = "neuron"
+)
+
+// AMI selects the machine image of the nodes.
+type AMI struct {
+	// Family defaults to AL2.
+	Family string
+
+	// Architecture defaults to x86_64.
+	Architecture string
+
+	// Accelerator selects an image variant with drivers for accelerated instance types.
+	Accelerator string
+
+	// ID pins the image instead of using the one recommended for the Kubernetes version.
+	ID string
+}
+
+// Default sets default values for fields left empty in the spec.
+func (a *AMI) Default() {
+	if a.Family == "" {
+		a.Family = AMIFamilyAL2
+	}
+
+	if a.Architecture == "" {
+		a.Architecture = ArchitectureX86_64
+	}
+}
+
+func (a AMI) Validate() error {
+	switch a.Family {
+	case AMIFamilyAL2, AMIFamilyAL2023, AMIFamilyBottlerocket:
+	default:
+		return fmt.Errorf("unsupported family: %q", a.Family)
+	}
+
+	switch a.Architecture {
+	case ArchitectureX86_64, ArchitectureARM64:
+	default:
+		return fmt.Errorf("unsupported architecture: %q", a.Architecture)
+	}
+
+	switch a.Accelerator {
+	case AcceleratorNone, AcceleratorNvidia, AcceleratorNeuron:
+	default:
+		return fmt.Errorf("unsupported accelerator: %q", a.Accelerator)
+	}
+
+	// Combinations without a published image
+	switch {
+	case a.Family == AMIFamilyAL2 && a.Architecture == ArchitectureARM64 && a.Accelerator != AcceleratorNone:
+		return errors.New("AL2 accelerated images are only available for x86_64")
+
+	case a.Family == AMIFamilyAL2023 && a.Architecture == ArchitectureARM64 && a.Accelerator == AcceleratorNeuron:
+		return errors.New("AL2023 neuron images are only available for x86_64")
+
+	case a.Family == AMIFamilyBottlerocket && a.Accelerator == AcceleratorNeuron:
+		return errors.New("bottlerocket does not have neuron images")
+	}
+
+	if a.ID != "" && !strings.HasPrefix(a.ID, "ami-") {
+		return fmt.Errorf("invalid ID: %q", a.ID)
+	}
+
+	return nil
+}
+
+// SSMParameter returns the name of the public SSM parameter holding the recommended image ID for a Kubernetes version.
+//
+// See https://docs.aws.amazon.com/eks/latest/userguide/retrieve-ami-id.html
+// and https://docs.aws.amazon.com/eks/latest/userguide/retrieve-ami-id-bottlerocket.html
+func (a AMI) SSMParameter(kubernetesVersion string) string {
+	switch a.Family {
+	case AMIFamilyAL2023:
+		variant := a.Accelerator
+		if variant == AcceleratorNone {
+			variant = "standard"
+		}
+
+		return fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2023/%s/%s/recommended/image_id", kubernetesVersion, a.Architecture, variant)
+
+	case AMIFamilyBottlerocket:
+		variant := "aws-k8s-" + kubernetesVersion
+		if a.Accelerator == AcceleratorNvidia {
+			variant += "-nvidia"
+		}
+
+		return fmt.Sprintf("/aws/service/bottlerocket/%s/%s/latest/image_id", variant, a.Architecture)
+
+	default:
+		image := "amazon-linux-2"
+
+		switch {
+		case a.Accelerator != AcceleratorNone:
+			image = "amazon-linux-2-gpu"
+		case a.Architecture == ArchitectureARM64:
+			image = "amazon-linux-2-arm64"
+		}
+
+		return fmt.Sprintf("/aws/service/eks/optimized-ami/%s/%s/recommended/image_id", kubernetesVersion, image)
+	}
+}
+
+// ControlPlane contains the details of a running control plane nodes need to join the cluster.
+type ControlPlane struct {
+	Endpoint string
+
+	// CertificateAuthority is the base64 encoded CA bundle of the API server.
+	CertificateAuthority string
+
+	ServiceCIDR string
+}
+
+// RenderUserData returns the user data of the nodes in the format of the AMI family.
+//
+// It returns an empty string for AL2: the node group template renders its user data from the bootstrap arguments.
+func (ng NodeGroup) RenderUserData(clusterName string, controlPlane ControlPlane) (string, error) {
+	switch ng.AMI.Family {
+	case AMIFamilyAL2023:
+		return ng.renderNodeConfig(clusterName, controlPlane)
+
+	case AMIFamilyBottlerocket:
+		return ng.renderBottlerocketSettings(clusterName, controlPlane), nil
+
+	default:
+		return "", nil
+	}
+}
+
+// renderNodeConfig renders the nodeadm configuration used by AL2023.
+//
+// See https://awslabs.github.io/amazon-eks-ami/nodeadm/
+func (ng NodeGroup) renderNodeConfig(clusterName string, controlPlane ControlPlane) (string, error) {
+	type nodeConfig struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+		Spec       struct {
+			Cluster struct {
+				Name                 string `json:"name"`
+				APIServerEndpoint    string `json:"apiServerEndpoint"`
+				CertificateAuthority string `json:"certificateAuthority"`
+				CIDR                 string `json:"cidr"`
+			} `json:"cluster"`
+			Kubelet struct {
+				Flags []string `json:"flags,omitempty"`
+			} `json:"kubelet"`
+		} `json:"spec"`
+	}
+
+	config := nodeConfig{
+		APIVersion: "node.eks.aws/v1alpha1",
+		Kind:       "NodeConfig",
+	}
+
+	config.Spec.Cluster.Name = clusterName
+	config.Spec.Cluster.APIServerEndpoint = controlPlane.Endpoint
+	config.Spec.Cluster.CertificateAuthority = controlPlane.CertificateAuthority
+	config.Spec.Cluster.CIDR = controlPlane.ServiceCIDR
+	config.Spec.Kubelet.Flags = ng.kubeletFlags()
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+
+	const boundary = "//"
+
+	var b strings.Builder
+
+	b.WriteString("MIME-Version: 1.0\n")
+	b.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\n\n", boundary))
+	b.WriteString("--" + boundary + "\n")
+	b.WriteString("Content-Type: application/node.eks.aws\n\n")
+	b.Write(data)
+	b.WriteString("\n--" + boundary + "--\n")
+
+	return b.String(), nil
+}
+
+// renderBottlerocketSettings renders the TOML settings used by Bottlerocket.
+//
+// See https://bottlerocket.dev/en/os/latest/api/settings/kubernetes/
+func (ng NodeGroup) renderBottlerocketSettings(clusterName string, controlPlane ControlPlane) string {
+	var b strings.Builder
+
+	b.WriteString("[settings.kubernetes]\n")
+	b.WriteString(fmt.Sprintf("cluster-name = %s\n", strconv.Quote(clusterName)))
+	b.WriteString(fmt.Sprintf("api-server = %s\n", strconv.Quote(controlPlane.Endpoint)))
+	b.WriteString(fmt.Sprintf("cluster-certificate = %s\n", strconv.Quote(controlPlane.CertificateAuthority)))
+
+	if len(ng.Kubernetes.Labels) > 0 {
+		b.WriteString("\n[settings.kubernetes.node-labels]\n")
+
+		for _, key := range sortedKeys(ng.Kubernetes.Labels) {
+			b.WriteString(fmt.Sprintf("%s = %s\n", strconv.Quote(key), strconv.Quote(ng.Kubernetes.Labels[key])))
+		}
+	}
+
+	if len(ng.Kubernetes.Taints) > 0 {
+		b.WriteString("\n[settings.kubernetes.node-taints]\n")
+
+		// Bottlerocket expects a list of "value:effect" pairs for each key
+		taints := make(map[string][]string)
+
+		for _, taint := range ng.Kubernetes.Taints {
+			taints[taint.Key] = append(taints[taint.Key], strconv.Quote(fmt.Sprintf("%s:%s", taint.Value, taint.Effect)))
+		}
+
+		for _, key := range sortedKeys(taints) {
+			b.WriteString(fmt.Sprintf("%s = [%s]\n", strconv.Quote(key), strings.Join(taints[key], ", ")))
+		}
+	}
+
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	// Map iteration order is random, but the result has to be deterministic
+	sort.Strings(keys)
+
+	return keys
+}
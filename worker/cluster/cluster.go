@@ -3,6 +3,9 @@ package cluster
 import (
 	"errors"
 	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 // Cluster describes the desired state of a cluster.
@@ -11,6 +14,9 @@ type Cluster struct {
 	Cloud      Cloud
 	Kubernetes ClusterKubernetes
 
+	// Maintenance restricts when disruptive steps of cluster operations may run.
+	Maintenance Maintenance
+
 	NodeGroups []NodeGroup
 }
 
@@ -27,15 +33,39 @@ func (c Cluster) Validate() error {
 		return fmt.Errorf("kubernetes: %w", err)
 	}
 
+	if err := c.Maintenance.Validate(); err != nil {
+		return fmt.Errorf("maintenance: %w", err)
+	}
+
+	nodeGroups := make(map[string]bool, len(c.NodeGroups))
+
 	for _, ng := range c.NodeGroups {
 		if err := ng.Validate(); err != nil {
 			return fmt.Errorf("node group(%s): %w", ng.Name, err)
 		}
+
+		if nodeGroups[ng.Name] {
+			return fmt.Errorf("node group(%s): duplicate node group", ng.Name)
+		}
+
+		nodeGroups[ng.Name] = true
 	}
 
 	return nil
 }
 
+// Default sets default values for fields left empty in the spec.
+func (c *Cluster) Default() {
+	for i := range c.NodeGroups {
+		// Node groups run the same version as the control plane unless told otherwise
+		if c.NodeGroups[i].Kubernetes.Version == "" {
+			c.NodeGroups[i].Kubernetes.Version = c.Kubernetes.Version
+		}
+
+		c.NodeGroups[i].Default()
+	}
+}
+
 type Cloud struct {
 	RoleARN string
 }
@@ -61,11 +91,47 @@ func (k ClusterKubernetes) Validate() error {
 }
 
 type NodeGroup struct {
-	Name       string
-	KeyName    string
+	Name         string
+	KeyName      string
+	InstanceType string
+	AMI          AMI
+	Scaling      NodeGroupScaling
+	Volume       NodeGroupVolume
+
+	// BootstrapArguments are passed to the EKS bootstrap script as is (AL2 only).
+	// See https://github.com/awslabs/amazon-eks-ami/blob/master/files/bootstrap.sh
+	BootstrapArguments string
+
+	DisableIMDSv1 bool
+
 	Kubernetes NodeGroupKubernetes
 }
 
+// Default sets default values (matching the node group template) for fields left empty in the spec.
+func (ng *NodeGroup) Default() {
+	if ng.InstanceType == "" {
+		ng.InstanceType = "t3.medium"
+	}
+
+	ng.AMI.Default()
+
+	if ng.Scaling == (NodeGroupScaling{}) {
+		ng.Scaling = NodeGroupScaling{
+			MinSize:         1,
+			MaxSize:         4,
+			DesiredCapacity: 3,
+		}
+	}
+
+	if ng.Volume.Size == 0 {
+		ng.Volume.Size = 20
+	}
+
+	if ng.Volume.Type == "" {
+		ng.Volume.Type = "gp2"
+	}
+}
+
 func (ng NodeGroup) Validate() error {
 	if ng.Name == "" {
 		return errors.New("name is required")
@@ -75,15 +141,127 @@ func (ng NodeGroup) Validate() error {
 		return errors.New("key name is required")
 	}
 
+	if ng.InstanceType == "" {
+		return errors.New("instance type is required")
+	}
+
+	if err := ng.AMI.Validate(); err != nil {
+		return fmt.Errorf("ami: %w", err)
+	}
+
+	if ng.BootstrapArguments != "" && ng.AMI.Family != AMIFamilyAL2 {
+		return fmt.Errorf("bootstrap arguments are not supported by the %s AMI family", ng.AMI.Family)
+	}
+
+	if err := ng.Scaling.Validate(); err != nil {
+		return fmt.Errorf("scaling: %w", err)
+	}
+
+	if err := ng.Volume.Validate(); err != nil {
+		return fmt.Errorf("volume: %w", err)
+	}
+
 	if err := ng.Kubernetes.Validate(); err != nil {
 		return fmt.Errorf("kubernetes: %w", err)
 	}
 
+	// Labels and taints are passed to the kubelet in --kubelet-extra-args (and only the last occurrence takes effect)
+	if (len(ng.Kubernetes.Labels) > 0 || len(ng.Kubernetes.Taints) > 0) && strings.Contains(ng.BootstrapArguments, "--kubelet-extra-args") {
+		return errors.New("bootstrap arguments cannot contain --kubelet-extra-args when labels or taints are set")
+	}
+
+	return nil
+}
+
+// RenderBootstrapArguments returns the arguments passed to the EKS bootstrap script (used by AL2),
+// including the kubelet arguments registering the node with its labels and taints.
+func (ng NodeGroup) RenderBootstrapArguments() string {
+	args := ng.BootstrapArguments
+
+	if kubeletFlags := ng.kubeletFlags(); len(kubeletFlags) > 0 {
+		args = strings.TrimSpace(fmt.Sprintf("%s --kubelet-extra-args '%s'", args, strings.Join(kubeletFlags, " ")))
+	}
+
+	return args
+}
+
+// kubeletFlags returns the kubelet flags registering the node with its labels and taints.
+func (ng NodeGroup) kubeletFlags() []string {
+	var flags []string
+
+	if len(ng.Kubernetes.Labels) > 0 {
+		labels := make([]string, 0, len(ng.Kubernetes.Labels))
+
+		for _, key := range sortedKeys(ng.Kubernetes.Labels) {
+			labels = append(labels, fmt.Sprintf("%s=%s", key, ng.Kubernetes.Labels[key]))
+		}
+
+		flags = append(flags, "--node-labels="+strings.Join(labels, ","))
+	}
+
+	if len(ng.Kubernetes.Taints) > 0 {
+		taints := make([]string, 0, len(ng.Kubernetes.Taints))
+
+		for _, taint := range ng.Kubernetes.Taints {
+			taints = append(taints, taint.String())
+		}
+
+		flags = append(flags, "--register-with-taints="+strings.Join(taints, ","))
+	}
+
+	return flags
+}
+
+type NodeGroupScaling struct {
+	MinSize         int
+	MaxSize         int
+	DesiredCapacity int
+}
+
+func (s NodeGroupScaling) Validate() error {
+	if s.MinSize < 0 {
+		return errors.New("min size cannot be negative")
+	}
+
+	if s.MaxSize < 1 {
+		return errors.New("max size must be at least 1")
+	}
+
+	if s.MinSize > s.MaxSize {
+		return errors.New("min size cannot be greater than max size")
+	}
+
+	if s.DesiredCapacity < s.MinSize || s.DesiredCapacity > s.MaxSize {
+		return errors.New("desired capacity must be between min size and max size")
+	}
+
+	return nil
+}
+
+type NodeGroupVolume struct {
+	// Size in GiB.
+	Size int
+	Type string
+}
+
+func (v NodeGroupVolume) Validate() error {
+	if v.Size < 1 {
+		return errors.New("size must be at least 1")
+	}
+
+	switch v.Type {
+	case "gp2", "gp3", "io1", "io2", "st1", "sc1", "standard":
+	default:
+		return fmt.Errorf("unsupported type: %q", v.Type)
+	}
+
 	return nil
 }
 
 type NodeGroupKubernetes struct {
 	Version string
+	Labels  map[string]string
+	Taints  []Taint
 }
 
 func (k NodeGroupKubernetes) Validate() error {
@@ -91,5 +269,85 @@ func (k NodeGroupKubernetes) Validate() error {
 		return errors.New("version is required")
 	}
 
+	for key, value := range k.Labels {
+		if err := validateLabel(key, value); err != nil {
+			return fmt.Errorf("label(%s): %w", key, err)
+		}
+	}
+
+	for _, taint := range k.Taints {
+		if err := taint.Validate(); err != nil {
+			return fmt.Errorf("taint(%s): %w", taint.Key, err)
+		}
+	}
+
 	return nil
 }
+
+func validateLabel(key string, value string) error {
+	if err := validateKeyValue(key, value); err != nil {
+		return err
+	}
+
+	// The kubelet is only allowed to set labels in the kubernetes.io and k8s.io namespaces under a few prefixes
+	// See https://kubernetes.io/docs/reference/access-authn-authz/admission-controllers/#noderestriction
+	if namespace, _, ok := strings.Cut(key, "/"); ok && isInDomain(namespace, "kubernetes.io", "k8s.io") {
+		if !isInDomain(namespace, "kubelet.kubernetes.io", "node.kubernetes.io") {
+			return errors.New("kubelet cannot set labels in the kubernetes.io and k8s.io namespaces (except node.kubernetes.io and kubelet.kubernetes.io)")
+		}
+	}
+
+	return nil
+}
+
+func validateKeyValue(key string, value string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return fmt.Errorf("invalid key: %s", strings.Join(errs, "; "))
+	}
+
+	if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+		return fmt.Errorf("invalid value: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+func isInDomain(namespace string, domains ...string) bool {
+	for _, domain := range domains {
+		if namespace == domain || strings.HasSuffix(namespace, "."+domain) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Taint is registered on every node of a node group.
+type Taint struct {
+	Key    string
+	Value  string
+	Effect string
+}
+
+func (t Taint) Validate() error {
+	if err := validateKeyValue(t.Key, t.Value); err != nil {
+		return err
+	}
+
+	switch t.Effect {
+	case "NoSchedule", "PreferNoSchedule", "NoExecute":
+	default:
+		return fmt.Errorf("unsupported effect: %q", t.Effect)
+	}
+
+	return nil
+}
+
+// String returns the taint in the format accepted by the kubelet.
+func (t Taint) String() string {
+	if t.Value == "" {
+		return fmt.Sprintf("%s:%s", t.Key, t.Effect)
+	}
+
+	return fmt.Sprintf("%s=%s:%s", t.Key, t.Value, t.Effect)
+}
@@ -0,0 +1,174 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	// Embed the time zone database as a fallback for workers without one (eg. in minimal container images).
+	// Workers with a system database load time zones from it, so keep it the same on every worker:
+	// windows are computed in workflow code, which has to be deterministic.
+	_ "time/tzdata"
+)
+
+// maxMaintenanceLookahead limits how far in the future the next maintenance window is searched for.
+const maxMaintenanceLookahead = 366 * 24 * time.Hour
+
+// Maintenance restricts disruptive steps of cluster operations (eg. draining nodes, upgrading the control plane)
+// to maintenance windows outside of freeze periods.
+//
+// Disruptive steps are allowed at any time (outside of freeze periods) if there are no windows.
+type Maintenance struct {
+	// TimeZone is the IANA name of the time zone window schedules are interpreted in.
+	// Defaults to UTC.
+	TimeZone string
+
+	Windows []MaintenanceWindow
+	Freezes []FreezePeriod
+}
+
+// MaintenanceWindow is a recurring period of time disruptive steps are allowed in.
+type MaintenanceWindow struct {
+	// Schedule is a cron expression (minute, hour, day of month, month, day of week) describing when the window opens.
+	Schedule string
+
+	// Duration is the length of the window.
+	Duration time.Duration
+}
+
+// FreezePeriod is a period of time disruptive steps are not allowed in (even inside a maintenance window).
+type FreezePeriod struct {
+	Start time.Time
+	End   time.Time
+
+	Reason string
+}
+
+func (m Maintenance) Validate() error {
+	if _, err := time.LoadLocation(m.TimeZone); err != nil {
+		return fmt.Errorf("invalid time zone: %w", err)
+	}
+
+	for i, window := range m.Windows {
+		if _, err := cron.ParseStandard(window.Schedule); err != nil {
+			return fmt.Errorf("window(%d): invalid schedule: %w", i, err)
+		}
+
+		if window.Duration <= 0 {
+			return fmt.Errorf("window(%d): duration must be positive", i)
+		}
+	}
+
+	for i, freeze := range m.Freezes {
+		if !freeze.End.After(freeze.Start) {
+			return fmt.Errorf("freeze(%d): end must be after start", i)
+		}
+	}
+
+	return nil
+}
+
+// NextWindow returns the next period of time (starting at now at the earliest) disruptive steps are allowed in.
+//
+// The end of the period is zero if it is unbounded.
+func (m Maintenance) NextWindow(now time.Time) (time.Time, time.Time, error) {
+	location, err := time.LoadLocation(m.TimeZone)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+
+	schedules := make([]cron.Schedule, 0, len(m.Windows))
+
+	for _, window := range m.Windows {
+		schedule, err := cron.ParseStandard(window.Schedule)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	t := now.In(location)
+
+	for t.Sub(now) < maxMaintenanceLookahead {
+		if freeze, ok := m.freezeAt(t); ok {
+			t = freeze.End.In(location)
+
+			continue
+		}
+
+		end, next := m.windowAt(t, schedules)
+		if len(schedules) > 0 && end.IsZero() && next.IsZero() {
+			return time.Time{}, time.Time{}, errors.New("maintenance windows never open")
+		}
+
+		if !next.IsZero() {
+			t = next
+
+			continue
+		}
+
+		// Freezes starting during the window cut it short
+		for _, freeze := range m.Freezes {
+			if freeze.Start.After(t) && (end.IsZero() || freeze.Start.Before(end)) {
+				end = freeze.Start
+			}
+		}
+
+		return t, end, nil
+	}
+
+	return time.Time{}, time.Time{}, errors.New("no maintenance window in the next year")
+}
+
+// windowAt returns the end of the window open at t.
+// If no window is open, it returns the time the next window opens instead.
+func (m Maintenance) windowAt(t time.Time, schedules []cron.Schedule) (time.Time, time.Time) {
+	if len(schedules) == 0 {
+		return time.Time{}, time.Time{}
+	}
+
+	var end, next time.Time
+
+	for i, schedule := range schedules {
+		duration := m.Windows[i].Duration
+
+		// The first opening of the window after t-duration is either still open at t or opens after t
+		start := schedule.Next(t.Add(-duration))
+
+		// The schedule never activates (eg. February 30)
+		if start.IsZero() {
+			continue
+		}
+
+		if !start.After(t) {
+			if windowEnd := start.Add(duration); windowEnd.After(end) {
+				end = windowEnd
+			}
+
+			continue
+		}
+
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+
+	if !end.IsZero() {
+		return end, time.Time{}
+	}
+
+	return time.Time{}, next
+}
+
+func (m Maintenance) freezeAt(t time.Time) (FreezePeriod, bool) {
+	for _, freeze := range m.Freezes {
+		if !t.Before(freeze.Start) && t.Before(freeze.End) {
+			return freeze, true
+		}
+	}
+
+	return FreezePeriod{}, false
+}
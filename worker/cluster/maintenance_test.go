@@ -0,0 +1,257 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaintenance_NextWindow(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name        string
+		maintenance Maintenance
+		now         time.Time
+
+		start time.Time
+		end   time.Time
+	}{
+		{
+			name: "no restrictions",
+			now:  now,
+
+			start: now,
+		},
+		{
+			name: "freeze without windows",
+			maintenance: Maintenance{
+				Freezes: []FreezePeriod{
+					{
+						Start: now.Add(-time.Hour),
+						End:   now.Add(48 * time.Hour),
+					},
+				},
+			},
+			now: now,
+
+			start: now.Add(48 * time.Hour),
+		},
+		{
+			name: "upcoming freeze without windows",
+			maintenance: Maintenance{
+				Freezes: []FreezePeriod{
+					{
+						Start: now.Add(time.Hour),
+						End:   now.Add(48 * time.Hour),
+					},
+				},
+			},
+			now: now,
+
+			start: now,
+			end:   now.Add(time.Hour),
+		},
+		{
+			name: "open window",
+			maintenance: Maintenance{
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 9 * * *",
+						Duration: 2 * time.Hour,
+					},
+				},
+			},
+			now: now,
+
+			start: now,
+			end:   time.Date(2026, time.October, 19, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "next window",
+			maintenance: Maintenance{
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 22 * * 1-5",
+						Duration: 4 * time.Hour,
+					},
+				},
+			},
+			now: time.Date(2026, time.October, 17, 10, 0, 0, 0, time.UTC), // Saturday
+
+			start: time.Date(2026, time.October, 19, 22, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, time.October, 20, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "overlapping windows",
+			maintenance: Maintenance{
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 9 * * *",
+						Duration: 2 * time.Hour,
+					},
+					{
+						Schedule: "30 9 * * *",
+						Duration: 3 * time.Hour,
+					},
+				},
+			},
+			now: now,
+
+			start: now,
+			end:   time.Date(2026, time.October, 19, 12, 30, 0, 0, time.UTC),
+		},
+		{
+			name: "time zone",
+			maintenance: Maintenance{
+				TimeZone: "America/New_York",
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 22 * * *",
+						Duration: time.Hour,
+					},
+				},
+			},
+			now: now,
+
+			start: time.Date(2026, time.October, 20, 2, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, time.October, 20, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "daylight saving time",
+			maintenance: Maintenance{
+				TimeZone: "Europe/Budapest",
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 3 * * *",
+						Duration: time.Hour,
+					},
+				},
+			},
+			now: time.Date(2026, time.March, 28, 12, 0, 0, 0, time.UTC),
+
+			// 03:00 CEST (instead of 03:00 CET the day before)
+			start: time.Date(2026, time.March, 29, 1, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, time.March, 29, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "window during freeze",
+			maintenance: Maintenance{
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 9 * * *",
+						Duration: 2 * time.Hour,
+					},
+				},
+				Freezes: []FreezePeriod{
+					{
+						Start: time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+						End:   time.Date(2026, time.October, 20, 10, 0, 0, 0, time.UTC),
+					},
+				},
+			},
+			now: now,
+
+			// The freeze ends during the next window
+			start: time.Date(2026, time.October, 20, 10, 0, 0, 0, time.UTC),
+			end:   time.Date(2026, time.October, 20, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "freeze cutting a window short",
+			maintenance: Maintenance{
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 9 * * *",
+						Duration: 2 * time.Hour,
+					},
+				},
+				Freezes: []FreezePeriod{
+					{
+						Start: time.Date(2026, time.October, 19, 10, 30, 0, 0, time.UTC),
+						End:   time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC),
+					},
+				},
+			},
+			now: now,
+
+			start: now,
+			end:   time.Date(2026, time.October, 19, 10, 30, 0, 0, time.UTC),
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			start, end, err := testCase.maintenance.NextWindow(testCase.now)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !start.Equal(testCase.start) {
+				t.Errorf("expected start %s, got %s", testCase.start, start)
+			}
+
+			if !end.Equal(testCase.end) {
+				t.Errorf("expected end %s, got %s", testCase.end, end)
+			}
+		})
+	}
+}
+
+func TestMaintenance_NextWindow_Never(t *testing.T) {
+	now := time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name        string
+		maintenance Maintenance
+		err         string
+	}{
+		{
+			name: "window never opens",
+			maintenance: Maintenance{
+				Windows: []MaintenanceWindow{
+					{
+						Schedule: "0 0 30 2 *",
+						Duration: time.Hour,
+					},
+				},
+			},
+			err: "maintenance windows never open",
+		},
+		{
+			name: "freeze longer than a year",
+			maintenance: Maintenance{
+				Freezes: []FreezePeriod{
+					{
+						Start: now,
+						End:   now.AddDate(2, 0, 0),
+					},
+				},
+			},
+			err: "no maintenance window in the next year",
+		},
+		{
+			name: "invalid time zone",
+			maintenance: Maintenance{
+				TimeZone: "Mars/Olympus_Mons",
+			},
+			err: "unknown time zone",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			_, _, err := testCase.maintenance.NextWindow(now)
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if !strings.Contains(err.Error(), testCase.err) {
+				t.Errorf("expected error containing %q, got %q", testCase.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package cluster
+
+import (
+	"time"
+)
+
+// VersionSupport describes how long EKS supports a Kubernetes minor version.
+type VersionSupport struct {
+	// StandardSupportEnd is the end of standard support.
+	// Clusters running the version after that are billed for extended support.
+	StandardSupportEnd time.Time
+
+	// ExtendedSupportEnd is the end of extended support.
+	// Clusters running the version after that are upgraded automatically by EKS.
+	ExtendedSupportEnd time.Time
+}
+
+// versionSupport is based on the EKS Kubernetes release calendar.
+//
+// It is only a fallback for versions EKS does not return support dates for (see DescribeClusterVersions),
+// so it is not kept up to date with new versions.
+//
+// See https://docs.aws.amazon.com/eks/latest/userguide/kubernetes-versions.html#kubernetes-release-calendar
+var versionSupport = map[string]VersionSupport{
+	"1.23": {StandardSupportEnd: date(2023, time.October, 11), ExtendedSupportEnd: date(2024, time.October, 11)},
+	"1.24": {StandardSupportEnd: date(2024, time.January, 31), ExtendedSupportEnd: date(2025, time.January, 31)},
+	"1.25": {StandardSupportEnd: date(2024, time.May, 1), ExtendedSupportEnd: date(2025, time.May, 1)},
+	"1.26": {StandardSupportEnd: date(2024, time.June, 11), ExtendedSupportEnd: date(2025, time.June, 11)},
+	"1.27": {StandardSupportEnd: date(2024, time.July, 24), ExtendedSupportEnd: date(2025, time.July, 24)},
+	"1.28": {StandardSupportEnd: date(2024, time.November, 26), ExtendedSupportEnd: date(2025, time.November, 26)},
+	"1.29": {StandardSupportEnd: date(2025, time.March, 23), ExtendedSupportEnd: date(2026, time.March, 23)},
+	"1.30": {StandardSupportEnd: date(2025, time.July, 23), ExtendedSupportEnd: date(2026, time.July, 23)},
+	"1.31": {StandardSupportEnd: date(2025, time.November, 26), ExtendedSupportEnd: date(2026, time.November, 26)},
+	"1.32": {StandardSupportEnd: date(2026, time.March, 23), ExtendedSupportEnd: date(2027, time.March, 23)},
+	"1.33": {StandardSupportEnd: date(2026, time.July, 29), ExtendedSupportEnd: date(2027, time.July, 29)},
+	"1.34": {StandardSupportEnd: date(2026, time.December, 2), ExtendedSupportEnd: date(2027, time.December, 2)},
+}
+
+// VersionCalendarUpdatedAt is the date the release calendar used by [LookupVersionSupport] was last updated.
+var VersionCalendarUpdatedAt = date(2026, time.October, 19)
+
+// LookupVersionSupport returns the EKS support dates of a Kubernetes minor version from the release calendar built into the worker.
+//
+// It returns false if the version is not in the release calendar.
+func LookupVersionSupport(version string) (VersionSupport, bool) {
+	support, ok := versionSupport[version]
+
+	return support, ok
+}
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,204 @@
+// Package encryption implements a Temporal payload codec that encrypts workflow data.
+//
+// Payloads are encrypted using AES-256-GCM with the currently active key.
+// The ID of the key is recorded in the payload metadata, so payloads encrypted with older keys
+// can still be decrypted as long as those keys are available. This allows rotating keys without
+// breaking running workflows: add a new key, make it the active one and only remove the old key
+// once no workflow histories reference it anymore.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+)
+
+const (
+	// MetadataEncodingEncrypted is the encoding of encrypted payloads.
+	MetadataEncodingEncrypted = "binary/encrypted"
+
+	// MetadataEncryptionKeyID is the metadata key holding the ID of the key a payload was encrypted with.
+	MetadataEncryptionKeyID = "encryption-key-id"
+)
+
+// KeySize is the required size of encryption keys (AES-256).
+const KeySize = 32
+
+// Codec is a [converter.PayloadCodec] that encrypts payloads.
+type Codec struct {
+	keyID string
+	keys  map[string][]byte
+}
+
+var _ converter.PayloadCodec = (*Codec)(nil)
+
+// NewCodec returns a new [Codec].
+//
+// Payloads are encrypted with the key identified by keyID.
+// All keys are used for decrypting payloads.
+func NewCodec(keyID string, keys map[string][]byte) (*Codec, error) {
+	if keyID == "" {
+		return nil, errors.New("encryption key ID is required")
+	}
+
+	if _, ok := keys[keyID]; !ok {
+		return nil, fmt.Errorf("encryption key %q not found", keyID)
+	}
+
+	for id, key := range keys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("encryption key %q: invalid key size %d (must be %d bytes)", id, len(key), KeySize)
+		}
+	}
+
+	return &Codec{
+		keyID: keyID,
+		keys:  keys,
+	}, nil
+}
+
+// Encode implements [converter.PayloadCodec].
+func (c *Codec) Encode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+
+	for i, p := range payloads {
+		b, err := p.Marshal()
+		if err != nil {
+			return payloads, err
+		}
+
+		data, err := encrypt(c.keys[c.keyID], b)
+		if err != nil {
+			return payloads, err
+		}
+
+		result[i] = &commonpb.Payload{
+			Metadata: map[string][]byte{
+				converter.MetadataEncoding: []byte(MetadataEncodingEncrypted),
+				MetadataEncryptionKeyID:    []byte(c.keyID),
+			},
+			Data: data,
+		}
+	}
+
+	return result, nil
+}
+
+// Decode implements [converter.PayloadCodec].
+func (c *Codec) Decode(payloads []*commonpb.Payload) ([]*commonpb.Payload, error) {
+	result := make([]*commonpb.Payload, len(payloads))
+
+	for i, p := range payloads {
+		// Only decrypt payloads encrypted by this codec
+		if string(p.Metadata[converter.MetadataEncoding]) != MetadataEncodingEncrypted {
+			result[i] = p
+
+			continue
+		}
+
+		keyID := string(p.Metadata[MetadataEncryptionKeyID])
+
+		key, ok := c.keys[keyID]
+		if !ok {
+			return payloads, fmt.Errorf("encryption key %q not found", keyID)
+		}
+
+		b, err := decrypt(key, p.Data)
+		if err != nil {
+			return payloads, err
+		}
+
+		result[i] = &commonpb.Payload{}
+
+		err = result[i].Unmarshal(b)
+		if err != nil {
+			return payloads, err
+		}
+	}
+
+	return result, nil
+}
+
+func encrypt(key []byte, plaintext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func decrypt(key []byte, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// LoadKeys loads encryption keys from a directory.
+//
+// Every file in the directory is a key: the file name is the key ID, the content is the base64 encoded key.
+// Hidden files are ignored (eg. the internal files of mounted Kubernetes secrets).
+func LoadKeys(dir string) (map[string][]byte, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string][]byte)
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+		if err != nil {
+			return nil, fmt.Errorf("decoding key %q: %w", entry.Name(), err)
+		}
+
+		keys[entry.Name()] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no encryption keys found in %s", dir)
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,231 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	commonpb "go.temporal.io/api/common/v1"
+	"go.temporal.io/sdk/converter"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func TestCodec_RoundTrip(t *testing.T) {
+	testCases := []struct {
+		name   string
+		encode string
+		decode string
+		keys   map[string][]byte
+	}{
+		{
+			name:   "active key",
+			encode: "key-1",
+			decode: "key-1",
+			keys:   map[string][]byte{"key-1": testKey(1)},
+		},
+		{
+			// Payloads encrypted before a key rotation can still be decrypted
+			name:   "rotated key",
+			encode: "key-1",
+			decode: "key-2",
+			keys:   map[string][]byte{"key-1": testKey(1), "key-2": testKey(2)},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			encoder, err := NewCodec(testCase.encode, testCase.keys)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			decoder, err := NewCodec(testCase.decode, testCase.keys)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			payloads, err := converter.GetDefaultDataConverter().ToPayloads("cluster/mark-1", 42, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			encoded, err := encoder.Encode(payloads.GetPayloads())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, payload := range encoded {
+				if encoding := string(payload.GetMetadata()[converter.MetadataEncoding]); encoding != MetadataEncodingEncrypted {
+					t.Errorf("payload %d: expected encoding %q, got %q", i, MetadataEncodingEncrypted, encoding)
+				}
+
+				if keyID := string(payload.GetMetadata()[MetadataEncryptionKeyID]); keyID != testCase.encode {
+					t.Errorf("payload %d: expected key ID %q, got %q", i, testCase.encode, keyID)
+				}
+
+				if bytes.Contains(payload.GetData(), []byte("mark-1")) {
+					t.Errorf("payload %d: data is not encrypted", i)
+				}
+			}
+
+			decoded, err := decoder.Decode(encoded)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var (
+				id     string
+				number int
+				empty  any
+			)
+
+			err = converter.GetDefaultDataConverter().FromPayloads(&commonpb.Payloads{Payloads: decoded}, &id, &number, &empty)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if id != "cluster/mark-1" || number != 42 || empty != nil {
+				t.Errorf("unexpected values after a round-trip: %q, %d, %v", id, number, empty)
+			}
+		})
+	}
+}
+
+func TestCodec_Decode(t *testing.T) {
+	codec, err := NewCodec("key-1", map[string][]byte{"key-1": testKey(1)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("plaintext", func(t *testing.T) {
+		payload, err := converter.GetDefaultDataConverter().ToPayload("plaintext")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		decoded, err := codec.Decode([]*commonpb.Payload{payload})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if decoded[0] != payload {
+			t.Error("plaintext payloads should be left untouched")
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		other, err := NewCodec("key-2", map[string][]byte{"key-2": testKey(2)})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		payload, err := converter.GetDefaultDataConverter().ToPayload("secret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		encoded, err := other.Encode([]*commonpb.Payload{payload})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if _, err := codec.Decode(encoded); err == nil || !strings.Contains(err.Error(), `"key-2" not found`) {
+			t.Errorf("expected a missing key error, got %v", err)
+		}
+	})
+
+	t.Run("tampered data", func(t *testing.T) {
+		payload, err := converter.GetDefaultDataConverter().ToPayload("secret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		encoded, err := codec.Encode([]*commonpb.Payload{payload})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		encoded[0].Data[len(encoded[0].Data)-1] ^= 0xff
+
+		if _, err := codec.Decode(encoded); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
+
+func TestNewCodec(t *testing.T) {
+	testCases := []struct {
+		name  string
+		keyID string
+		keys  map[string][]byte
+		err   string
+	}{
+		{
+			name: "missing key ID",
+			keys: map[string][]byte{"key-1": testKey(1)},
+			err:  "encryption key ID is required",
+		},
+		{
+			name:  "unknown key ID",
+			keyID: "key-2",
+			keys:  map[string][]byte{"key-1": testKey(1)},
+			err:   `encryption key "key-2" not found`,
+		},
+		{
+			name:  "invalid key size",
+			keyID: "key-1",
+			keys:  map[string][]byte{"key-1": testKey(1), "key-0": []byte("short")},
+			err:   `encryption key "key-0": invalid key size 5`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := NewCodec(testCase.keyID, testCase.keys)
+			if err == nil || !strings.Contains(err.Error(), testCase.err) {
+				t.Errorf("expected error containing %q, got %v", testCase.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	files := map[string]string{
+		"key-1": base64.StdEncoding.EncodeToString(testKey(1)) + "\n",
+		"key-2": base64.StdEncoding.EncodeToString(testKey(2)),
+
+		// Internal files of mounted Kubernetes secrets
+		"..data": "ignored",
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keys, err := LoadKeys(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(keys) != 2 || !bytes.Equal(keys["key-1"], testKey(1)) || !bytes.Equal(keys["key-2"], testKey(2)) {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	if _, err := LoadKeys(t.TempDir()); err == nil {
+		t.Error("expected an error for an empty directory")
+	}
+}
@@ -0,0 +1,175 @@
+// Package metrics contains the custom metrics of the project.
+//
+// Metrics are recorded using the Temporal metrics handler (see [activity.GetMetricsHandler]),
+// so they are exported alongside the Temporal SDK metrics.
+//
+// [activity.GetMetricsHandler]: https://pkg.go.dev/go.temporal.io/sdk/activity#GetMetricsHandler
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/client"
+)
+
+const (
+	// NodesDrained is the number of successfully drained nodes.
+	NodesDrained = "thesis_nodes_drained"
+
+	// NodeDrainDuration is the time it takes to drain a node.
+	NodeDrainDuration = "thesis_node_drain_duration"
+
+	// StackWaitDuration is the time spent waiting for CloudFormation stack operations.
+	StackWaitDuration = "thesis_stack_wait_duration"
+
+	// AWSAPIErrors is the number of failed AWS API calls, tagged by service, operation and error type.
+	AWSAPIErrors = "thesis_aws_api_errors"
+
+	// UpgradesInProgress is the number of running upgrade workflows.
+	UpgradesInProgress = "thesis_upgrades_in_progress"
+)
+
+// upgradesCacheTTL is the time the results of visibility queries are reused for.
+const upgradesCacheTTL = 30 * time.Second
+
+// UpgradeWorkflow selects the running workflows of a type counted by [UpgradesCollector].
+type UpgradeWorkflow struct {
+	Type string
+
+	// Counted filters executions by workflow ID (eg. to skip parents starting the same workflow as a child).
+	// Every execution is counted if it's nil.
+	Counted func(workflowID string) bool
+}
+
+// UpgradesCollector collects the number of running upgrade workflows from Temporal.
+//
+// Unlike other metrics, this one is not reported by the workflows themselves:
+// it's queried from Temporal's visibility store, so it reflects every worker and survives restarts.
+// Query results are cached for a short period, so frequent scrapes do not overload the visibility store.
+type UpgradesCollector struct {
+	client    client.Client
+	workflows []UpgradeWorkflow
+	desc      *prometheus.Desc
+
+	mu          sync.Mutex
+	counts      map[string]upgradesCount
+	collectedAt time.Time
+}
+
+type upgradesCount struct {
+	count int64
+	err   error
+}
+
+var _ prometheus.Collector = (*UpgradesCollector)(nil)
+
+// NewUpgradesCollector returns a new [UpgradesCollector].
+func NewUpgradesCollector(client client.Client, workflows ...UpgradeWorkflow) *UpgradesCollector {
+	return &UpgradesCollector{
+		client:    client,
+		workflows: workflows,
+		desc: prometheus.NewDesc(
+			UpgradesInProgress,
+			"Number of running upgrade workflows.",
+			[]string{"workflow_type"},
+			nil,
+		),
+	}
+}
+
+// Describe implements [prometheus.Collector].
+func (c *UpgradesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements [prometheus.Collector].
+func (c *UpgradesCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.counts == nil || time.Since(c.collectedAt) >= upgradesCacheTTL {
+		c.counts = c.countUpgrades()
+		c.collectedAt = time.Now()
+	}
+
+	for _, workflow := range c.workflows {
+		count := c.counts[workflow.Type]
+
+		if count.err != nil {
+			ch <- prometheus.NewInvalidMetric(c.desc, count.err)
+
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, float64(count.count), workflow.Type)
+	}
+}
+
+// countUpgrades queries the number of running workflows of each type.
+//
+// Errors are cached as well, so that an unavailable visibility store is not queried on every scrape.
+func (c *UpgradesCollector) countUpgrades() map[string]upgradesCount {
+	counts := make(map[string]upgradesCount, len(c.workflows))
+
+	for _, workflow := range c.workflows {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+
+		count, err := c.countWorkflow(ctx, workflow)
+
+		cancel()
+
+		counts[workflow.Type] = upgradesCount{
+			count: count,
+			err:   err,
+		}
+	}
+
+	return counts
+}
+
+func (c *UpgradesCollector) countWorkflow(ctx context.Context, workflow UpgradeWorkflow) (int64, error) {
+	query := fmt.Sprintf("WorkflowType = '%s' AND ExecutionStatus = 'Running'", workflow.Type)
+
+	if workflow.Counted == nil {
+		resp, err := c.client.CountWorkflow(ctx, &workflowservice.CountWorkflowExecutionsRequest{
+			Query: query,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		return resp.GetCount(), nil
+	}
+
+	// Visibility queries cannot filter workflow IDs by suffix, so executions are filtered here
+	var (
+		count     int64
+		pageToken []byte
+	)
+
+	for {
+		resp, err := c.client.ListWorkflow(ctx, &workflowservice.ListWorkflowExecutionsRequest{
+			Query:         query,
+			NextPageToken: pageToken,
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		for _, execution := range resp.GetExecutions() {
+			if workflow.Counted(execution.GetExecution().GetWorkflowId()) {
+				count++
+			}
+		}
+
+		pageToken = resp.GetNextPageToken()
+		if len(pageToken) == 0 {
+			return count, nil
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Package spec decodes and encodes cluster specs.
+//
+// Specs are Kubernetes-style documents identified by an apiVersion and a kind.
+// Every API version is converted to (and from) the internal [cluster.Cluster] representation,
+// so converting between API versions goes through it as well.
+package spec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/invopop/jsonschema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+	"github.com/sagikazarmark/thesis/worker/spec/v1alpha1"
+)
+
+// LatestAPIVersion is the API version new specs should be written in.
+const LatestAPIVersion = v1alpha1.APIVersion
+
+type apiVersion struct {
+	// decode returns either a cluster or a node group document.
+	decode func(kind string, data []byte) (any, error)
+	encode func(c cluster.Cluster) any
+
+	kinds map[string]any
+}
+
+// nodeGroupDocument is a node group described in a separate document.
+type nodeGroupDocument struct {
+	cluster   string
+	nodeGroup cluster.NodeGroup
+}
+
+var apiVersions = map[string]apiVersion{
+	v1alpha1.APIVersion: {
+		decode: func(kind string, data []byte) (any, error) {
+			switch kind {
+			case v1alpha1.KindCluster:
+				var c v1alpha1.Cluster
+
+				if err := unmarshalStrict(data, &c); err != nil {
+					return nil, err
+				}
+
+				return c.ConvertTo(), nil
+
+			case v1alpha1.KindNodeGroup:
+				var ng v1alpha1.NodeGroup
+
+				if err := unmarshalStrict(data, &ng); err != nil {
+					return nil, err
+				}
+
+				return nodeGroupDocument{cluster: ng.Metadata.Cluster, nodeGroup: ng.ConvertTo()}, nil
+			}
+
+			return nil, fmt.Errorf("unsupported kind: %q", kind)
+		},
+		encode: func(c cluster.Cluster) any {
+			return v1alpha1.ConvertFrom(c)
+		},
+		kinds: map[string]any{
+			v1alpha1.KindCluster:   v1alpha1.Cluster{},
+			v1alpha1.KindNodeGroup: v1alpha1.NodeGroup{},
+		},
+	},
+}
+
+// APIVersions returns the supported API versions.
+func APIVersions() []string {
+	versions := make([]string, 0, len(apiVersions))
+
+	for version := range apiVersions {
+		versions = append(versions, version)
+	}
+
+	sort.Strings(versions)
+
+	return versions
+}
+
+// Decode decodes a cluster spec from YAML or JSON.
+//
+// The input contains exactly one Cluster document and any number of NodeGroup documents belonging to it.
+// Documents without an apiVersion are decoded in the legacy (unversioned) format: a JSON encoded [cluster.Cluster].
+//
+// Unknown fields are rejected. Defaults are applied and the resulting spec is validated.
+func Decode(r io.Reader) (cluster.Cluster, error) {
+	var (
+		clusters   []cluster.Cluster
+		nodeGroups []nodeGroupDocument
+	)
+
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(r))
+
+	for i := 0; ; i++ {
+		doc, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return cluster.Cluster{}, err
+		}
+
+		obj, err := decodeDocument(doc)
+		if err != nil {
+			return cluster.Cluster{}, fmt.Errorf("document %d: %w", i, err)
+		}
+
+		switch obj := obj.(type) {
+		case cluster.Cluster:
+			clusters = append(clusters, obj)
+
+		case nodeGroupDocument:
+			nodeGroups = append(nodeGroups, obj)
+		}
+	}
+
+	if len(clusters) != 1 {
+		return cluster.Cluster{}, fmt.Errorf("expected exactly one cluster, found %d", len(clusters))
+	}
+
+	c := clusters[0]
+
+	for _, ng := range nodeGroups {
+		if ng.cluster != c.Name {
+			return cluster.Cluster{}, fmt.Errorf("node group %q belongs to unknown cluster %q", ng.nodeGroup.Name, ng.cluster)
+		}
+
+		c.NodeGroups = append(c.NodeGroups, ng.nodeGroup)
+	}
+
+	c.Default()
+
+	if err := c.Validate(); err != nil {
+		return cluster.Cluster{}, err
+	}
+
+	return c, nil
+}
+
+func decodeDocument(doc []byte) (any, error) {
+	data, err := yaml.YAMLToJSON(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Empty documents (eg. comments only)
+	if bytes.Equal(bytes.TrimSpace(data), []byte("null")) {
+		return nil, nil
+	}
+
+	var typeMeta struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+
+	if err := json.Unmarshal(data, &typeMeta); err != nil {
+		return nil, err
+	}
+
+	if typeMeta.APIVersion == "" && typeMeta.Kind == "" {
+		var c cluster.Cluster
+
+		if err := unmarshalStrict(data, &c); err != nil {
+			return nil, err
+		}
+
+		return c, nil
+	}
+
+	version, ok := apiVersions[typeMeta.APIVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported apiVersion: %q", typeMeta.APIVersion)
+	}
+
+	return version.decode(typeMeta.Kind, data)
+}
+
+func unmarshalStrict(data []byte, v any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	return decoder.Decode(v)
+}
+
+// Encode encodes a cluster spec as YAML in the given API version.
+func Encode(w io.Writer, c cluster.Cluster, apiVersion string) error {
+	version, ok := apiVersions[apiVersion]
+	if !ok {
+		return fmt.Errorf("unsupported apiVersion: %q", apiVersion)
+	}
+
+	data, err := yaml.Marshal(version.encode(c))
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+
+	return err
+}
+
+// Schema returns the JSON Schema of a kind in the given API version.
+func Schema(apiVersion string, kind string) (*jsonschema.Schema, error) {
+	version, ok := apiVersions[apiVersion]
+	if !ok {
+		return nil, fmt.Errorf("unsupported apiVersion: %q", apiVersion)
+	}
+
+	obj, ok := version.kinds[kind]
+	if !ok {
+		return nil, fmt.Errorf("unsupported kind: %q", kind)
+	}
+
+	reflector := jsonschema.Reflector{
+		// Reject unknown fields in editors as well
+		AllowAdditionalProperties: false,
+	}
+
+	schema := reflector.Reflect(obj)
+
+	// Kinds share the TypeMeta of their version, but a schema only describes a single kind
+	if definition, ok := schema.Definitions[kind]; ok {
+		if property, ok := definition.Properties.Get("kind"); ok {
+			property.Enum = []any{kind}
+		}
+	}
+
+	schema.Title = fmt.Sprintf("%s (%s)", kind, apiVersion)
+
+	return schema, nil
+}
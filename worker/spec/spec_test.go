@@ -0,0 +1,211 @@
+package spec
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+const testClusterDocument = `apiVersion: thesis/v1alpha1
+kind: Cluster
+metadata:
+  name: mark-1
+spec:
+  cloud:
+    roleARN: arn:aws:iam::123456789012:role/AmazonEKSClusterRole
+  kubernetes:
+    version: "1.27"
+  nodeGroups:
+    - name: ng-1
+      keyName: mark
+      instanceType: t3.medium
+`
+
+const testNodeGroupDocument = `apiVersion: thesis/v1alpha1
+kind: NodeGroup
+metadata:
+  name: ng-2
+  cluster: mark-1
+spec:
+  keyName: mark
+  instanceType: t3.large
+  kubernetes:
+    version: "1.26"
+`
+
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+
+		nodeGroups []string
+		versions   []string
+	}{
+		{
+			name:       "cluster",
+			input:      testClusterDocument,
+			nodeGroups: []string{"ng-1"},
+			versions:   []string{"1.27"},
+		},
+		{
+			name:       "multiple documents",
+			input:      testClusterDocument + "---\n" + testNodeGroupDocument,
+			nodeGroups: []string{"ng-1", "ng-2"},
+			versions:   []string{"1.27", "1.26"},
+		},
+		{
+			name:       "empty documents",
+			input:      "# comment only\n---\n" + testClusterDocument + "---\n",
+			nodeGroups: []string{"ng-1"},
+			versions:   []string{"1.27"},
+		},
+		{
+			name: "legacy JSON",
+			input: `{
+				"Name": "mark-1",
+				"Cloud": {"RoleARN": "arn:aws:iam::123456789012:role/AmazonEKSClusterRole"},
+				"Kubernetes": {"Version": "1.27"},
+				"NodeGroups": [{"Name": "ng-1", "KeyName": "mark", "InstanceType": "t3.medium"}]
+			}`,
+			nodeGroups: []string{"ng-1"},
+			versions:   []string{"1.27"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			c, err := Decode(strings.NewReader(testCase.input))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if c.Name != "mark-1" {
+				t.Errorf("expected cluster name %q, got %q", "mark-1", c.Name)
+			}
+
+			if len(c.NodeGroups) != len(testCase.nodeGroups) {
+				t.Fatalf("expected %d node groups, got %d", len(testCase.nodeGroups), len(c.NodeGroups))
+			}
+
+			for i, ng := range c.NodeGroups {
+				if ng.Name != testCase.nodeGroups[i] {
+					t.Errorf("node group %d: expected name %q, got %q", i, testCase.nodeGroups[i], ng.Name)
+				}
+
+				// Node groups default to the version of the control plane
+				if ng.Kubernetes.Version != testCase.versions[i] {
+					t.Errorf("node group %d: expected version %q, got %q", i, testCase.versions[i], ng.Kubernetes.Version)
+				}
+			}
+		})
+	}
+}
+
+func TestDecode_Invalid(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			name:  "unknown field",
+			input: strings.Replace(testClusterDocument, "  kubernetes:\n    version", "  kubernetes:\n    flavor: vanilla\n    version", 1),
+			err:   `unknown field "flavor"`,
+		},
+		{
+			name:  "unknown legacy field",
+			input: `{"Name": "mark-1", "Flavor": "vanilla"}`,
+			err:   `unknown field "Flavor"`,
+		},
+		{
+			name:  "unsupported API version",
+			input: strings.Replace(testClusterDocument, "thesis/v1alpha1", "thesis/v2", 1),
+			err:   `unsupported apiVersion: "thesis/v2"`,
+		},
+		{
+			name:  "unsupported kind",
+			input: strings.Replace(testClusterDocument, "kind: Cluster", "kind: Fleet", 1),
+			err:   `unsupported kind: "Fleet"`,
+		},
+		{
+			name:  "no cluster",
+			input: testNodeGroupDocument,
+			err:   "expected exactly one cluster, found 0",
+		},
+		{
+			name:  "multiple clusters",
+			input: testClusterDocument + "---\n" + testClusterDocument,
+			err:   "expected exactly one cluster, found 2",
+		},
+		{
+			name:  "node group of another cluster",
+			input: testClusterDocument + "---\n" + strings.Replace(testNodeGroupDocument, "cluster: mark-1", "cluster: mark-2", 1),
+			err:   `node group "ng-2" belongs to unknown cluster "mark-2"`,
+		},
+		{
+			name:  "duplicate node group",
+			input: testClusterDocument + "---\n" + strings.Replace(testNodeGroupDocument, "name: ng-2", "name: ng-1", 1),
+			err:   "duplicate node group",
+		},
+		{
+			name:  "invalid spec",
+			input: strings.Replace(testClusterDocument, "    roleARN: arn:aws:iam::123456789012:role/AmazonEKSClusterRole\n", "    {}\n", 1),
+			err:   "role ARN is required",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			_, err := Decode(strings.NewReader(testCase.input))
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			if !strings.Contains(err.Error(), testCase.err) {
+				t.Errorf("expected error containing %q, got %q", testCase.err, err)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	c, err := Decode(strings.NewReader(testClusterDocument + "---\n" + testNodeGroupDocument))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var buf bytes.Buffer
+
+	if err := Encode(&buf, c, LatestAPIVersion); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("decoding encoded spec: %v", err)
+	}
+
+	if !equalNodeGroups(c.NodeGroups, decoded.NodeGroups) {
+		t.Errorf("node groups changed after a round-trip:\n%+v\n%+v", c.NodeGroups, decoded.NodeGroups)
+	}
+}
+
+func equalNodeGroups(a []cluster.NodeGroup, b []cluster.NodeGroup) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].InstanceType != b[i].InstanceType || a[i].Kubernetes.Version != b[i].Kubernetes.Version {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,186 @@
+package v1alpha1
+
+import (
+	"time"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// ConvertTo converts the cluster to the internal representation.
+func (c Cluster) ConvertTo() cluster.Cluster {
+	out := cluster.Cluster{
+		Name: c.Metadata.Name,
+		Cloud: cluster.Cloud{
+			RoleARN: c.Spec.Cloud.RoleARN,
+		},
+		Kubernetes: cluster.ClusterKubernetes{
+			Version: c.Spec.Kubernetes.Version,
+		},
+	}
+
+	for _, ng := range c.Spec.NodeGroups {
+		out.NodeGroups = append(out.NodeGroups, ng.NodeGroupSpec.convertTo(ng.Name))
+	}
+
+	if m := c.Spec.Maintenance; m != nil {
+		out.Maintenance.TimeZone = m.TimeZone
+
+		for _, window := range m.Windows {
+			out.Maintenance.Windows = append(out.Maintenance.Windows, cluster.MaintenanceWindow{
+				Schedule: window.Schedule,
+				Duration: time.Duration(window.Duration),
+			})
+		}
+
+		for _, freeze := range m.Freezes {
+			out.Maintenance.Freezes = append(out.Maintenance.Freezes, cluster.FreezePeriod{
+				Start:  freeze.Start,
+				End:    freeze.End,
+				Reason: freeze.Reason,
+			})
+		}
+	}
+
+	return out
+}
+
+// ConvertTo converts the node group to the internal representation.
+func (ng NodeGroup) ConvertTo() cluster.NodeGroup {
+	return ng.Spec.convertTo(ng.Metadata.Name)
+}
+
+func (s NodeGroupSpec) convertTo(name string) cluster.NodeGroup {
+	out := cluster.NodeGroup{
+		Name:         name,
+		KeyName:      s.KeyName,
+		InstanceType: s.InstanceType,
+		AMI: cluster.AMI{
+			Family:       s.AMI.Family,
+			Architecture: s.AMI.Architecture,
+			Accelerator:  s.AMI.Accelerator,
+			ID:           s.AMI.ID,
+		},
+		Volume: cluster.NodeGroupVolume{
+			Size: s.Volume.Size,
+			Type: s.Volume.Type,
+		},
+		BootstrapArguments: s.BootstrapArguments,
+		DisableIMDSv1:      s.DisableIMDSv1,
+		Kubernetes: cluster.NodeGroupKubernetes{
+			Version: s.Kubernetes.Version,
+			Labels:  s.Kubernetes.Labels,
+		},
+	}
+
+	if s.Scaling != nil {
+		out.Scaling = cluster.NodeGroupScaling{
+			MinSize:         s.Scaling.MinSize,
+			MaxSize:         s.Scaling.MaxSize,
+			DesiredCapacity: s.Scaling.DesiredCapacity,
+		}
+	}
+
+	for _, taint := range s.Kubernetes.Taints {
+		out.Kubernetes.Taints = append(out.Kubernetes.Taints, cluster.Taint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: taint.Effect,
+		})
+	}
+
+	return out
+}
+
+// ConvertFrom converts a cluster from the internal representation.
+//
+// Node groups are described inline.
+func ConvertFrom(c cluster.Cluster) Cluster {
+	out := Cluster{
+		TypeMeta: TypeMeta{
+			APIVersion: APIVersion,
+			Kind:       KindCluster,
+		},
+		Metadata: ClusterMetadata{
+			Name: c.Name,
+		},
+		Spec: ClusterSpec{
+			Cloud: Cloud{
+				RoleARN: c.Cloud.RoleARN,
+			},
+			Kubernetes: ClusterKubernetes{
+				Version: c.Kubernetes.Version,
+			},
+		},
+	}
+
+	for _, ng := range c.NodeGroups {
+		out.Spec.NodeGroups = append(out.Spec.NodeGroups, ClusterNodeGroup{
+			Name:          ng.Name,
+			NodeGroupSpec: convertNodeGroupSpecFrom(ng),
+		})
+	}
+
+	if m := c.Maintenance; m.TimeZone != "" || len(m.Windows) > 0 || len(m.Freezes) > 0 {
+		out.Spec.Maintenance = &Maintenance{
+			TimeZone: m.TimeZone,
+		}
+
+		for _, window := range m.Windows {
+			out.Spec.Maintenance.Windows = append(out.Spec.Maintenance.Windows, MaintenanceWindow{
+				Schedule: window.Schedule,
+				Duration: Duration(window.Duration),
+			})
+		}
+
+		for _, freeze := range m.Freezes {
+			out.Spec.Maintenance.Freezes = append(out.Spec.Maintenance.Freezes, FreezePeriod{
+				Start:  freeze.Start,
+				End:    freeze.End,
+				Reason: freeze.Reason,
+			})
+		}
+	}
+
+	return out
+}
+
+func convertNodeGroupSpecFrom(ng cluster.NodeGroup) NodeGroupSpec {
+	out := NodeGroupSpec{
+		KeyName:      ng.KeyName,
+		InstanceType: ng.InstanceType,
+		AMI: AMI{
+			Family:       ng.AMI.Family,
+			Architecture: ng.AMI.Architecture,
+			Accelerator:  ng.AMI.Accelerator,
+			ID:           ng.AMI.ID,
+		},
+		Volume: NodeGroupVolume{
+			Size: ng.Volume.Size,
+			Type: ng.Volume.Type,
+		},
+		BootstrapArguments: ng.BootstrapArguments,
+		DisableIMDSv1:      ng.DisableIMDSv1,
+		Kubernetes: NodeGroupKubernetes{
+			Version: ng.Kubernetes.Version,
+			Labels:  ng.Kubernetes.Labels,
+		},
+	}
+
+	if ng.Scaling != (cluster.NodeGroupScaling{}) {
+		out.Scaling = &NodeGroupScaling{
+			MinSize:         ng.Scaling.MinSize,
+			MaxSize:         ng.Scaling.MaxSize,
+			DesiredCapacity: ng.Scaling.DesiredCapacity,
+		}
+	}
+
+	for _, taint := range ng.Kubernetes.Taints {
+		out.Kubernetes.Taints = append(out.Kubernetes.Taints, Taint{
+			Key:    taint.Key,
+			Value:  taint.Value,
+			Effect: taint.Effect,
+		})
+	}
+
+	return out
+}
@@ -0,0 +1,187 @@
+// Package v1alpha1 contains the v1alpha1 version of the cluster spec format.
+package v1alpha1
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/invopop/jsonschema"
+)
+
+const (
+	// APIVersion is the API version of the types in this package.
+	APIVersion = "thesis/v1alpha1"
+
+	KindCluster   = "Cluster"
+	KindNodeGroup = "NodeGroup"
+)
+
+// TypeMeta identifies the version and the kind of a document.
+type TypeMeta struct {
+	APIVersion string `json:"apiVersion" jsonschema:"enum=thesis/v1alpha1"`
+	Kind       string `json:"kind" jsonschema:"enum=Cluster,enum=NodeGroup"`
+}
+
+// Cluster describes the desired state of a cluster.
+type Cluster struct {
+	TypeMeta `json:",inline"`
+
+	Metadata ClusterMetadata `json:"metadata"`
+	Spec     ClusterSpec     `json:"spec"`
+}
+
+type ClusterMetadata struct {
+	Name string `json:"name"`
+}
+
+type ClusterSpec struct {
+	Cloud      Cloud             `json:"cloud"`
+	Kubernetes ClusterKubernetes `json:"kubernetes"`
+
+	// NodeGroups can also be described in separate NodeGroup documents.
+	NodeGroups []ClusterNodeGroup `json:"nodeGroups,omitempty"`
+
+	// Maintenance restricts disruptive steps (eg. draining nodes) to maintenance windows.
+	Maintenance *Maintenance `json:"maintenance,omitempty"`
+}
+
+type Cloud struct {
+	RoleARN string `json:"roleARN"`
+}
+
+type ClusterKubernetes struct {
+	Version string `json:"version"`
+}
+
+type Maintenance struct {
+	// TimeZone is the IANA name of the time zone window schedules are interpreted in (defaults to UTC).
+	TimeZone string `json:"timeZone,omitempty"`
+
+	Windows []MaintenanceWindow `json:"windows,omitempty"`
+	Freezes []FreezePeriod      `json:"freezes,omitempty"`
+}
+
+type MaintenanceWindow struct {
+	// Schedule is a cron expression describing when the window opens (eg. "0 2 * * 1-5").
+	Schedule string   `json:"schedule"`
+	Duration Duration `json:"duration"`
+}
+
+// FreezePeriod is a period of time disruptive steps are not allowed in (even inside a maintenance window).
+type FreezePeriod struct {
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Duration is a [time.Duration] encoded as a string (eg. "4h30m").
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(duration)
+
+	return nil
+}
+
+func (Duration) JSONSchema() *jsonschema.Schema {
+	return &jsonschema.Schema{
+		Type:    "string",
+		Pattern: `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+	}
+}
+
+// ClusterNodeGroup is a node group described inline in a [Cluster].
+type ClusterNodeGroup struct {
+	Name string `json:"name"`
+
+	NodeGroupSpec `json:",inline"`
+}
+
+// NodeGroup describes the desired state of a node group.
+type NodeGroup struct {
+	TypeMeta `json:",inline"`
+
+	Metadata NodeGroupMetadata `json:"metadata"`
+	Spec     NodeGroupSpec     `json:"spec"`
+}
+
+type NodeGroupMetadata struct {
+	Name string `json:"name"`
+
+	// Cluster is the name of the cluster the node group belongs to.
+	Cluster string `json:"cluster"`
+}
+
+type NodeGroupSpec struct {
+	KeyName string `json:"keyName"`
+
+	// InstanceType defaults to t3.medium.
+	InstanceType string `json:"instanceType,omitempty"`
+
+	// AMI defaults to the x86_64 AL2 image recommended for the Kubernetes version.
+	AMI AMI `json:"ami,omitempty"`
+
+	// Scaling defaults to 1-4 nodes with a desired capacity of 3.
+	Scaling *NodeGroupScaling `json:"scaling,omitempty"`
+
+	// Volume defaults to a 20 GiB gp2 volume.
+	Volume NodeGroupVolume `json:"volume,omitempty"`
+
+	// BootstrapArguments are passed to the EKS bootstrap script as is (AL2 only).
+	BootstrapArguments string `json:"bootstrapArguments,omitempty"`
+
+	DisableIMDSv1 bool `json:"disableIMDSv1,omitempty"`
+
+	// Kubernetes defaults to the settings of the control plane.
+	Kubernetes NodeGroupKubernetes `json:"kubernetes,omitempty"`
+}
+
+type AMI struct {
+	Family       string `json:"family,omitempty" jsonschema:"enum=AL2,enum=AL2023,enum=Bottlerocket"`
+	Architecture string `json:"architecture,omitempty" jsonschema:"enum=x86_64,enum=arm64"`
+
+	// Accelerator selects an image variant with drivers for accelerated instance types.
+	Accelerator string `json:"accelerator,omitempty" jsonschema:"enum=nvidia,enum=neuron"`
+
+	// ID pins the image instead of using the one recommended for the Kubernetes version.
+	ID string `json:"id,omitempty" jsonschema:"pattern=^ami-"`
+}
+
+type NodeGroupScaling struct {
+	MinSize         int `json:"minSize" jsonschema:"minimum=0"`
+	MaxSize         int `json:"maxSize" jsonschema:"minimum=1"`
+	DesiredCapacity int `json:"desiredCapacity" jsonschema:"minimum=0"`
+}
+
+type NodeGroupVolume struct {
+	// Size in GiB.
+	Size int    `json:"size,omitempty" jsonschema:"minimum=1"`
+	Type string `json:"type,omitempty" jsonschema:"enum=gp2,enum=gp3,enum=io1,enum=io2,enum=st1,enum=sc1,enum=standard"`
+}
+
+type NodeGroupKubernetes struct {
+	Version string            `json:"version,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Taints  []Taint           `json:"taints,omitempty"`
+}
+
+type Taint struct {
+	Key    string `json:"key"`
+	Value  string `json:"value,omitempty"`
+	Effect string `json:"effect" jsonschema:"enum=NoSchedule,enum=PreferNoSchedule,enum=NoExecute"`
+}
@@ -0,0 +1,120 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+)
+
+// SignalApproval is the name of the signal approving (or rejecting) a workflow waiting at an approval gate.
+const SignalApproval = "approval"
+
+// DefaultApprovalTimeout is the default time a workflow waits for an approval before failing.
+const DefaultApprovalTimeout = 24 * time.Hour
+
+// ApprovalGateCanary is the approval gate after the canary node of a node group proved healthy.
+const ApprovalGateCanary = "canary"
+
+// errApprovalRejected is returned when a change is rejected at an approval gate.
+var errApprovalRejected = errors.New("rejected")
+
+// nodeGroupApprovalGate returns the approval gate before updating a node group.
+func nodeGroupApprovalGate(nodeGroupName string) string {
+	return "nodegroup/" + nodeGroupName
+}
+
+// Approval is the payload of [SignalApproval].
+//
+// Signals are recorded in the workflow history, so the history serves as an audit log of approvals.
+type Approval struct {
+	// Gate is the approval gate the workflow is waiting at (see [Status.ApprovalGate]).
+	// Approvals for other gates are ignored.
+	Gate string
+
+	// Approved is false if the change is rejected.
+	Approved bool
+
+	// Approver identifies the person approving (or rejecting) the change.
+	Approver string
+
+	Comment string
+}
+
+// waitForApproval blocks until the gate is approved.
+//
+// Approvals received before reaching the gate are discarded.
+// It returns an error if the gate is rejected or no decision arrives before the timeout.
+func waitForApproval(ctx workflow.Context, gate string, timeout time.Duration, status *Status) error {
+	logger := workflow.GetLogger(ctx)
+
+	logger.Info("waiting for approval", "gate", gate, "timeout", timeout)
+
+	status.ApprovalGate = gate
+	defer func() {
+		status.ApprovalGate = ""
+	}()
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	timer := workflow.NewTimer(timerCtx, timeout)
+	approvalCh := workflow.GetSignalChannel(ctx, SignalApproval)
+
+	// Only decisions made while the workflow is waiting at the gate count:
+	// approvals sent earlier (eg. before the canary proved healthy) are discarded
+	for {
+		var approval Approval
+
+		if !approvalCh.ReceiveAsync(&approval) {
+			break
+		}
+
+		logger.Warn("discarding approval sent before reaching the gate", "gate", approval.Gate, "approver", approval.Approver)
+	}
+
+	for {
+		var (
+			approval Approval
+			timedOut bool
+		)
+
+		selector := workflow.NewSelector(ctx)
+
+		selector.AddReceive(approvalCh, func(c workflow.ReceiveChannel, _ bool) {
+			c.Receive(ctx, &approval)
+		})
+
+		selector.AddFuture(timer, func(f workflow.Future) {
+			timedOut = true
+		})
+
+		selector.Select(ctx)
+
+		if timedOut {
+			// Timer may be cancelled together with the workflow
+			if err := timer.Get(ctx, nil); err != nil {
+				return err
+			}
+
+			return fmt.Errorf("no approval for %s in %s", gate, timeout)
+		}
+
+		if approval.Gate != gate {
+			logger.Warn("ignoring approval for a different gate", "gate", approval.Gate, "approver", approval.Approver)
+
+			continue
+		}
+
+		if !approval.Approved {
+			logger.Info("rejected", "gate", gate, "approver", approval.Approver, "comment", approval.Comment)
+
+			return fmt.Errorf("%s %w by %s: %s", gate, errApprovalRejected, approval.Approver, approval.Comment)
+		}
+
+		logger.Info("approved", "gate", gate, "approver", approval.Approver, "comment", approval.Comment)
+
+		return nil
+	}
+}
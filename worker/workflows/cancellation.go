@@ -0,0 +1,233 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+const (
+	// InFlightOutcomeKept means the interrupted node was not drained yet and kept running.
+	InFlightOutcomeKept = "kept"
+
+	// InFlightOutcomeUncordoned means the interrupted node was uncordoned and kept running.
+	InFlightOutcomeUncordoned = "uncordoned"
+
+	// InFlightOutcomeTerminated means the rotation of the interrupted node was completed by terminating its instance.
+	InFlightOutcomeTerminated = "terminated"
+)
+
+// CancellationReport describes the state a cancelled workflow rotating nodes left the node group in.
+//
+// Cancelled workflows return a canceled error with the report in its details.
+type CancellationReport struct {
+	// RotatedNodes is the number of nodes rotated before the cancellation (including the interrupted node if it was terminated).
+	RotatedNodes int
+
+	// RemainingNodes are the nodes that were not rotated.
+	RemainingNodes []string `json:",omitempty"`
+
+	// InFlight is the node rotation interrupted by the cancellation (if any).
+	InFlight *InFlightRotation `json:",omitempty"`
+
+	// InFlightOutcome is what happened to the interrupted node (see InFlightOutcome constants).
+	InFlightOutcome string `json:",omitempty"`
+
+	// ResumedProcesses are the auto scaling processes resumed after the cancellation.
+	ResumedProcesses []string `json:",omitempty"`
+
+	// Errors are the cleanup steps that failed. The changes they were supposed to revert are left in place.
+	Errors []string `json:",omitempty"`
+}
+
+// releaseNodeGroupOnFailure cleans up after a workflow rotating nodes failed or was cancelled:
+// the interrupted node rotation is brought to a consistent state (see [recoverInFlightRotation])
+// and the node group is released (see [releaseNodeGroup]).
+//
+// Cancelled workflows return a canceled error with a [CancellationReport] in its details,
+// other errors are returned as is.
+//
+// Defer it in workflows rotating nodes.
+func releaseNodeGroupOnFailure(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, err error) error {
+	if err == nil || workflow.IsContinueAsNewError(err) || checkpoint == nil {
+		return err
+	}
+
+	cancelled := errors.Is(ctx.Err(), workflow.ErrCanceled)
+
+	// Clean up even if the workflow was cancelled
+	ctx, cancel := workflow.NewDisconnectedContext(ctx)
+	defer cancel()
+
+	logger := workflow.GetLogger(ctx)
+
+	report := CancellationReport{
+		InFlight: checkpoint.InFlight,
+	}
+
+	fail := func(step string, err error) {
+		logger.Error("cleanup failed", "step", step, "error", err)
+
+		report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", step, err))
+	}
+
+	if inFlight := checkpoint.InFlight; inFlight != nil {
+		outcome, err := recoverInFlightRotation(ctx, clusterName, checkpoint)
+		if err != nil {
+			fail(fmt.Sprintf("recovering node %s (interrupted while %s)", inFlight.Node.Name, inFlight.Step), err)
+		}
+
+		report.InFlightOutcome = outcome
+	}
+
+	if err := unmarkOutdatedNodes(ctx, clusterName, checkpoint); err != nil {
+		fail("unmarking outdated nodes", err)
+	}
+
+	if suspension := checkpoint.ScalingSuspension; suspension != nil {
+		if err := resumeScaling(ctx, clusterName, checkpoint); err != nil {
+			fail("resuming auto scaling", err)
+		} else {
+			report.ResumedProcesses = suspension.Processes
+		}
+	}
+
+	report.RotatedNodes = checkpoint.RotatedNodes
+	report.RemainingNodes = rotationNodeNames(checkpoint.Nodes)
+
+	logger.Info(
+		"node group released",
+		"cancelled", cancelled,
+		"rotatedNodes", report.RotatedNodes,
+		"remainingNodes", report.RemainingNodes,
+		"inFlightOutcome", report.InFlightOutcome,
+		"resumedProcesses", report.ResumedProcesses,
+		"errors", len(report.Errors),
+	)
+
+	if !cancelled {
+		return err
+	}
+
+	return temporal.NewCanceledError(report)
+}
+
+// recoverInFlightRotation brings an interrupted node rotation (if any) to a consistent state.
+//
+// Nodes interrupted before draining are kept as they are, nodes interrupted while draining still run (some of) their pods,
+// so they are uncordoned. In both cases, the surge node added for the rotation (if any) is removed (see [revertSurge]).
+// Nodes already deleted from the cluster cannot come back, so the rotation is completed by terminating their instance
+// (detaching it from the auto scaling group first if necessary) and they are recorded as rotated.
+//
+// It returns the outcome (see InFlightOutcome constants).
+func recoverInFlightRotation(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint) (string, error) {
+	var nodeactivities kubeactivities.Nodes
+
+	inFlight := checkpoint.InFlight
+	if inFlight == nil {
+		return "", nil
+	}
+
+	logger := workflow.GetLogger(ctx)
+
+	node := inFlight.Node
+
+	// The node is kept, so the surge node is not needed
+	if inFlight.Step == RotationStepScheduling {
+		if inFlight.Surged {
+			if err := revertSurge(ctx, clusterName, checkpoint); err != nil {
+				return "", err
+			}
+		}
+
+		checkpoint.InFlight = nil
+
+		return InFlightOutcomeKept, nil
+	}
+
+	if inFlight.Step == RotationStepDraining {
+		logger.Info("uncordoning interrupted node", "node", node.Name)
+
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.UncordonNodeInput{
+			ClusterName: clusterName,
+			NodeName:    node.Name,
+		}
+
+		err := workflow.ExecuteActivity(ctx, nodeactivities.UncordonNode, input).Get(ctx, nil)
+		if err != nil {
+			return "", err
+		}
+
+		if inFlight.Surged {
+			if err := revertSurge(ctx, clusterName, checkpoint); err != nil {
+				return "", err
+			}
+		}
+
+		checkpoint.InFlight = nil
+
+		return InFlightOutcomeUncordoned, nil
+	}
+
+	logger.Info("completing the termination of interrupted node", "node", node.Name, "instanceId", node.InstanceID, "step", inFlight.Step)
+
+	// The deletion may not have gone through
+	if inFlight.Step == RotationStepDeleting {
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.DeleteNodeInput{
+			ClusterName: clusterName,
+			NodeName:    node.Name,
+		}
+
+		err := workflow.ExecuteActivity(ctx, nodeactivities.DeleteNode, input).Get(ctx, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// The detachment may not have gone through
+	if inFlight.Step != RotationStepTerminating {
+		group, err := describeAutoScalingGroup(ctx, checkpoint.AutoScalingGroupName)
+		if err != nil {
+			return "", err
+		}
+
+		for _, instance := range group.Instances {
+			if aws.ToString(instance.InstanceId) != node.InstanceID {
+				continue
+			}
+
+			if err := detachInstance(ctx, checkpoint.AutoScalingGroupName, node.InstanceID, inFlight.Surged); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	if err := terminateInstance(ctx, node.InstanceID); err != nil {
+		return "", err
+	}
+
+	if len(checkpoint.Nodes) > 0 && checkpoint.Nodes[0].Name == node.Name {
+		checkpoint.Nodes = checkpoint.Nodes[1:]
+	}
+
+	checkpoint.RotatedNodes++
+	checkpoint.InFlight = nil
+
+	return InFlightOutcomeTerminated, nil
+}
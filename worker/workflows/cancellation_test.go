@@ -0,0 +1,233 @@
+package workflows
+
+import (
+	"errors"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+// onDetachInstance expects an instance to be detached from an auto scaling group.
+func onDetachInstance(env *testsuite.TestWorkflowEnvironment, asgName string, instanceID string, decrementDesiredCapacity bool) {
+	matcher := mock.MatchedBy(func(input *autoscaling.DetachInstancesInput) bool {
+		return aws.ToString(input.AutoScalingGroupName) == asgName &&
+			slices.Equal(input.InstanceIds, []string{instanceID}) &&
+			aws.ToBool(input.ShouldDecrementDesiredCapacity) == decrementDesiredCapacity
+	})
+
+	env.OnActivity((&awsactivities.AutoScaling{}).DetachInstances, mock.Anything, matcher).
+		Return(&autoscaling.DetachInstancesOutput{}, nil).Once()
+}
+
+// onTerminateInstance expects an instance to be terminated.
+func onTerminateInstance(env *testsuite.TestWorkflowEnvironment, instanceID string) {
+	matcher := mock.MatchedBy(func(input *ec2.TerminateInstancesInput) bool {
+		return slices.Equal(input.InstanceIds, []string{instanceID})
+	})
+
+	env.OnActivity((&awsactivities.EC2{}).TerminateInstances, mock.Anything, matcher).
+		Return(&ec2.TerminateInstancesOutput{}, nil).Once()
+	env.OnActivity((&awsactivities.EC2{}).WaitForInstanceTerminated, mock.Anything, []string{instanceID}).
+		Return(nil).Once()
+}
+
+func TestReleaseNodeGroupOnFailure(t *testing.T) {
+	nodes := []RotationNode{
+		{Name: "node-1", InstanceID: "i-1", Zone: "eu-west-1a"},
+		{Name: "node-2", InstanceID: "i-2", Zone: "eu-west-1b"},
+	}
+
+	testCases := []struct {
+		name   string
+		step   RotationStep
+		cancel bool
+		mock   func(env *testsuite.TestWorkflowEnvironment)
+
+		report CancellationReport
+
+		// cleanupErrors are the beginnings of the reported cleanup errors (errors are wrapped by the SDK)
+		cleanupErrors []string
+	}{
+		{
+			name:   "cancelled while draining",
+			step:   RotationStepDraining,
+			cancel: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				env.OnActivity((&kubeactivities.Nodes{}).UncordonNode, mock.Anything, kubeactivities.UncordonNodeInput{ClusterName: "mark-1", NodeName: "node-1"}).
+					Return(&kubeactivities.UncordonNodeOutput{}, nil).Once()
+				env.OnActivity((&kubeactivities.Nodes{}).UnmarkOutdatedNodes, mock.Anything, kubeactivities.UnmarkOutdatedNodesInput{ClusterName: "mark-1", NodeNames: []string{"node-1", "node-2"}}).
+					Return(&kubeactivities.UnmarkOutdatedNodesOutput{}, nil).Once()
+			},
+			report: CancellationReport{
+				RotatedNodes:    1,
+				RemainingNodes:  []string{"node-1", "node-2"},
+				InFlightOutcome: InFlightOutcomeUncordoned,
+			},
+		},
+		{
+			name:   "cancelled while deleting",
+			step:   RotationStepDeleting,
+			cancel: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				env.OnActivity((&kubeactivities.Nodes{}).DeleteNode, mock.Anything, kubeactivities.DeleteNodeInput{ClusterName: "mark-1", NodeName: "node-1"}).
+					Return(&kubeactivities.DeleteNodeOutput{}, nil).Once()
+
+				// The instance is still attached to the auto scaling group
+				env.OnActivity((&awsactivities.AutoScaling{}).DescribeAutoScalingGroups, mock.Anything, mock.Anything).
+					Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []astypes.AutoScalingGroup{
+							{
+								AutoScalingGroupName: aws.String("mark-1-ng-1-asg"),
+								Instances: []astypes.Instance{
+									{InstanceId: aws.String("i-1")},
+									{InstanceId: aws.String("i-2")},
+								},
+							},
+						},
+					}, nil).Once()
+
+				onDetachInstance(env, "mark-1-ng-1-asg", "i-1", false)
+				onTerminateInstance(env, "i-1")
+
+				env.OnActivity((&kubeactivities.Nodes{}).UnmarkOutdatedNodes, mock.Anything, kubeactivities.UnmarkOutdatedNodesInput{ClusterName: "mark-1", NodeNames: []string{"node-2"}}).
+					Return(&kubeactivities.UnmarkOutdatedNodesOutput{}, nil).Once()
+			},
+			report: CancellationReport{
+				RotatedNodes:    2,
+				RemainingNodes:  []string{"node-2"},
+				InFlightOutcome: InFlightOutcomeTerminated,
+			},
+		},
+		{
+			// Failed cleanup steps are reported, the remaining steps are still executed
+			name:   "cleanup failure",
+			step:   RotationStepDeleting,
+			cancel: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				env.OnActivity((&kubeactivities.Nodes{}).DeleteNode, mock.Anything, mock.Anything).
+					Return(nil, temporal.NewNonRetryableApplicationError("unauthorized", "Unauthorized", nil)).Once()
+				env.OnActivity((&kubeactivities.Nodes{}).UnmarkOutdatedNodes, mock.Anything, mock.Anything).
+					Return(&kubeactivities.UnmarkOutdatedNodesOutput{}, nil).Once()
+			},
+			report: CancellationReport{
+				RotatedNodes:   1,
+				RemainingNodes: []string{"node-1", "node-2"},
+			},
+			cleanupErrors: []string{"recovering node node-1 (interrupted while deleting): "},
+		},
+		{
+			// The node group is released after failures as well, but the error is returned as is
+			name: "failed while draining",
+			step: RotationStepDraining,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				env.OnActivity((&kubeactivities.Nodes{}).UncordonNode, mock.Anything, kubeactivities.UncordonNodeInput{ClusterName: "mark-1", NodeName: "node-1"}).
+					Return(&kubeactivities.UncordonNodeOutput{}, nil).Once()
+				env.OnActivity((&kubeactivities.Nodes{}).UnmarkOutdatedNodes, mock.Anything, mock.Anything).
+					Return(&kubeactivities.UnmarkOutdatedNodesOutput{}, nil).Once()
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			env := newTestWorkflowEnvironment(t)
+
+			testCase.mock(env)
+
+			if testCase.cancel {
+				env.RegisterDelayedCallback(env.CancelWorkflow, time.Minute)
+			}
+
+			env.ExecuteWorkflow(func(ctx workflow.Context) (err error) {
+				checkpoint := RotationCheckpoint{
+					AutoScalingGroupName: "mark-1-ng-1-asg",
+					Nodes:                slices.Clone(nodes),
+					RotatedNodes:         1,
+					OutdatedNodesMarked:  true,
+					InFlight: &InFlightRotation{
+						Node: nodes[0],
+						Step: testCase.step,
+					},
+				}
+
+				defer func() {
+					err = releaseNodeGroupOnFailure(ctx, "mark-1", &checkpoint, err)
+				}()
+
+				if !testCase.cancel {
+					return temporal.NewNonRetryableApplicationError("drain timed out", "DrainTimeout", nil)
+				}
+
+				return workflow.Sleep(ctx, time.Hour)
+			})
+
+			err := env.GetWorkflowError()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+
+			var canceledErr *temporal.CanceledError
+
+			if !testCase.cancel {
+				if errors.As(err, &canceledErr) {
+					t.Fatalf("expected the original error, got %v", err)
+				}
+
+				var appErr *temporal.ApplicationError
+				if !errors.As(err, &appErr) || appErr.Type() != "DrainTimeout" {
+					t.Errorf("expected the original error, got %v", err)
+				}
+
+				return
+			}
+
+			if !errors.As(err, &canceledErr) {
+				t.Fatalf("expected a canceled error, got %v", err)
+			}
+
+			var report CancellationReport
+
+			if err := canceledErr.Details(&report); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if report.InFlight == nil || report.InFlight.Node != nodes[0] || report.InFlight.Step != testCase.step {
+				t.Errorf("expected the interrupted rotation in the report, got %+v", report.InFlight)
+			}
+
+			report.InFlight = nil
+
+			if len(report.Errors) != len(testCase.cleanupErrors) {
+				t.Fatalf("expected %d cleanup errors, got %v", len(testCase.cleanupErrors), report.Errors)
+			}
+
+			for i, prefix := range testCase.cleanupErrors {
+				if !strings.HasPrefix(report.Errors[i], prefix) {
+					t.Errorf("expected cleanup error starting with %q, got %q", prefix, report.Errors[i])
+				}
+			}
+
+			report.Errors = nil
+
+			if !reflect.DeepEqual(report, testCase.report) {
+				t.Errorf("expected report %+v, got %+v", testCase.report, report)
+			}
+		})
+	}
+}
@@ -0,0 +1,370 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+const (
+	// UpdateApplySpec is the name of the update replacing the desired spec of a [ClusterEntity] and reconciling the cluster.
+	UpdateApplySpec = "apply-spec"
+
+	// UpdateRequestOperation is the name of the update queueing a [ClusterOperation] in a [ClusterEntity].
+	UpdateRequestOperation = "request-operation"
+
+	// QueryClusterState is the name of the query returning the [ClusterEntityState] of a [ClusterEntity].
+	QueryClusterState = "cluster-state"
+)
+
+// Types of operations run by a [ClusterEntity].
+const (
+	ClusterOperationReconcile    = "Reconcile"
+	ClusterOperationDelete       = "Delete"
+	ClusterOperationRecycleNodes = "RecycleNodes"
+)
+
+// maxRecentOperations is the number of completed operations kept in the state of a [ClusterEntity].
+const maxRecentOperations = 20
+
+// ClusterOperation is an operation queued in a [ClusterEntity].
+type ClusterOperation struct {
+	// ID is assigned by the entity when the operation is accepted.
+	ID int
+
+	Type string
+
+	// NodeGroup, MaxAge and MaxNodes are the parameters of [ClusterOperationRecycleNodes] (see [RecycleNodesInput]).
+	NodeGroup string        `json:",omitempty"`
+	MaxAge    time.Duration `json:",omitempty"`
+	MaxNodes  int           `json:",omitempty"`
+}
+
+// ClusterOperationResult records the outcome of a completed [ClusterOperation].
+type ClusterOperationResult struct {
+	Operation ClusterOperation
+
+	// Error is empty if the operation succeeded.
+	Error string `json:",omitempty"`
+
+	CompletedAt time.Time
+}
+
+// ClusterEntityState describes the state of a [ClusterEntity].
+type ClusterEntityState struct {
+	// Cluster is the current desired spec.
+	Cluster cluster.Cluster
+
+	// Running is the operation currently running (if any).
+	Running *ClusterOperation `json:",omitempty"`
+
+	// Pending operations run in order after the running one.
+	Pending []ClusterOperation
+
+	// Recent contains the last completed operations (oldest first).
+	Recent []ClusterOperationResult
+
+	// NextOperationID is the ID assigned to the next accepted operation.
+	NextOperationID int
+}
+
+// ClusterEntityInput contains the input parameters for the [ClusterEntity] workflow.
+type ClusterEntityInput struct {
+	// Cluster is the initial desired spec.
+	// It is not applied until requested using [UpdateApplySpec].
+	Cluster cluster.Cluster
+
+	// MaxHistoryLength is the number of history events after which the workflow continues as new.
+	// Defaults to [DefaultMaxHistoryLength].
+	MaxHistoryLength int
+
+	// State carries the entity state over to the next run when the workflow continues as new.
+	// It is populated by the workflow itself and should be left empty when starting the entity.
+	State *ClusterEntityState
+}
+
+// ClusterEntityOutput contains the return parameters for the [ClusterEntity] workflow.
+type ClusterEntityOutput struct{}
+
+// ClusterEntity is a long-running workflow holding the desired state of a cluster.
+//
+// Every cluster has a single entity (see [ClusterEntityID]). Spec changes and operations are submitted as Temporal updates,
+// validated before they are accepted, then run one by one as child workflows.
+// Child workflows use the same IDs as the equivalent standalone operations (node recycling runs under [NodeGroupRotationID]),
+// so the same operation never runs twice at the same time and thesisctl refuses to start standalone operations on a cluster managed by an entity.
+// Scheduled operations are not started through the entity: those only share the node group lock with the entity.
+//
+// The entity completes once the cluster is deleted. Until then, it continues as new on the default Build ID
+// and starts operations on the default Build ID as well, so it never pins workers of older Build IDs.
+func ClusterEntity(ctx workflow.Context, input ClusterEntityInput) (*ClusterEntityOutput, error) {
+	state := input.State
+	if state == nil {
+		state = &ClusterEntityState{
+			Cluster:         input.Cluster,
+			NextOperationID: 1,
+		}
+	}
+
+	state.Cluster.Default()
+
+	if err := state.Cluster.Validate(); err != nil {
+		return nil, err
+	}
+
+	if workflow.GetInfo(ctx).WorkflowExecution.ID != ClusterEntityID(state.Cluster.Name) {
+		return nil, fmt.Errorf("workflow ID must be %s", ClusterEntityID(state.Cluster.Name))
+	}
+
+	maxHistoryLength := input.MaxHistoryLength
+	if maxHistoryLength == 0 {
+		maxHistoryLength = DefaultMaxHistoryLength
+	}
+
+	status := &Status{
+		Phase: "idle",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
+	err := workflow.SetQueryHandler(ctx, QueryClusterState, func() (ClusterEntityState, error) {
+		return *state, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	enqueue := func(op ClusterOperation) int {
+		op.ID = state.NextOperationID
+		state.NextOperationID++
+
+		state.Pending = append(state.Pending, op)
+
+		return op.ID
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(
+		ctx,
+		UpdateApplySpec,
+		func(ctx workflow.Context, c cluster.Cluster) (int, error) {
+			c.Default()
+
+			state.Cluster = c
+
+			// A pending reconciliation picks up the new spec as well
+			for _, op := range state.Pending {
+				if op.Type == ClusterOperationReconcile {
+					return op.ID, nil
+				}
+			}
+
+			return enqueue(ClusterOperation{Type: ClusterOperationReconcile}), nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, c cluster.Cluster) error {
+				if err := state.acceptsOperations(); err != nil {
+					return err
+				}
+
+				if c.Name != state.Cluster.Name {
+					return fmt.Errorf("cluster name cannot be changed (%s)", state.Cluster.Name)
+				}
+
+				c.Default()
+
+				return c.Validate()
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = workflow.SetUpdateHandlerWithOptions(
+		ctx,
+		UpdateRequestOperation,
+		func(ctx workflow.Context, op ClusterOperation) (int, error) {
+			return enqueue(op), nil
+		},
+		workflow.UpdateHandlerOptions{
+			Validator: func(ctx workflow.Context, op ClusterOperation) error {
+				if err := state.acceptsOperations(); err != nil {
+					return err
+				}
+
+				return state.validateOperation(op)
+			},
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	shouldContinueAsNew := func() bool {
+		info := workflow.GetInfo(ctx)
+
+		return info.GetCurrentHistoryLength() >= maxHistoryLength || info.GetContinueAsNewSuggested()
+	}
+
+	for {
+		err := workflow.Await(ctx, func() bool {
+			return len(state.Pending) > 0 || shouldContinueAsNew()
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		// Update handlers never block, so there is nothing to wait for before continuing as new
+		if shouldContinueAsNew() {
+			workflow.GetLogger(ctx).Info("continuing as new", "pendingOperations", len(state.Pending))
+
+			input.State = state
+
+			// Move to the default Build ID, so that workers of older Build IDs can be retired
+			ctx := workflow.WithWorkflowVersioningIntent(ctx, temporal.VersioningIntentDefault)
+
+			return nil, workflow.NewContinueAsNewError(ctx, ClusterEntity, input)
+		}
+
+		op := state.Pending[0]
+		state.Pending = state.Pending[1:]
+		state.Running = &op
+
+		status.Phase = fmt.Sprintf("running operation %d (%s)", op.ID, op.Type)
+
+		err = runClusterOperation(ctx, state.Cluster, op)
+
+		result := ClusterOperationResult{
+			Operation:   op,
+			CompletedAt: workflow.Now(ctx),
+		}
+
+		if err != nil {
+			workflow.GetLogger(ctx).Error("operation failed", "id", op.ID, "type", op.Type, "error", err)
+
+			result.Error = err.Error()
+		}
+
+		state.Running = nil
+		state.Recent = append(state.Recent, result)
+
+		if len(state.Recent) > maxRecentOperations {
+			state.Recent = state.Recent[len(state.Recent)-maxRecentOperations:]
+		}
+
+		status.Phase = "idle"
+
+		if op.Type == ClusterOperationDelete && err == nil {
+			status.Phase = "deleted"
+
+			return nil, nil
+		}
+	}
+}
+
+// ClusterEntityID returns the workflow ID of the [ClusterEntity] of a cluster.
+func ClusterEntityID(clusterName string) string {
+	return WorkflowID(clusterName)
+}
+
+// acceptsOperations reports an error if the entity does not accept new operations (because the cluster is being deleted).
+func (s ClusterEntityState) acceptsOperations() error {
+	if s.Running != nil && s.Running.Type == ClusterOperationDelete {
+		return errors.New("cluster is being deleted")
+	}
+
+	for _, op := range s.Pending {
+		if op.Type == ClusterOperationDelete {
+			return errors.New("cluster is being deleted")
+		}
+	}
+
+	return nil
+}
+
+func (s ClusterEntityState) validateOperation(op ClusterOperation) error {
+	switch op.Type {
+	case ClusterOperationReconcile, ClusterOperationDelete:
+		return nil
+
+	case ClusterOperationRecycleNodes:
+		input := RecycleNodesInput{
+			ClusterName:   s.Cluster.Name,
+			NodeGroupName: op.NodeGroup,
+			MaxAge:        op.MaxAge,
+			MaxNodes:      op.MaxNodes,
+		}
+
+		if err := input.Validate(); err != nil {
+			return err
+		}
+
+		for _, ng := range s.Cluster.NodeGroups {
+			if ng.Name == op.NodeGroup {
+				return nil
+			}
+		}
+
+		return fmt.Errorf("node group %q not found in cluster spec", op.NodeGroup)
+
+	default:
+		return fmt.Errorf("unknown operation: %q", op.Type)
+	}
+}
+
+// runClusterOperation runs an operation as a child workflow.
+//
+// Operations start on the default Build ID (instead of the Build ID of the entity).
+func runClusterOperation(ctx workflow.Context, c cluster.Cluster, op ClusterOperation) error {
+	ctx = workflow.WithWorkflowVersioningIntent(ctx, temporal.VersioningIntentDefault)
+
+	var (
+		id    string
+		fn    any
+		input any
+	)
+
+	switch op.Type {
+	case ClusterOperationReconcile:
+		id = WorkflowID(c.Name, "reconcile")
+		fn = ReconcileCluster
+		input = ReconcileClusterInput{
+			Cluster: c,
+		}
+
+	case ClusterOperationDelete:
+		id = WorkflowID(c.Name, "delete")
+		fn = DeleteCluster
+		input = DeleteClusterInput{
+			Cluster: c,
+		}
+
+	case ClusterOperationRecycleNodes:
+		input := RecycleNodesInput{
+			ClusterName:   c.Name,
+			NodeGroupName: op.NodeGroup,
+			MaxAge:        op.MaxAge,
+			Maintenance:   c.Maintenance,
+			MaxNodes:      op.MaxNodes,
+		}
+
+		return runNodeGroupRotation(ctx, c.Name, op.NodeGroup, RecycleNodes, input, nil, nil)
+
+	default:
+		return fmt.Errorf("unknown operation: %q", op.Type)
+	}
+
+	cwo := workflow.ChildWorkflowOptions{
+		WorkflowID:       id,
+		VersioningIntent: temporal.VersioningIntentDefault,
+	}
+	ctx = workflow.WithChildOptions(ctx, cwo)
+
+	return workflow.ExecuteChildWorkflow(ctx, fn, input).Get(ctx, nil)
+}
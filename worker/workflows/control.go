@@ -0,0 +1,159 @@
+package workflows
+
+import (
+	"go.temporal.io/sdk/workflow"
+)
+
+const (
+	// QueryStatus is the name of the query returning the [Status] of a workflow.
+	QueryStatus = "status"
+
+	// SignalPause is the name of the signal pausing a workflow before its next disruptive step.
+	SignalPause = "pause"
+
+	// SignalResume is the name of the signal resuming a paused workflow.
+	SignalResume = "resume"
+)
+
+// Status describes the progress of a workflow.
+type Status struct {
+	// Phase is a short description of what the workflow is currently doing.
+	Phase string
+
+	// Paused is true if the workflow is paused (or is going to pause before its next step).
+	Paused bool
+
+	// ApprovalGate is the gate the workflow is waiting for an [Approval] at (if any).
+	ApprovalGate string
+
+	// Completed and Total describe the progress of the current phase (if applicable).
+	Completed int
+	Total     int
+
+	// ChildWorkflowID is the ID of the child workflow running the current phase (if any).
+	// Pause and resume signals are forwarded to it, but its progress has to be queried separately.
+	ChildWorkflowID string `json:",omitempty"`
+}
+
+// setStatusQueryHandler registers a query handler returning the current status of the workflow.
+func setStatusQueryHandler(ctx workflow.Context, status *Status) error {
+	return workflow.SetQueryHandler(ctx, QueryStatus, func() (Status, error) {
+		return *status, nil
+	})
+}
+
+// pauser handles pause and resume signals.
+type pauser struct {
+	status *Status
+
+	pauseCh  workflow.ReceiveChannel
+	resumeCh workflow.ReceiveChannel
+
+	// child is the ID of the running child workflow signals are forwarded to (if any).
+	child string
+}
+
+// newPauser listens to pause and resume signals and records the result in status.
+func newPauser(ctx workflow.Context, status *Status) *pauser {
+	p := &pauser{
+		status:   status,
+		pauseCh:  workflow.GetSignalChannel(ctx, SignalPause),
+		resumeCh: workflow.GetSignalChannel(ctx, SignalResume),
+	}
+
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			selector := workflow.NewSelector(ctx)
+
+			selector.AddReceive(p.pauseCh, func(c workflow.ReceiveChannel, _ bool) {
+				c.Receive(ctx, nil)
+
+				p.pause(ctx)
+			})
+
+			selector.AddReceive(p.resumeCh, func(c workflow.ReceiveChannel, _ bool) {
+				c.Receive(ctx, nil)
+
+				p.resume(ctx)
+			})
+
+			selector.Select(ctx)
+		}
+	})
+
+	return p
+}
+
+func (p *pauser) pause(ctx workflow.Context) {
+	workflow.GetLogger(ctx).Info("pausing workflow")
+
+	p.status.Paused = true
+
+	p.signalChild(ctx, SignalPause)
+}
+
+func (p *pauser) resume(ctx workflow.Context) {
+	workflow.GetLogger(ctx).Info("resuming workflow")
+
+	p.status.Paused = false
+
+	p.signalChild(ctx, SignalResume)
+}
+
+// signalChild forwards a signal to the running child workflow (if any).
+func (p *pauser) signalChild(ctx workflow.Context, signalName string) {
+	if p.child == "" {
+		return
+	}
+
+	err := workflow.SignalExternalWorkflow(ctx, p.child, "", signalName, nil).Get(ctx, nil)
+	if err != nil {
+		// The child may have completed in the meantime
+		workflow.GetLogger(ctx).Warn("failed to forward signal to child workflow", "signal", signalName, "workflowId", p.child, "error", err)
+	}
+}
+
+// runChild waits for a child workflow to complete, forwarding pause and resume signals to it in the meantime.
+//
+// A pause received before the child started is forwarded as well.
+func (p *pauser) runChild(ctx workflow.Context, future workflow.ChildWorkflowFuture, output any) error {
+	var execution workflow.Execution
+
+	if err := future.GetChildWorkflowExecution().Get(ctx, &execution); err != nil {
+		return err
+	}
+
+	p.child = execution.ID
+	p.status.ChildWorkflowID = execution.ID
+
+	defer func() {
+		p.child = ""
+		p.status.ChildWorkflowID = ""
+	}()
+
+	if p.status.Paused {
+		p.signalChild(ctx, SignalPause)
+	}
+
+	return future.Get(ctx, output)
+}
+
+// wait blocks until the workflow is resumed (if it's paused).
+func (p *pauser) wait(ctx workflow.Context) error {
+	return workflow.Await(ctx, func() bool {
+		return !p.status.Paused
+	})
+}
+
+// drain processes signals that arrived but haven't been handled yet.
+//
+// Call it before continuing as new to avoid losing signals.
+func (p *pauser) drain(ctx workflow.Context) {
+	for p.pauseCh.ReceiveAsync(nil) {
+		p.pause(ctx)
+	}
+
+	for p.resumeCh.ReceiveAsync(nil) {
+		p.resume(ctx)
+	}
+}
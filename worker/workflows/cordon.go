@@ -0,0 +1,103 @@
+package workflows
+
+import (
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+// markOutdatedNodes taints the nodes left in the checkpoint with a PreferNoSchedule taint (and cordons them if requested).
+func markOutdatedNodes(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, cordon bool) error {
+	var nodeactivities kubeactivities.Nodes
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Minute,
+		HeartbeatTimeout:    30 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.MarkOutdatedNodesInput{
+		ClusterName: clusterName,
+		NodeNames:   rotationNodeNames(checkpoint.Nodes),
+		Cordon:      cordon,
+	}
+
+	workflow.GetLogger(ctx).Info("marking outdated nodes", "nodes", input.NodeNames, "cordon", cordon)
+
+	err := workflow.ExecuteActivity(ctx, nodeactivities.MarkOutdatedNodes, input).Get(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	checkpoint.OutdatedNodesMarked = true
+	checkpoint.OutdatedNodesCordoned = checkpoint.OutdatedNodesCordoned || cordon
+
+	return nil
+}
+
+// unmarkOutdatedNodes removes the taint from the nodes left in the checkpoint and uncordons them (if they were cordoned by [markOutdatedNodes]).
+func unmarkOutdatedNodes(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint) error {
+	var nodeactivities kubeactivities.Nodes
+
+	if !checkpoint.OutdatedNodesMarked {
+		return nil
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Minute,
+		HeartbeatTimeout:    30 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.UnmarkOutdatedNodesInput{
+		ClusterName: clusterName,
+		NodeNames:   rotationNodeNames(checkpoint.Nodes),
+	}
+
+	workflow.GetLogger(ctx).Info("unmarking outdated nodes", "nodes", input.NodeNames)
+
+	err := workflow.ExecuteActivity(ctx, nodeactivities.UnmarkOutdatedNodes, input).Get(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	checkpoint.OutdatedNodesMarked = false
+	checkpoint.OutdatedNodesCordoned = false
+
+	return nil
+}
+
+// cordonOutdatedNodes cordons the nodes left in the checkpoint once there is enough replacement capacity,
+// ie. the pods of the next node would fit on the remaining nodes even with every outdated node cordoned.
+func cordonOutdatedNodes(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint) error {
+	if checkpoint.OutdatedNodesCordoned || len(checkpoint.Nodes) == 0 {
+		return nil
+	}
+
+	next := checkpoint.Nodes[0]
+
+	unschedulable, err := simulateDrain(ctx, clusterName, next.Name, rotationNodeNames(checkpoint.Nodes[1:]))
+	if err != nil {
+		return err
+	}
+
+	if len(unschedulable) > 0 {
+		workflow.GetLogger(ctx).Info("not enough replacement capacity to cordon outdated nodes yet", "unschedulablePods", len(unschedulable))
+
+		return nil
+	}
+
+	return markOutdatedNodes(ctx, clusterName, checkpoint, true)
+}
+
+func rotationNodeNames(nodes []RotationNode) []string {
+	names := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+
+	return names
+}
@@ -29,10 +29,20 @@ type CreateClusterOutput struct{}
 
 // CreateCluster creates a new EKS cluster.
 func CreateCluster(ctx workflow.Context, input CreateClusterInput) (*CreateClusterOutput, error) {
+	input.Cluster.Default()
+
 	if err := input.Cluster.Validate(); err != nil {
 		return nil, err
 	}
 
+	status := &Status{
+		Phase: "creating VPC",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
 	var cfactivities awsactivities.CloudFormation
 	var eksactivities awsactivities.EKS
 	var clusterSetupActivities kubeactivities.ClusterSetup
@@ -72,41 +82,14 @@ func CreateCluster(ctx workflow.Context, input CreateClusterInput) (*CreateClust
 	}
 
 	// Grab VPC details
-	var vpcID, subnetIDs, securityGroupIDs string
-	{
-		ao := workflow.ActivityOptions{
-			StartToCloseTimeout: 15 * time.Second,
-		}
-		ctx := workflow.WithActivityOptions(ctx, ao)
-
-		input := &cloudformation.DescribeStacksInput{
-			StackName: aws.String(vpcStackName),
-		}
-
-		var output *cloudformation.DescribeStacksOutput
-
-		err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacks, input).Get(ctx, &output)
-		if err != nil {
-			return nil, err
-		}
-
-		if len(output.Stacks) == 0 {
-			return nil, errors.New("stack not found")
-		}
-
-		for _, output := range output.Stacks[0].Outputs {
-			switch aws.ToString(output.OutputKey) {
-			case "VpcId":
-				vpcID = aws.ToString(output.OutputValue)
-			case "SubnetIds":
-				subnetIDs = aws.ToString(output.OutputValue)
-			case "SecurityGroups":
-				securityGroupIDs = aws.ToString(output.OutputValue)
-			}
-		}
+	vpc, err := describeVPCStack(ctx, vpcStackName)
+	if err != nil {
+		return nil, err
 	}
 
-	workflow.GetLogger(ctx).Info("VPC details", "VPCID", vpcID, "SubnetIDs", subnetIDs, "securityGroupIDs", securityGroupIDs)
+	workflow.GetLogger(ctx).Info("VPC details", "VPCID", vpc.VpcID, "SubnetIDs", vpc.SubnetIDs, "securityGroupIDs", vpc.SecurityGroupIDs)
+
+	status.Phase = "creating cluster"
 
 	// Create cluster
 	{
@@ -118,8 +101,8 @@ func CreateCluster(ctx workflow.Context, input CreateClusterInput) (*CreateClust
 		input := &eks.CreateClusterInput{
 			Name: aws.String(input.Cluster.Name),
 			ResourcesVpcConfig: &ekstypes.VpcConfigRequest{
-				SecurityGroupIds: strings.Split(securityGroupIDs, ","),
-				SubnetIds:        strings.Split(subnetIDs, ","),
+				SecurityGroupIds: strings.Split(vpc.SecurityGroupIDs, ","),
+				SubnetIds:        strings.Split(vpc.SubnetIDs, ","),
 			},
 			RoleArn: aws.String(input.Cluster.Cloud.RoleARN),
 			Version: aws.String(input.Cluster.Kubernetes.Version),
@@ -145,126 +128,200 @@ func CreateCluster(ctx workflow.Context, input CreateClusterInput) (*CreateClust
 		}
 	}
 
+	controlPlane, err := describeControlPlane(ctx, input.Cluster.Name)
+	if err != nil {
+		return nil, err
+	}
+
 	var nodeInstanceRoleARNs []string
 
 	for _, ng := range input.Cluster.NodeGroups {
-		ngStackName := fmt.Sprintf("%s-%s", input.Cluster.Name, ng.Name)
+		status.Phase = fmt.Sprintf("creating node group %s", ng.Name)
 
-		// Create self-managed node group (using cloudformation)
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: 15 * time.Second,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
-
-			stackParameters := []cftypes.Parameter{
-				{
-					ParameterKey:   aws.String("ClusterName"),
-					ParameterValue: aws.String(input.Cluster.Name),
-				},
-				{
-					ParameterKey:   aws.String("NodeGroupName"),
-					ParameterValue: aws.String(fmt.Sprintf("%s-%s", input.Cluster.Name, ng.Name)),
-				},
-				{
-					ParameterKey:   aws.String("VpcId"),
-					ParameterValue: aws.String(vpcID),
-				},
-				{
-					ParameterKey:   aws.String("Subnets"),
-					ParameterValue: aws.String(subnetIDs),
-				},
-				{
-					ParameterKey:   aws.String("ClusterControlPlaneSecurityGroup"),
-					ParameterValue: aws.String(securityGroupIDs),
-				},
-				{
-					ParameterKey:   aws.String("KeyName"),
-					ParameterValue: aws.String(ng.KeyName),
-				},
-				{
-					ParameterKey:   aws.String("NodeImageIdSSMParam"),
-					ParameterValue: aws.String(fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2/recommended/image_id", ng.Kubernetes.Version)),
-				},
-			}
+		nodeInstanceRoleARN, err := createNodeGroup(ctx, input.Cluster.Name, ng, vpc, controlPlane)
+		if err != nil {
+			return nil, err
+		}
 
-			input := &cloudformation.CreateStackInput{
-				StackName:    aws.String(ngStackName),
-				TemplateBody: aws.String(cftemplates.NodeGroup()),
-				Capabilities: []cftypes.Capability{
-					cftypes.CapabilityCapabilityIam,
-				},
-				Parameters: stackParameters,
-			}
+		nodeInstanceRoleARNs = append(nodeInstanceRoleARNs, nodeInstanceRoleARN)
+	}
 
-			err := workflow.ExecuteActivity(ctx, cfactivities.CreateStack, input).Get(ctx, nil)
-			if err != nil {
-				return nil, err
-			}
+	status.Phase = "configuring cluster"
+
+	// Create auth ConfigMap
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
 		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
 
-		// Wait for cloudformation stack
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: 10 * time.Minute,
-				HeartbeatTimeout:    30 * time.Second,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
+		input := kubeactivities.CreateAuthConfigMapInput{
+			ClusterName:          input.Cluster.Name,
+			NodeInstanceRoleARNs: nodeInstanceRoleARNs,
+		}
 
-			err := workflow.ExecuteActivity(ctx, cfactivities.WaitForCreateStack, ngStackName).Get(ctx, nil)
-			if err != nil {
-				return nil, err
-			}
+		err := workflow.ExecuteActivity(ctx, clusterSetupActivities.CreateAuthConfigMap, input).Get(ctx, nil)
+		if err != nil {
+			return nil, err
 		}
+	}
 
-		// Grab node group details
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: 15 * time.Second,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
+	status.Phase = "completed"
 
-			input := &cloudformation.DescribeStacksInput{
-				StackName: aws.String(ngStackName),
-			}
+	return nil, nil
+}
 
-			var output *cloudformation.DescribeStacksOutput
+// vpcDetails contains the outputs of the VPC stack node groups are created in.
+type vpcDetails struct {
+	VpcID string
 
-			err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacks, input).Get(ctx, &output)
-			if err != nil {
-				return nil, err
-			}
+	// SubnetIDs and SecurityGroupIDs are comma separated lists.
+	SubnetIDs        string
+	SecurityGroupIDs string
+}
 
-			if len(output.Stacks) == 0 {
-				return nil, errors.New("stack not found")
-			}
+// describeVPCStack returns the outputs of the VPC stack.
+func describeVPCStack(ctx workflow.Context, stackName string) (vpcDetails, error) {
+	var cfactivities awsactivities.CloudFormation
 
-			for _, output := range output.Stacks[0].Outputs {
-				switch aws.ToString(output.OutputKey) {
-				case "NodeInstanceRole":
-					nodeInstanceRoleARNs = append(nodeInstanceRoleARNs, aws.ToString(output.OutputValue))
-				}
-			}
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	}
+
+	var output *cloudformation.DescribeStacksOutput
+
+	err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacks, input).Get(ctx, &output)
+	if err != nil {
+		return vpcDetails{}, err
+	}
+
+	if len(output.Stacks) == 0 {
+		return vpcDetails{}, errors.New("stack not found")
+	}
+
+	var vpc vpcDetails
+
+	for _, output := range output.Stacks[0].Outputs {
+		switch aws.ToString(output.OutputKey) {
+		case "VpcId":
+			vpc.VpcID = aws.ToString(output.OutputValue)
+		case "SubnetIds":
+			vpc.SubnetIDs = aws.ToString(output.OutputValue)
+		case "SecurityGroups":
+			vpc.SecurityGroupIDs = aws.ToString(output.OutputValue)
 		}
 	}
 
-	// Create auth ConfigMap
+	return vpc, nil
+}
+
+// createNodeGroup creates a self-managed node group and returns the ARN of its node instance role.
+func createNodeGroup(ctx workflow.Context, clusterName string, ng cluster.NodeGroup, vpc vpcDetails, controlPlane cluster.ControlPlane) (string, error) {
+	var cfactivities awsactivities.CloudFormation
+
+	ngStackName := nodeGroupStackName(clusterName, ng.Name)
+
+	// Create self-managed node group (using cloudformation)
 	{
 		ao := workflow.ActivityOptions{
 			StartToCloseTimeout: 15 * time.Second,
 		}
 		ctx := workflow.WithActivityOptions(ctx, ao)
 
-		input := kubeactivities.CreateAuthConfigMapInput{
-			ClusterName:          input.Cluster.Name,
-			NodeInstanceRoleARNs: nodeInstanceRoleARNs,
+		stackParameters := []cftypes.Parameter{
+			{
+				ParameterKey:   aws.String("ClusterName"),
+				ParameterValue: aws.String(clusterName),
+			},
+			{
+				ParameterKey:   aws.String("NodeGroupName"),
+				ParameterValue: aws.String(fmt.Sprintf("%s-%s", clusterName, ng.Name)),
+			},
+			{
+				ParameterKey:   aws.String("VpcId"),
+				ParameterValue: aws.String(vpc.VpcID),
+			},
+			{
+				ParameterKey:   aws.String("Subnets"),
+				ParameterValue: aws.String(vpc.SubnetIDs),
+			},
+			{
+				ParameterKey:   aws.String("ClusterControlPlaneSecurityGroup"),
+				ParameterValue: aws.String(vpc.SecurityGroupIDs),
+			},
 		}
 
-		err := workflow.ExecuteActivity(ctx, clusterSetupActivities.CreateAuthConfigMap, input).Get(ctx, nil)
+		nodeGroupParameters, err := nodeGroupStackParameters(clusterName, ng, controlPlane)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+
+		stackParameters = append(stackParameters, nodeGroupParameters...)
+
+		input := &cloudformation.CreateStackInput{
+			StackName:    aws.String(ngStackName),
+			TemplateBody: aws.String(cftemplates.NodeGroup()),
+			Capabilities: []cftypes.Capability{
+				cftypes.CapabilityCapabilityIam,
+			},
+			Parameters: stackParameters,
+		}
+
+		err = workflow.ExecuteActivity(ctx, cfactivities.CreateStack, input).Get(ctx, nil)
+		if err != nil {
+			return "", err
 		}
 	}
 
-	return nil, nil
+	// Wait for cloudformation stack
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 10 * time.Minute,
+			HeartbeatTimeout:    30 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		err := workflow.ExecuteActivity(ctx, cfactivities.WaitForCreateStack, ngStackName).Get(ctx, nil)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// Grab node group details
+	var nodeInstanceRoleARN string
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := &cloudformation.DescribeStacksInput{
+			StackName: aws.String(ngStackName),
+		}
+
+		var output *cloudformation.DescribeStacksOutput
+
+		err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacks, input).Get(ctx, &output)
+		if err != nil {
+			return "", err
+		}
+
+		if len(output.Stacks) == 0 {
+			return "", errors.New("stack not found")
+		}
+
+		for _, output := range output.Stacks[0].Outputs {
+			switch aws.ToString(output.OutputKey) {
+			case "NodeInstanceRole":
+				nodeInstanceRoleARN = aws.ToString(output.OutputValue)
+			}
+		}
+	}
+
+	return nodeInstanceRoleARN, nil
 }
@@ -22,48 +22,31 @@ type DeleteClusterOutput struct{}
 
 // DeleteCluster creates a new EKS cluster.
 func DeleteCluster(ctx workflow.Context, input DeleteClusterInput) (*DeleteClusterOutput, error) {
+	input.Cluster.Default()
+
 	if err := input.Cluster.Validate(); err != nil {
 		return nil, err
 	}
 
-	var cfactivities awsactivities.CloudFormation
+	status := &Status{}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
 	var eksactivities awsactivities.EKS
 
 	for _, ng := range input.Cluster.NodeGroups {
-		ngStackName := fmt.Sprintf("%s-%s", input.Cluster.Name, ng.Name)
-
-		// Delete self-managed node group (using cloudformation)
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: 15 * time.Second,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
-
-			input := &cloudformation.DeleteStackInput{
-				StackName: aws.String(ngStackName),
-			}
-
-			err := workflow.ExecuteActivity(ctx, cfactivities.DeleteStack, input).Get(ctx, nil)
-			if err != nil {
-				return nil, err
-			}
-		}
+		status.Phase = fmt.Sprintf("deleting node group %s", ng.Name)
 
-		// Wait for cloudformation stack
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: 10 * time.Minute,
-				HeartbeatTimeout:    30 * time.Second,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
-
-			err := workflow.ExecuteActivity(ctx, cfactivities.WaitForDeleteStack, ngStackName).Get(ctx, nil)
-			if err != nil {
-				return nil, err
-			}
+		err := deleteStack(ctx, nodeGroupStackName(input.Cluster.Name, ng.Name))
+		if err != nil {
+			return nil, err
 		}
 	}
 
+	status.Phase = "deleting cluster"
+
 	// Delete cluster
 	{
 		ao := workflow.ActivityOptions{
@@ -91,9 +74,23 @@ func DeleteCluster(ctx workflow.Context, input DeleteClusterInput) (*DeleteClust
 		}
 	}
 
-	vpcStackName := fmt.Sprintf("%s-vpc", input.Cluster.Name)
+	status.Phase = "deleting VPC"
+
+	err := deleteStack(ctx, fmt.Sprintf("%s-vpc", input.Cluster.Name))
+	if err != nil {
+		return nil, err
+	}
+
+	status.Phase = "completed"
+
+	return nil, nil
+}
 
-	// Delete VPC (using cloudformation)
+// deleteStack deletes a CloudFormation stack and waits for the deletion to complete.
+func deleteStack(ctx workflow.Context, stackName string) error {
+	var cfactivities awsactivities.CloudFormation
+
+	// Delete stack
 	{
 		ao := workflow.ActivityOptions{
 			StartToCloseTimeout: 15 * time.Second,
@@ -101,12 +98,12 @@ func DeleteCluster(ctx workflow.Context, input DeleteClusterInput) (*DeleteClust
 		ctx := workflow.WithActivityOptions(ctx, ao)
 
 		input := &cloudformation.DeleteStackInput{
-			StackName: aws.String(vpcStackName),
+			StackName: aws.String(stackName),
 		}
 
 		err := workflow.ExecuteActivity(ctx, cfactivities.DeleteStack, input).Get(ctx, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
@@ -118,11 +115,11 @@ func DeleteCluster(ctx workflow.Context, input DeleteClusterInput) (*DeleteClust
 		}
 		ctx := workflow.WithActivityOptions(ctx, ao)
 
-		err := workflow.ExecuteActivity(ctx, cfactivities.WaitForDeleteStack, vpcStackName).Get(ctx, nil)
+		err := workflow.ExecuteActivity(ctx, cfactivities.WaitForDeleteStack, stackName).Get(ctx, nil)
 		if err != nil {
-			return nil, err
+			return err
 		}
 	}
 
-	return nil, nil
+	return nil
 }
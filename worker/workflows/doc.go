@@ -1,2 +1,23 @@
 // Package workflows contains Temporal workflows for the project.
+//
+// # Versioning
+//
+// Workflows are versioned using worker Build IDs:
+// every worker deployment gets a new Build ID and new workflow executions are routed to the latest one,
+// while running executions stay on the workers of the Build ID they were started on.
+// As a result, most changes (eg. changing the order of activities) can be made without any special care,
+// as long as workers of older Build IDs keep running until their workflows complete.
+//
+// Use a new (incompatible) Build ID for changes that only need to apply to new executions.
+// That is the default and it needs no changes in workflow code.
+//
+// Patch in place for changes that have to reach running executions (eg. a hotfix to a node rotation running for hours):
+// mark the new Build ID compatible with the previous one (so that running executions move to it)
+// and guard the change with [patched]. Once executions following the old code path are gone,
+// replace the condition with [deprecatePatch] and remove the old code.
+//
+// Long-running workflows that continue as new (eg. [ClusterEntity]) move to the default Build ID when they do
+// (and start their children on it), so that workers of older Build IDs can be retired.
+//
+// Always run the replayer (see cmd/replayer) against histories exported from production before deploying a change.
 package workflows
@@ -0,0 +1,151 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+const (
+	// DefaultHealthCheckTimeout is the default time workloads have to recover after a node replacement.
+	DefaultHealthCheckTimeout = 10 * time.Minute
+
+	// DefaultStuckPodThreshold is the default time after which Pending and crash looping pods are considered unhealthy.
+	DefaultStuckPodThreshold = 5 * time.Minute
+)
+
+// errWorkloadsUnhealthy is returned when workloads do not recover after a disruptive step.
+var errWorkloadsUnhealthy = errors.New("workloads did not recover")
+
+// HealthCheckOptions configures the workload health gate after every node replacement.
+//
+// The gate waits for Deployments, StatefulSets and DaemonSets to be at their desired availability,
+// pods not to be stuck in Pending or CrashLoopBackOff and nodes to be ready.
+// Problems that already existed before the node replacement are ignored.
+type HealthCheckOptions struct {
+	Enabled bool
+
+	// Timeout is the time workloads have to recover after a node replacement.
+	// Defaults to [DefaultHealthCheckTimeout].
+	Timeout time.Duration
+
+	// StuckPodThreshold is the time after which Pending and crash looping pods are considered unhealthy.
+	// Defaults to [DefaultStuckPodThreshold].
+	StuckPodThreshold time.Duration
+
+	// PauseOnFailure pauses the workflow instead of failing it if workloads do not recover in time.
+	PauseOnFailure bool
+}
+
+func (o HealthCheckOptions) Validate() error {
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	if o.StuckPodThreshold < 0 {
+		return errors.New("stuck pod threshold must not be negative")
+	}
+
+	return nil
+}
+
+func (o HealthCheckOptions) withDefaults() HealthCheckOptions {
+	if o.Timeout == 0 {
+		o.Timeout = DefaultHealthCheckTimeout
+	}
+
+	if o.StuckPodThreshold == 0 {
+		o.StuckPodThreshold = DefaultStuckPodThreshold
+	}
+
+	return o
+}
+
+// checkClusterHealth returns the current health problems of a cluster (used as a baseline before a disruptive step).
+func checkClusterHealth(ctx workflow.Context, clusterName string, options HealthCheckOptions) ([]kubeactivities.HealthProblem, error) {
+	var healthactivities kubeactivities.Health
+
+	options = options.withDefaults()
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.CheckHealthInput{
+		ClusterName:       clusterName,
+		StuckPodThreshold: options.StuckPodThreshold,
+	}
+
+	var output kubeactivities.CheckHealthOutput
+
+	err := workflow.ExecuteActivity(ctx, healthactivities.CheckHealth, input).Get(ctx, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Problems, nil
+}
+
+// healthGate waits for workloads to recover after a disruptive step (eg. replacing a node).
+//
+// If they do not recover in time, it either fails or pauses the workflow (see [HealthCheckOptions.PauseOnFailure]).
+func healthGate(ctx workflow.Context, clusterName string, step string, baseline []kubeactivities.HealthProblem, options HealthCheckOptions, status *Status, pauser *pauser) error {
+	var healthactivities kubeactivities.Health
+
+	options = options.withDefaults()
+
+	phase := status.Phase
+	status.Phase = fmt.Sprintf("waiting for workloads to recover after %s", step)
+
+	defer func() {
+		status.Phase = phase
+	}()
+
+	var output kubeactivities.WaitForHealthyOutput
+	{
+		ao := workflow.ActivityOptions{
+			// The activity returns the remaining problems shortly before the deadline
+			StartToCloseTimeout: options.Timeout,
+			HeartbeatTimeout:    time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.WaitForHealthyInput{
+			ClusterName:       clusterName,
+			StuckPodThreshold: options.StuckPodThreshold,
+			Ignored:           baseline,
+		}
+
+		err := workflow.ExecuteActivity(ctx, healthactivities.WaitForHealthy, input).Get(ctx, &output)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(output.Problems) == 0 {
+		return nil
+	}
+
+	problems := make([]string, 0, len(output.Problems))
+
+	for _, problem := range output.Problems {
+		problems = append(problems, problem.String())
+	}
+
+	workflow.GetLogger(ctx).Error("workloads did not recover", "step", step, "problems", problems)
+
+	if !options.PauseOnFailure {
+		return fmt.Errorf("%w after %s: %s", errWorkloadsUnhealthy, step, strings.Join(problems, "; "))
+	}
+
+	// Resuming the workflow accepts the problems
+	pauser.pause(ctx)
+
+	return nil
+}
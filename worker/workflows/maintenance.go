@@ -0,0 +1,47 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// waitForDisruption blocks until the workflow is allowed to perform a disruptive step (eg. draining a node).
+//
+// It waits for the workflow to be resumed (if it is paused) and for the next maintenance window (sleeping durably).
+func waitForDisruption(ctx workflow.Context, maintenance cluster.Maintenance, status *Status, pauser *pauser) error {
+	for {
+		if err := pauser.wait(ctx); err != nil {
+			return err
+		}
+
+		now := workflow.Now(ctx)
+
+		start, _, err := maintenance.NextWindow(now)
+		if err != nil {
+			return err
+		}
+
+		if !start.After(now) {
+			return nil
+		}
+
+		workflow.GetLogger(ctx).Info("waiting for maintenance window", "start", start)
+
+		phase := status.Phase
+		status.Phase = fmt.Sprintf("%s (waiting for maintenance window at %s)", phase, start.Format(time.RFC3339))
+
+		err = workflow.Sleep(ctx, start.Sub(now))
+
+		status.Phase = phase
+
+		if err != nil {
+			return err
+		}
+
+		// The workflow may have been paused in the meantime, so check everything again
+	}
+}
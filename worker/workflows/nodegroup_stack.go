@@ -0,0 +1,302 @@
+package workflows
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// nodeGroupStackParameters returns the node group stack parameters derived from the node group spec.
+//
+// The control plane details are only used by AMI families that need them in their user data.
+func nodeGroupStackParameters(clusterName string, ng cluster.NodeGroup, controlPlane cluster.ControlPlane) ([]cftypes.Parameter, error) {
+	userData, err := ng.RenderUserData(clusterName, controlPlane)
+	if err != nil {
+		return nil, err
+	}
+
+	return []cftypes.Parameter{
+		{
+			ParameterKey:   aws.String("KeyName"),
+			ParameterValue: aws.String(ng.KeyName),
+		},
+		{
+			ParameterKey:   aws.String("NodeImageIdSSMParam"),
+			ParameterValue: aws.String(ng.AMI.SSMParameter(ng.Kubernetes.Version)),
+		},
+		{
+			ParameterKey:   aws.String("NodeImageId"),
+			ParameterValue: aws.String(ng.AMI.ID),
+		},
+		{
+			ParameterKey:   aws.String("NodeUserData"),
+			ParameterValue: aws.String(userData),
+		},
+		{
+			ParameterKey:   aws.String("NodeInstanceType"),
+			ParameterValue: aws.String(ng.InstanceType),
+		},
+		{
+			ParameterKey:   aws.String("NodeAutoScalingGroupMinSize"),
+			ParameterValue: aws.String(strconv.Itoa(ng.Scaling.MinSize)),
+		},
+		{
+			ParameterKey:   aws.String("NodeAutoScalingGroupMaxSize"),
+			ParameterValue: aws.String(strconv.Itoa(ng.Scaling.MaxSize)),
+		},
+		{
+			ParameterKey:   aws.String("NodeAutoScalingGroupDesiredCapacity"),
+			ParameterValue: aws.String(strconv.Itoa(ng.Scaling.DesiredCapacity)),
+		},
+		{
+			ParameterKey:   aws.String("NodeVolumeSize"),
+			ParameterValue: aws.String(strconv.Itoa(ng.Volume.Size)),
+		},
+		{
+			ParameterKey:   aws.String("NodeVolumeType"),
+			ParameterValue: aws.String(ng.Volume.Type),
+		},
+		{
+			ParameterKey:   aws.String("BootstrapArguments"),
+			ParameterValue: aws.String(ng.RenderBootstrapArguments()),
+		},
+		{
+			ParameterKey:   aws.String("DisableIMDSv1"),
+			ParameterValue: aws.String(strconv.FormatBool(ng.DisableIMDSv1)),
+		},
+	}, nil
+}
+
+// describeControlPlane returns the control plane details nodes need to join the cluster.
+func describeControlPlane(ctx workflow.Context, clusterName string) (cluster.ControlPlane, error) {
+	var eksactivities awsactivities.EKS
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &eks.DescribeClusterInput{
+		Name: aws.String(clusterName),
+	}
+
+	var output *eks.DescribeClusterOutput
+
+	err := workflow.ExecuteActivity(ctx, eksactivities.DescribeCluster, input).Get(ctx, &output)
+	if err != nil {
+		return cluster.ControlPlane{}, err
+	}
+
+	controlPlane := cluster.ControlPlane{
+		Endpoint: aws.ToString(output.Cluster.Endpoint),
+	}
+
+	if output.Cluster.CertificateAuthority != nil {
+		controlPlane.CertificateAuthority = aws.ToString(output.Cluster.CertificateAuthority.Data)
+	}
+
+	if output.Cluster.KubernetesNetworkConfig != nil {
+		controlPlane.ServiceCIDR = aws.ToString(output.Cluster.KubernetesNetworkConfig.ServiceIpv4Cidr)
+	}
+
+	return controlPlane, nil
+}
+
+// nodeGroupInPlaceParameters are the node group stack parameters that are applied to the auto scaling group itself.
+//
+// Every other parameter ends up in the launch template, so existing nodes have to be replaced to pick up changes.
+var nodeGroupInPlaceParameters = map[string]bool{
+	"NodeAutoScalingGroupMinSize":         true,
+	"NodeAutoScalingGroupMaxSize":         true,
+	"NodeAutoScalingGroupDesiredCapacity": true,
+}
+
+// resolvedImageParameter is the key of the change reported when the image parameters did not change,
+// but the SSM parameter points to a new image.
+const resolvedImageParameter = "NodeImageIdSSMParam (resolved)"
+
+// stackImage returns the image ID the node group stack was last updated with.
+func stackImage(parameters []cftypes.Parameter) string {
+	var ssmImage string
+
+	for _, parameter := range parameters {
+		switch aws.ToString(parameter.ParameterKey) {
+		case "NodeImageId":
+			// A pinned image overrides the SSM parameter
+			if image := aws.ToString(parameter.ParameterValue); image != "" {
+				return image
+			}
+
+		case "NodeImageIdSSMParam":
+			ssmImage = aws.ToString(parameter.ResolvedValue)
+		}
+	}
+
+	return ssmImage
+}
+
+// ssmParameterVersion matches the Kubernetes version in the SSM parameter names returned by [cluster.AMI.SSMParameter].
+var ssmParameterVersion = regexp.MustCompile(`/(?:optimized-ami/|aws-k8s-)(\d+\.\d+)`)
+
+// stackKubernetesVersion returns the Kubernetes version the node group stack was last updated with.
+func stackKubernetesVersion(parameters []cftypes.Parameter) string {
+	match := ssmParameterVersion.FindStringSubmatch(stackParameter(parameters, "NodeImageIdSSMParam"))
+	if match == nil {
+		return ""
+	}
+
+	return match[1]
+}
+
+// resolveNodeGroupImage returns the ID of the image the nodes of a node group should run.
+func resolveNodeGroupImage(ctx workflow.Context, ng cluster.NodeGroup) (string, error) {
+	if ng.AMI.ID != "" {
+		return ng.AMI.ID, nil
+	}
+
+	return resolveSSMImage(ctx, ng.AMI.SSMParameter(ng.Kubernetes.Version))
+}
+
+// resolveSSMImage returns the ID of the image an SSM parameter currently points to.
+func resolveSSMImage(ctx workflow.Context, parameterName string) (string, error) {
+	var ssmactivities awsactivities.SSM
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &ssm.GetParameterInput{
+		Name: aws.String(parameterName),
+	}
+
+	var output *ssm.GetParameterOutput
+
+	err := workflow.ExecuteActivity(ctx, ssmactivities.GetParameter, input).Get(ctx, &output)
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(output.Parameter.Value), nil
+}
+
+// nodeGroupPreviousValueParameters are the node group stack parameters that never change after creating the node group.
+//
+// Stack updates keep their previous values.
+var nodeGroupPreviousValueParameters = []string{
+	"ClusterName",
+	"NodeGroupName",
+	"VpcId",
+	"Subnets",
+	"ClusterControlPlaneSecurityGroup",
+}
+
+// liveNodeGroupStackParameters returns the current parameters of a node group stack as its desired parameters
+// (see [LiveNodeGroupUpdate]) and the image the nodes of the node group should run.
+//
+// If kubernetesVersion is not empty, the SSM parameter of the image is replaced with the one for that version.
+func liveNodeGroupStackParameters(ctx workflow.Context, current []cftypes.Parameter, kubernetesVersion string) ([]cftypes.Parameter, string, error) {
+	var desired []cftypes.Parameter
+
+	for _, parameter := range current {
+		key := aws.ToString(parameter.ParameterKey)
+
+		if slices.Contains(nodeGroupPreviousValueParameters, key) {
+			continue
+		}
+
+		value := aws.ToString(parameter.ParameterValue)
+
+		if key == "NodeImageIdSSMParam" && kubernetesVersion != "" {
+			match := ssmParameterVersion.FindStringSubmatchIndex(value)
+			if match == nil {
+				return nil, "", fmt.Errorf("cannot determine the Kubernetes version of SSM parameter %s", value)
+			}
+
+			value = value[:match[2]] + kubernetesVersion + value[match[3]:]
+		}
+
+		desired = append(desired, cftypes.Parameter{
+			ParameterKey:   parameter.ParameterKey,
+			ParameterValue: aws.String(value),
+		})
+	}
+
+	// A pinned image overrides the SSM parameter
+	if image := stackParameter(desired, "NodeImageId"); image != "" {
+		if kubernetesVersion != "" && kubernetesVersion != stackKubernetesVersion(current) {
+			return nil, "", fmt.Errorf("cannot upgrade node group to Kubernetes %s: image is pinned to %s", kubernetesVersion, image)
+		}
+
+		return desired, image, nil
+	}
+
+	image, err := resolveSSMImage(ctx, stackParameter(desired, "NodeImageIdSSMParam"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	return desired, image, nil
+}
+
+// StackParameterChange describes the change of a single node group stack parameter.
+type StackParameterChange struct {
+	Key      string
+	Current  string
+	Desired  string
+	Replaces bool
+}
+
+// diffStackParameters compares the desired stack parameters with the current ones.
+//
+// Parameters missing from the current stack (eg. added to the template later) are considered empty.
+func diffStackParameters(current []cftypes.Parameter, desired []cftypes.Parameter) []StackParameterChange {
+	currentValues := make(map[string]string, len(current))
+
+	for _, parameter := range current {
+		currentValues[aws.ToString(parameter.ParameterKey)] = aws.ToString(parameter.ParameterValue)
+	}
+
+	var changes []StackParameterChange
+
+	// Iterate over a slice to keep the result deterministic
+	for _, parameter := range desired {
+		key := aws.ToString(parameter.ParameterKey)
+		value := aws.ToString(parameter.ParameterValue)
+
+		if currentValues[key] == value {
+			continue
+		}
+
+		changes = append(changes, StackParameterChange{
+			Key:      key,
+			Current:  currentValues[key],
+			Desired:  value,
+			Replaces: !nodeGroupInPlaceParameters[key],
+		})
+	}
+
+	return changes
+}
+
+// requiresReplacement reports whether any of the changes requires replacing the existing nodes.
+func requiresReplacement(changes []StackParameterChange) bool {
+	for _, change := range changes {
+		if change.Replaces {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,132 @@
+package workflows
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+func stackParameters(keyValues ...string) []cftypes.Parameter {
+	parameters := make([]cftypes.Parameter, 0, len(keyValues)/2)
+
+	for i := 0; i < len(keyValues); i += 2 {
+		parameters = append(parameters, cftypes.Parameter{
+			ParameterKey:   aws.String(keyValues[i]),
+			ParameterValue: aws.String(keyValues[i+1]),
+		})
+	}
+
+	return parameters
+}
+
+func TestDiffStackParameters(t *testing.T) {
+	current := stackParameters(
+		"NodeInstanceType", "t3.medium",
+		"NodeAutoScalingGroupDesiredCapacity", "3",
+		"NodeImageIdSSMParam", "/aws/service/eks/optimized-ami/1.27/amazon-linux-2/recommended/image_id",
+	)
+
+	testCases := []struct {
+		name    string
+		desired []cftypes.Parameter
+
+		changes     []StackParameterChange
+		replacement bool
+	}{
+		{
+			name: "no changes",
+			desired: stackParameters(
+				"NodeInstanceType", "t3.medium",
+				"NodeAutoScalingGroupDesiredCapacity", "3",
+			),
+		},
+		{
+			name: "in-place change",
+			desired: stackParameters(
+				"NodeInstanceType", "t3.medium",
+				"NodeAutoScalingGroupDesiredCapacity", "5",
+			),
+			changes: []StackParameterChange{
+				{Key: "NodeAutoScalingGroupDesiredCapacity", Current: "3", Desired: "5"},
+			},
+		},
+		{
+			name: "replacing change",
+			desired: stackParameters(
+				"NodeAutoScalingGroupDesiredCapacity", "4",
+				"NodeInstanceType", "t3.large",
+				"NodeImageIdSSMParam", "/aws/service/eks/optimized-ami/1.28/amazon-linux-2/recommended/image_id",
+			),
+			changes: []StackParameterChange{
+				{Key: "NodeAutoScalingGroupDesiredCapacity", Current: "3", Desired: "4"},
+				{Key: "NodeInstanceType", Current: "t3.medium", Desired: "t3.large", Replaces: true},
+				{
+					Key:      "NodeImageIdSSMParam",
+					Current:  "/aws/service/eks/optimized-ami/1.27/amazon-linux-2/recommended/image_id",
+					Desired:  "/aws/service/eks/optimized-ami/1.28/amazon-linux-2/recommended/image_id",
+					Replaces: true,
+				},
+			},
+			replacement: true,
+		},
+		{
+			name: "parameter missing from the current stack",
+			desired: stackParameters(
+				"NodeInstanceType", "t3.medium",
+				"BootstrapArguments", "--max-pods=110",
+			),
+			changes: []StackParameterChange{
+				{Key: "BootstrapArguments", Current: "", Desired: "--max-pods=110", Replaces: true},
+			},
+			replacement: true,
+		},
+		{
+			name: "empty parameter missing from the current stack",
+			desired: stackParameters(
+				"BootstrapArguments", "",
+			),
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			changes := diffStackParameters(current, testCase.desired)
+
+			if !reflect.DeepEqual(changes, testCase.changes) {
+				t.Errorf("expected changes %+v, got %+v", testCase.changes, changes)
+			}
+
+			if replacement := requiresReplacement(changes); replacement != testCase.replacement {
+				t.Errorf("expected replacement to be %t, got %t", testCase.replacement, replacement)
+			}
+		})
+	}
+}
+
+func TestStackKubernetesVersion(t *testing.T) {
+	testCases := []struct {
+		parameter string
+		version   string
+	}{
+		{parameter: "/aws/service/eks/optimized-ami/1.28/amazon-linux-2/recommended/image_id", version: "1.28"},
+		{parameter: "/aws/service/eks/optimized-ami/1.30/amazon-linux-2023/x86_64/standard/recommended/image_id", version: "1.30"},
+		{parameter: "/aws/service/bottlerocket/aws-k8s-1.29-nvidia/arm64/latest/image_id", version: "1.29"},
+		{parameter: "/custom/image", version: ""},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.parameter, func(t *testing.T) {
+			version := stackKubernetesVersion(stackParameters("NodeImageIdSSMParam", testCase.parameter))
+
+			if version != testCase.version {
+				t.Errorf("expected %q, got %q", testCase.version, version)
+			}
+		})
+	}
+}
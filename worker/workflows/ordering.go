@@ -0,0 +1,202 @@
+package workflows
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+// RotationOrder is the order nodes are rotated in.
+type RotationOrder string
+
+const (
+	// RotationOrderDefault rotates nodes in the order the API returns them.
+	RotationOrderDefault RotationOrder = ""
+
+	// RotationOrderZoneRoundRobin alternates between availability zones (from the topology.kubernetes.io/zone label),
+	// so that workloads spread across zones never lose the replicas of a whole zone at once.
+	RotationOrderZoneRoundRobin RotationOrder = "zone-round-robin"
+
+	// RotationOrderOldestFirst rotates the oldest nodes first.
+	RotationOrderOldestFirst RotationOrder = "oldest-first"
+
+	// RotationOrderFewestPodsFirst rotates the nodes running the fewest pods (rescheduled by a drain) first.
+	RotationOrderFewestPodsFirst RotationOrder = "fewest-pods-first"
+)
+
+func (o RotationOrder) Validate() error {
+	switch o {
+	case RotationOrderDefault, RotationOrderZoneRoundRobin, RotationOrderOldestFirst, RotationOrderFewestPodsFirst:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported rotation order: %q", o)
+}
+
+// orderNodes sorts nodes according to the rotation order.
+func orderNodes(ctx workflow.Context, clusterName string, nodes []nodeGroupNode, order RotationOrder) ([]nodeGroupNode, error) {
+	nodes = append([]nodeGroupNode(nil), nodes...)
+
+	switch order {
+	case RotationOrderZoneRoundRobin:
+		return zoneRoundRobin(nodes), nil
+
+	case RotationOrderOldestFirst:
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].CreatedAt.Before(nodes[j].CreatedAt)
+		})
+
+	case RotationOrderFewestPodsFirst:
+		pods, err := countPods(ctx, clusterName)
+		if err != nil {
+			return nil, err
+		}
+
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return pods[nodes[i].Name] < pods[nodes[j].Name]
+		})
+	}
+
+	return nodes, nil
+}
+
+// zoneRoundRobin interleaves nodes of different zones (keeping the order of nodes within a zone).
+func zoneRoundRobin(nodes []nodeGroupNode) []nodeGroupNode {
+	var zones []string
+
+	nodesByZone := map[string][]nodeGroupNode{}
+
+	for _, node := range nodes {
+		if _, ok := nodesByZone[node.Zone]; !ok {
+			zones = append(zones, node.Zone)
+		}
+
+		nodesByZone[node.Zone] = append(nodesByZone[node.Zone], node)
+	}
+
+	sort.Strings(zones)
+
+	ordered := make([]nodeGroupNode, 0, len(nodes))
+
+	for len(ordered) < len(nodes) {
+		for _, zone := range zones {
+			if len(nodesByZone[zone]) == 0 {
+				continue
+			}
+
+			ordered = append(ordered, nodesByZone[zone][0])
+			nodesByZone[zone] = nodesByZone[zone][1:]
+		}
+	}
+
+	return ordered
+}
+
+func countPods(ctx workflow.Context, clusterName string) (map[string]int, error) {
+	var nodeactivities kubeactivities.Nodes
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.CountPodsInput{
+		ClusterName: clusterName,
+	}
+
+	var output kubeactivities.CountPodsOutput
+
+	err := workflow.ExecuteActivity(ctx, nodeactivities.CountPods, input).Get(ctx, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Pods, nil
+}
+
+// waitForZoneBudget moves the first node in a zone with less than the maximum number of unavailable nodes
+// to the front of the checkpoint, waiting for nodes to become available if every zone is at the limit.
+//
+// Nodes are unavailable if they are not ready or cordoned (across the cluster, not just the node group).
+// Nodes without a zone are not limited.
+func waitForZoneBudget(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, maxUnavailable int, status *Status) error {
+	phase := status.Phase
+
+	defer func() {
+		status.Phase = phase
+	}()
+
+	for {
+		unavailable, err := countUnavailableNodes(ctx, clusterName)
+		if err != nil {
+			return err
+		}
+
+		for i, node := range checkpoint.Nodes {
+			if node.Zone != "" && unavailable[node.Zone] >= maxUnavailable {
+				continue
+			}
+
+			if i > 0 {
+				workflow.GetLogger(ctx).Info("rotating node out of order to respect max unavailable nodes per zone", "node", node.Name, "zone", node.Zone)
+
+				checkpoint.Nodes = append([]RotationNode{node}, append(checkpoint.Nodes[:i:i], checkpoint.Nodes[i+1:]...)...)
+			}
+
+			return nil
+		}
+
+		status.Phase = fmt.Sprintf("waiting for unavailable nodes (max %d per zone)", maxUnavailable)
+
+		if err := workflow.Sleep(ctx, 30*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+// countUnavailableNodes returns the number of nodes per zone that are not ready or cordoned.
+func countUnavailableNodes(ctx workflow.Context, clusterName string) (map[string]int, error) {
+	var nodeactivities kubeactivities.Nodes
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.ListNodesInput{
+		ClusterName: clusterName,
+	}
+
+	var output kubeactivities.ListNodesOutput
+
+	err := workflow.ExecuteActivity(ctx, nodeactivities.ListNodes, input).Get(ctx, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	unavailable := map[string]int{}
+
+	for _, node := range output.Nodes {
+		ready := false
+
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+
+		// Outdated nodes cordoned up front still run their pods
+		_, outdated := node.Annotations[kubeactivities.CordonedAnnotation]
+
+		if !ready || (node.Spec.Unschedulable && !outdated) {
+			unavailable[node.Labels[corev1.LabelTopologyZone]]++
+		}
+	}
+
+	return unavailable, nil
+}
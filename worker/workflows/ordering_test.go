@@ -0,0 +1,106 @@
+package workflows
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+func TestOrderNodes(t *testing.T) {
+	createdAt := time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+	nodes := []nodeGroupNode{
+		{Name: "a1", Zone: "eu-west-1a", CreatedAt: createdAt.Add(3 * time.Hour)},
+		{Name: "a2", Zone: "eu-west-1a", CreatedAt: createdAt.Add(1 * time.Hour)},
+		{Name: "b1", Zone: "eu-west-1b", CreatedAt: createdAt.Add(4 * time.Hour)},
+		{Name: "a3", Zone: "eu-west-1a", CreatedAt: createdAt.Add(2 * time.Hour)},
+		{Name: "c1", Zone: "eu-west-1c", CreatedAt: createdAt},
+		{Name: "b2", Zone: "eu-west-1b", CreatedAt: createdAt.Add(5 * time.Hour)},
+	}
+
+	pods := map[string]int{
+		"a1": 10,
+		"a2": 2,
+		"b1": 7,
+		"a3": 2,
+		"c1": 30,
+		// b2 runs no pods
+	}
+
+	testCases := []struct {
+		order    RotationOrder
+		expected []string
+	}{
+		{
+			order:    RotationOrderDefault,
+			expected: []string{"a1", "a2", "b1", "a3", "c1", "b2"},
+		},
+		{
+			order:    RotationOrderZoneRoundRobin,
+			expected: []string{"a1", "b1", "c1", "a2", "b2", "a3"},
+		},
+		{
+			order:    RotationOrderOldestFirst,
+			expected: []string{"c1", "a2", "a3", "a1", "b1", "b2"},
+		},
+		{
+			// Ties keep the original order
+			order:    RotationOrderFewestPodsFirst,
+			expected: []string{"b2", "a2", "a3", "b1", "a1", "c1"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(string(testCase.order), func(t *testing.T) {
+			var suite testsuite.WorkflowTestSuite
+
+			env := suite.NewTestWorkflowEnvironment()
+
+			env.RegisterActivity(&kubeactivities.Nodes{})
+			env.OnActivity((&kubeactivities.Nodes{}).CountPods, mock.Anything, kubeactivities.CountPodsInput{ClusterName: "mark-1"}).
+				Return(&kubeactivities.CountPodsOutput{Pods: pods}, nil)
+
+			env.ExecuteWorkflow(func(ctx workflow.Context) ([]string, error) {
+				ordered, err := orderNodes(ctx, "mark-1", nodes, testCase.order)
+				if err != nil {
+					return nil, err
+				}
+
+				names := make([]string, 0, len(ordered))
+
+				for _, node := range ordered {
+					names = append(names, node.Name)
+				}
+
+				return names, nil
+			})
+
+			if err := env.GetWorkflowError(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var names []string
+
+			if err := env.GetWorkflowResult(&names); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !slices.Equal(names, testCase.expected) {
+				t.Errorf("expected order %v, got %v", testCase.expected, names)
+			}
+
+			// The input is left untouched
+			if nodes[0].Name != "a1" {
+				t.Errorf("input nodes were reordered")
+			}
+		})
+	}
+}
@@ -0,0 +1,608 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// Types of actions in a [ReconcilePlan].
+const (
+	ReconcileActionCreateCluster       = "CreateCluster"
+	ReconcileActionUpgradeControlPlane = "UpgradeControlPlane"
+	ReconcileActionCreateNodeGroup     = "CreateNodeGroup"
+	ReconcileActionUpdateNodeGroup     = "UpdateNodeGroup"
+	ReconcileActionDeleteNodeGroup     = "DeleteNodeGroup"
+)
+
+// ReconcileClusterInput contains the input parameters for the [ReconcileCluster] workflow.
+type ReconcileClusterInput struct {
+	// Cluster is the desired state of the cluster.
+	Cluster cluster.Cluster
+
+	// PlanOnly returns the plan without applying it.
+	PlanOnly bool
+}
+
+// ReconcileClusterOutput contains the return parameters for the [ReconcileCluster] workflow.
+type ReconcileClusterOutput struct {
+	Plan ReconcilePlan
+}
+
+// ReconcilePlan describes the actions necessary to converge a cluster to its desired state.
+type ReconcilePlan struct {
+	// Observed is the state of the cluster the plan was computed from.
+	Observed ObservedClusterState
+
+	// Actions are applied in order.
+	Actions []ReconcileAction
+}
+
+// ObservedClusterState describes the actual state of a cluster.
+type ObservedClusterState struct {
+	Exists bool
+
+	// Version is the Kubernetes version of the control plane.
+	Version string
+
+	// Addons maps installed EKS add-ons to their versions.
+	Addons map[string]string
+
+	// NodeGroups are the names of the existing node groups.
+	NodeGroups []string
+}
+
+// ReconcileAction is a single step of a [ReconcilePlan].
+type ReconcileAction struct {
+	Type string
+
+	// NodeGroup is the name of the node group affected by node group actions.
+	NodeGroup string `json:",omitempty"`
+
+	// CurrentVersion and DesiredVersion describe control plane upgrades.
+	CurrentVersion string `json:",omitempty"`
+	DesiredVersion string `json:",omitempty"`
+
+	// Changes are the node group stack parameters changed by node group updates.
+	Changes []StackParameterChange `json:",omitempty"`
+
+	// OutdatedNodes is the number of nodes rotated by node group updates.
+	OutdatedNodes int `json:",omitempty"`
+}
+
+func (a ReconcileAction) String() string {
+	switch a.Type {
+	case ReconcileActionCreateCluster:
+		return "create cluster"
+
+	case ReconcileActionUpgradeControlPlane:
+		return fmt.Sprintf("upgrade control plane from %s to %s", a.CurrentVersion, a.DesiredVersion)
+
+	case ReconcileActionCreateNodeGroup:
+		return fmt.Sprintf("create node group %s", a.NodeGroup)
+
+	case ReconcileActionUpdateNodeGroup:
+		return fmt.Sprintf("update node group %s (%d changed parameters, %d outdated nodes)", a.NodeGroup, len(a.Changes), a.OutdatedNodes)
+
+	case ReconcileActionDeleteNodeGroup:
+		return fmt.Sprintf("delete node group %s", a.NodeGroup)
+
+	default:
+		return a.Type
+	}
+}
+
+// ReconcileCluster converges a cluster to its desired state.
+//
+// The workflow observes the actual state of the cluster (VPC stack, EKS cluster, add-ons and node group stacks)
+// and computes a plan of actions: creating the cluster if it does not exist yet, upgrading the control plane,
+// creating, updating (see [UpdateNodeGroup]) and deleting node groups.
+//
+// In plan-only mode the workflow returns the plan without applying it.
+// Add-ons are only observed: the cluster spec does not describe them yet.
+func ReconcileCluster(ctx workflow.Context, input ReconcileClusterInput) (*ReconcileClusterOutput, error) {
+	input.Cluster.Default()
+
+	if err := input.Cluster.Validate(); err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Phase: "observing cluster",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
+	pauser := newPauser(ctx, status)
+
+	observed, ngStacks, err := observeCluster(ctx, input.Cluster.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Phase = "planning"
+
+	plan, err := planCluster(ctx, input.Cluster, observed, ngStacks)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, action := range plan.Actions {
+		workflow.GetLogger(ctx).Info("planned action", "action", action.String())
+	}
+
+	output := &ReconcileClusterOutput{
+		Plan: plan,
+	}
+
+	if input.PlanOnly || len(plan.Actions) == 0 {
+		status.Phase = "completed"
+
+		return output, nil
+	}
+
+	status.Total = len(plan.Actions)
+
+	err = applyClusterPlan(ctx, input.Cluster, plan, status, pauser)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Phase = "completed"
+
+	return output, nil
+}
+
+// errVPCStackNotFound is returned by [observeCluster] for clusters not created by this project.
+var errVPCStackNotFound = errors.New("VPC stack not found")
+
+// observeCluster describes the actual state of a cluster.
+//
+// It also returns the node group stacks (keyed by node group name).
+func observeCluster(ctx workflow.Context, clusterName string) (ObservedClusterState, map[string]cftypes.Stack, error) {
+	var cfactivities awsactivities.CloudFormation
+	var eksactivities awsactivities.EKS
+
+	var observed ObservedClusterState
+
+	// Grab cluster stacks
+	var stacks []cftypes.Stack
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacksWithPrefix, clusterName+"-").Get(ctx, &stacks)
+		if err != nil {
+			return observed, nil, err
+		}
+	}
+
+	vpcStackExists, ngStacks := clusterStacks(clusterName, stacks)
+
+	for name := range ngStacks {
+		observed.NodeGroups = append(observed.NodeGroups, name)
+	}
+
+	sort.Strings(observed.NodeGroups)
+
+	// Grab cluster details
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := &eks.DescribeClusterInput{
+			Name: aws.String(clusterName),
+		}
+
+		var output *eks.DescribeClusterOutput
+
+		err := workflow.ExecuteActivity(ctx, eksactivities.DescribeCluster, input).Get(ctx, &output)
+		if err != nil && !isNotFoundError(err) {
+			return observed, nil, err
+		}
+
+		if err == nil {
+			observed.Exists = true
+			observed.Version = aws.ToString(output.Cluster.Version)
+		}
+	}
+
+	// CreateCluster cannot pick up where a previous (failed) attempt left off
+	if !observed.Exists && (vpcStackExists || len(ngStacks) > 0) {
+		return observed, nil, errors.New("cluster is partially provisioned: delete it before reconciling")
+	}
+
+	if observed.Exists && !vpcStackExists {
+		return observed, nil, errVPCStackNotFound
+	}
+
+	if observed.Exists {
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		err := workflow.ExecuteActivity(ctx, eksactivities.DescribeAddonVersions, clusterName).Get(ctx, &observed.Addons)
+		if err != nil {
+			return observed, nil, err
+		}
+	}
+
+	return observed, ngStacks, nil
+}
+
+// clusterStacks picks the VPC stack and the node group stacks of a cluster from stacks with the cluster name as prefix.
+//
+// The prefix may match stacks of other clusters (eg. "prod" and "prod-eu"),
+// so node group stacks are identified by their ClusterName parameter.
+func clusterStacks(clusterName string, stacks []cftypes.Stack) (bool, map[string]cftypes.Stack) {
+	var vpcStackExists bool
+
+	ngStacks := make(map[string]cftypes.Stack)
+
+	for _, stack := range stacks {
+		stackName := aws.ToString(stack.StackName)
+
+		if stackName == fmt.Sprintf("%s-vpc", clusterName) {
+			vpcStackExists = true
+
+			continue
+		}
+
+		if stackParameter(stack.Parameters, "ClusterName") != clusterName {
+			continue
+		}
+
+		ngStacks[strings.TrimPrefix(stackName, clusterName+"-")] = stack
+	}
+
+	return vpcStackExists, ngStacks
+}
+
+func stackParameter(parameters []cftypes.Parameter, key string) string {
+	for _, parameter := range parameters {
+		if aws.ToString(parameter.ParameterKey) == key {
+			return aws.ToString(parameter.ParameterValue)
+		}
+	}
+
+	return ""
+}
+
+func stackOutput(outputs []cftypes.Output, key string) string {
+	for _, output := range outputs {
+		if aws.ToString(output.OutputKey) == key {
+			return aws.ToString(output.OutputValue)
+		}
+	}
+
+	return ""
+}
+
+// planCluster computes the actions necessary to converge the observed cluster to its desired state.
+func planCluster(ctx workflow.Context, desired cluster.Cluster, observed ObservedClusterState, ngStacks map[string]cftypes.Stack) (ReconcilePlan, error) {
+	plan := ReconcilePlan{
+		Observed: observed,
+	}
+
+	if !observed.Exists {
+		plan.Actions = append(plan.Actions, ReconcileAction{
+			Type: ReconcileActionCreateCluster,
+		})
+
+		return plan, nil
+	}
+
+	if observed.Version != desired.Kubernetes.Version {
+		// Fail early if the control plane cannot be upgraded (eg. downgrade)
+		if _, err := nextMinorVersion(observed.Version, desired.Kubernetes.Version); err != nil {
+			return plan, err
+		}
+
+		plan.Actions = append(plan.Actions, ReconcileAction{
+			Type:           ReconcileActionUpgradeControlPlane,
+			CurrentVersion: observed.Version,
+			DesiredVersion: desired.Kubernetes.Version,
+		})
+	}
+
+	desiredNodeGroups := make(map[string]bool, len(desired.NodeGroups))
+
+	for _, ng := range desired.NodeGroups {
+		desiredNodeGroups[ng.Name] = true
+
+		if _, ok := ngStacks[ng.Name]; !ok {
+			plan.Actions = append(plan.Actions, ReconcileAction{
+				Type:      ReconcileActionCreateNodeGroup,
+				NodeGroup: ng.Name,
+			})
+
+			continue
+		}
+
+		ngPlan, err := planNodeGroupUpdate(ctx, desired.Name, ng, nil)
+		if err != nil {
+			return plan, fmt.Errorf("node group(%s): %w", ng.Name, err)
+		}
+
+		outdatedNodes := ngPlan.outdatedNodes()
+
+		if len(ngPlan.Changes) == 0 && len(outdatedNodes) == 0 {
+			continue
+		}
+
+		plan.Actions = append(plan.Actions, ReconcileAction{
+			Type:          ReconcileActionUpdateNodeGroup,
+			NodeGroup:     ng.Name,
+			Changes:       ngPlan.Changes,
+			OutdatedNodes: len(outdatedNodes),
+		})
+	}
+
+	// Remove node groups last to make sure workloads have somewhere to go
+	for _, name := range observed.NodeGroups {
+		if desiredNodeGroups[name] {
+			continue
+		}
+
+		plan.Actions = append(plan.Actions, ReconcileAction{
+			Type:      ReconcileActionDeleteNodeGroup,
+			NodeGroup: name,
+		})
+	}
+
+	return plan, nil
+}
+
+// applyClusterPlan applies the actions of a plan in order.
+func applyClusterPlan(ctx workflow.Context, desired cluster.Cluster, plan ReconcilePlan, status *Status, pauser *pauser) error {
+	nodeGroups := make(map[string]cluster.NodeGroup, len(desired.NodeGroups))
+
+	for _, ng := range desired.NodeGroups {
+		nodeGroups[ng.Name] = ng
+	}
+
+	// The aws-auth ConfigMap has to be updated after creating or deleting node groups
+	var authChanged bool
+
+	for _, action := range plan.Actions {
+		if err := pauser.wait(ctx); err != nil {
+			return err
+		}
+
+		status.Phase = action.String()
+
+		switch action.Type {
+		case ReconcileActionCreateCluster:
+			cwo := workflow.ChildWorkflowOptions{
+				WorkflowID: fmt.Sprintf("%s/create", workflow.GetInfo(ctx).WorkflowExecution.ID),
+			}
+			ctx := workflow.WithChildOptions(ctx, cwo)
+
+			input := CreateClusterInput{
+				Cluster: desired,
+			}
+
+			err := workflow.ExecuteChildWorkflow(ctx, CreateCluster, input).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+
+		case ReconcileActionUpgradeControlPlane:
+			err := upgradeControlPlane(ctx, desired.Name, action.CurrentVersion, action.DesiredVersion, desired.Maintenance, status, pauser)
+			if err != nil {
+				return err
+			}
+
+		case ReconcileActionCreateNodeGroup:
+			vpc, err := describeVPCStack(ctx, fmt.Sprintf("%s-vpc", desired.Name))
+			if err != nil {
+				return err
+			}
+
+			controlPlane, err := describeControlPlane(ctx, desired.Name)
+			if err != nil {
+				return err
+			}
+
+			_, err = createNodeGroup(ctx, desired.Name, nodeGroups[action.NodeGroup], vpc, controlPlane)
+			if err != nil {
+				return err
+			}
+
+			authChanged = true
+
+		case ReconcileActionUpdateNodeGroup:
+			input := UpdateNodeGroupInput{
+				ClusterName: desired.Name,
+				NodeGroup:   nodeGroups[action.NodeGroup],
+				Maintenance: desired.Maintenance,
+			}
+
+			err := runNodeGroupRotation(ctx, desired.Name, action.NodeGroup, UpdateNodeGroup, input, nil, pauser)
+			if err != nil {
+				return err
+			}
+
+		case ReconcileActionDeleteNodeGroup:
+			// Draining nodes is disruptive
+			if err := waitForDisruption(ctx, desired.Maintenance, status, pauser); err != nil {
+				return err
+			}
+
+			err := deleteNodeGroup(ctx, desired.Name, action.NodeGroup)
+			if err != nil {
+				return err
+			}
+
+			authChanged = true
+
+		default:
+			return fmt.Errorf("unknown action: %s", action.Type)
+		}
+
+		status.Completed++
+	}
+
+	if authChanged {
+		status.Phase = "configuring cluster"
+
+		return updateAuthConfigMap(ctx, desired.Name)
+	}
+
+	return nil
+}
+
+// deleteNodeGroup drains the nodes of a node group and deletes its stack.
+//
+// Every node of the node group is cordoned up front (so evicted pods never land on another node of the node group),
+// and the node group is only drained if the pods of its nodes fit on the rest of the cluster.
+func deleteNodeGroup(ctx workflow.Context, clusterName string, nodeGroupName string) (err error) {
+	var nodeactivities kubeactivities.Nodes
+
+	stackName := nodeGroupStackName(clusterName, nodeGroupName)
+
+	asgName, _, err := describeNodeGroupStack(ctx, stackName)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := listNodeGroupNodes(ctx, clusterName, asgName)
+	if err != nil {
+		return err
+	}
+
+	checkpoint := &RotationCheckpoint{
+		AutoScalingGroupName: asgName,
+	}
+
+	for _, node := range nodes {
+		checkpoint.Nodes = append(checkpoint.Nodes, RotationNode{
+			Name:       node.Name,
+			InstanceID: node.InstanceID,
+			Zone:       node.Zone,
+		})
+	}
+
+	if err := markOutdatedNodes(ctx, clusterName, checkpoint, true); err != nil {
+		return err
+	}
+
+	// Make the nodes schedulable again if the node group cannot be drained
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		ctx, _ := workflow.NewDisconnectedContext(ctx)
+
+		if unmarkErr := unmarkOutdatedNodes(ctx, clusterName, checkpoint); unmarkErr != nil {
+			workflow.GetLogger(ctx).Error("failed to unmark nodes of node group", "nodeGroup", nodeGroupName, "error", unmarkErr)
+		}
+	}()
+
+	nodeNames := rotationNodeNames(checkpoint.Nodes)
+
+	for _, node := range checkpoint.Nodes {
+		unschedulable, err := simulateDrain(ctx, clusterName, node.Name, nodeNames)
+		if err != nil {
+			return err
+		}
+
+		if len(unschedulable) > 0 {
+			pods := make([]string, 0, len(unschedulable))
+
+			for _, pod := range unschedulable {
+				pods = append(pods, pod.String())
+			}
+
+			return fmt.Errorf("pods of node %s would not fit on the rest of the cluster: %s", node.Name, strings.Join(pods, "; "))
+		}
+	}
+
+	for _, node := range checkpoint.Nodes {
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.DrainNodeInput{
+			ClusterName: clusterName,
+			NodeName:    node.Name,
+		}
+
+		err := workflow.ExecuteActivity(ctx, nodeactivities.DrainNode, input).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return deleteStack(ctx, stackName)
+}
+
+// updateAuthConfigMap grants the node instance roles of every existing node group access to the cluster.
+func updateAuthConfigMap(ctx workflow.Context, clusterName string) error {
+	var cfactivities awsactivities.CloudFormation
+	var clusterSetupActivities kubeactivities.ClusterSetup
+
+	var stacks []cftypes.Stack
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacksWithPrefix, clusterName+"-").Get(ctx, &stacks)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, ngStacks := clusterStacks(clusterName, stacks)
+
+	var nodeInstanceRoleARNs []string
+
+	for _, stack := range ngStacks {
+		nodeInstanceRoleARNs = append(nodeInstanceRoleARNs, stackOutput(stack.Outputs, "NodeInstanceRole"))
+	}
+
+	// Map iteration order is random, but the activity input has to be deterministic
+	sort.Strings(nodeInstanceRoleARNs)
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.UpdateAuthConfigMapInput{
+		ClusterName:          clusterName,
+		NodeInstanceRoleARNs: nodeInstanceRoleARNs,
+	}
+
+	return workflow.ExecuteActivity(ctx, clusterSetupActivities.UpdateAuthConfigMap, input).Get(ctx, nil)
+}
+
+// isNotFoundError reports whether an activity failed because the resource does not exist.
+func isNotFoundError(err error) bool {
+	var appErr *temporal.ApplicationError
+
+	return errors.As(err, &appErr) && appErr.Type() == awsactivities.ErrorTypeNotFound
+}
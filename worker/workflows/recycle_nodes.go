@@ -0,0 +1,238 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// DefaultMaxRecycledNodes is the default number of nodes recycled by a single [RecycleNodes] workflow.
+const DefaultMaxRecycledNodes = 10
+
+// RecycleNodesInput contains the input parameters for the [RecycleNodes] workflow.
+type RecycleNodesInput struct {
+	ClusterName   string
+	NodeGroupName string
+
+	// MaxAge is the age after which nodes are recycled.
+	MaxAge time.Duration
+
+	// Maintenance restricts node rotation to maintenance windows.
+	Maintenance cluster.Maintenance
+
+	// HealthCheck waits for workloads to recover after every node replacement.
+	HealthCheck HealthCheckOptions
+
+	// SchedulingCheck makes sure the pods of a node fit on the remaining nodes before draining it.
+	SchedulingCheck SchedulingCheckOptions
+
+	// MaxNodes is the number of nodes recycled by the workflow (oldest first).
+	// Nodes over the limit are recycled by the next workflow.
+	// Defaults to [DefaultMaxRecycledNodes].
+	MaxNodes int
+
+	// MaxNodesPerRun is the number of nodes rotated in a single workflow run before continuing as new.
+	// Defaults to [DefaultMaxNodesPerRun].
+	MaxNodesPerRun int
+
+	// MaxHistoryLength is the number of history events after which the workflow continues as new.
+	// Defaults to [DefaultMaxHistoryLength].
+	MaxHistoryLength int
+
+	// Checkpoint carries rotation progress over to the next run when the workflow continues as new.
+	// It is populated by the workflow itself and should be left empty when starting a new workflow.
+	Checkpoint *RotationCheckpoint
+}
+
+func (i RecycleNodesInput) Validate() error {
+	if i.ClusterName == "" {
+		return errors.New("cluster name is required")
+	}
+
+	if i.NodeGroupName == "" {
+		return errors.New("node group name is required")
+	}
+
+	if i.MaxAge <= 0 {
+		return errors.New("max age must be positive")
+	}
+
+	if err := i.Maintenance.Validate(); err != nil {
+		return fmt.Errorf("maintenance: %w", err)
+	}
+
+	if err := i.HealthCheck.Validate(); err != nil {
+		return fmt.Errorf("health check: %w", err)
+	}
+
+	if err := i.SchedulingCheck.Validate(); err != nil {
+		return fmt.Errorf("scheduling check: %w", err)
+	}
+
+	if i.MaxNodes < 0 {
+		return errors.New("max nodes must not be negative")
+	}
+
+	if i.MaxNodesPerRun < 0 {
+		return errors.New("max nodes per run must not be negative")
+	}
+
+	if i.MaxHistoryLength < 0 {
+		return errors.New("max history length must not be negative")
+	}
+
+	return nil
+}
+
+// RecycleNodesOutput contains the return parameters for the [RecycleNodes] workflow.
+type RecycleNodesOutput struct{}
+
+// RecycleNodes replaces the nodes of a node group that are older than a maximum age.
+//
+// The age of a node is measured from the earlier of its creation timestamp and the launch time of its instance.
+// Nodes are rotated one by one (oldest first) the same way as in [UpdateNodeGroup].
+//
+// The workflow is meant to be run on a Temporal schedule.
+// It runs under [NodeGroupRotationID] (the schedule's workflow runs it as a child workflow), so it never rotates nodes next to [UpdateNodeGroup].
+func RecycleNodes(ctx workflow.Context, input RecycleNodesInput) (_ *RecycleNodesOutput, err error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	// Started under a different ID (eg. by a schedule): take the node group lock by running as a child workflow
+	if workflow.GetInfo(ctx).WorkflowExecution.ID != NodeGroupRotationID(input.ClusterName, input.NodeGroupName) {
+		var output RecycleNodesOutput
+
+		err := runNodeGroupRotation(ctx, input.ClusterName, input.NodeGroupName, RecycleNodes, input, &output, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return &output, nil
+	}
+
+	checkpoint := input.Checkpoint
+
+	status := &Status{
+		Phase: "collecting expired nodes",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil {
+		status.Paused = checkpoint.Paused
+	}
+
+	pauser := newPauser(ctx, status)
+
+	defer func() {
+		err = releaseNodeGroupOnFailure(ctx, input.ClusterName, checkpoint, err)
+	}()
+
+	// Collect nodes during the first run only
+	if checkpoint == nil {
+		var err error
+
+		checkpoint, err = collectExpiredNodes(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(checkpoint.Nodes) > 0 {
+			if err := suspendScaling(ctx, input.ClusterName, checkpoint); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	status.Phase = "recycling nodes"
+
+	options := rotationOptions{
+		MaxNodesPerRun:   input.MaxNodesPerRun,
+		MaxHistoryLength: input.MaxHistoryLength,
+		Maintenance:      input.Maintenance,
+		HealthCheck:      input.HealthCheck,
+		SchedulingCheck:  input.SchedulingCheck,
+	}
+
+	done, err := rotateNodes(ctx, input.ClusterName, checkpoint, options, status, pauser)
+	if err != nil {
+		return nil, err
+	}
+
+	if !done {
+		input.Checkpoint = checkpoint
+
+		return nil, workflow.NewContinueAsNewError(ctx, RecycleNodes, input)
+	}
+
+	if err := releaseNodeGroup(ctx, input.ClusterName, checkpoint); err != nil {
+		return nil, err
+	}
+
+	status.Phase = "completed"
+
+	return nil, nil
+}
+
+// collectExpiredNodes collects the nodes of a node group that are older than the maximum age.
+func collectExpiredNodes(ctx workflow.Context, input RecycleNodesInput) (*RotationCheckpoint, error) {
+	asgName, _, err := describeNodeGroupStack(ctx, nodeGroupStackName(input.ClusterName, input.NodeGroupName))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, err := listNodeGroupNodes(ctx, input.ClusterName, asgName)
+	if err != nil {
+		return nil, err
+	}
+
+	maxNodes := input.MaxNodes
+	if maxNodes == 0 {
+		maxNodes = DefaultMaxRecycledNodes
+	}
+
+	deadline := workflow.Now(ctx).Add(-input.MaxAge)
+
+	var expiredNodes []nodeGroupNode
+
+	for _, node := range nodes {
+		if node.CreatedAt.After(deadline) {
+			continue
+		}
+
+		expiredNodes = append(expiredNodes, node)
+	}
+
+	// Recycle the oldest nodes first
+	sort.SliceStable(expiredNodes, func(i, j int) bool {
+		return expiredNodes[i].CreatedAt.Before(expiredNodes[j].CreatedAt)
+	})
+
+	workflow.GetLogger(ctx).Info("expired nodes", "expired", len(expiredNodes), "total", len(nodes), "max", maxNodes)
+
+	if len(expiredNodes) > maxNodes {
+		expiredNodes = expiredNodes[:maxNodes]
+	}
+
+	checkpoint := &RotationCheckpoint{
+		AutoScalingGroupName: asgName,
+	}
+
+	for _, node := range expiredNodes {
+		checkpoint.Nodes = append(checkpoint.Nodes, RotationNode{
+			Name:       node.Name,
+			InstanceID: node.InstanceID,
+			Zone:       node.Zone,
+		})
+	}
+
+	return checkpoint, nil
+}
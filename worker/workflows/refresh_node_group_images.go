@@ -0,0 +1,135 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// RefreshNodeGroupImagesInput contains the input parameters for the [RefreshNodeGroupImages] workflow.
+type RefreshNodeGroupImagesInput struct {
+	ClusterName string
+
+	// NodeGroups are the names of the node groups to refresh.
+	// Defaults to every node group of the cluster.
+	NodeGroups []string
+
+	// Maintenance restricts node rotation to maintenance windows.
+	Maintenance cluster.Maintenance
+}
+
+func (i RefreshNodeGroupImagesInput) Validate() error {
+	if i.ClusterName == "" {
+		return errors.New("cluster name is required")
+	}
+
+	if err := i.Maintenance.Validate(); err != nil {
+		return fmt.Errorf("maintenance: %w", err)
+	}
+
+	return nil
+}
+
+// RefreshNodeGroupImagesOutput contains the return parameters for the [RefreshNodeGroupImages] workflow.
+type RefreshNodeGroupImagesOutput struct{}
+
+// RefreshNodeGroupImages rolls out the latest image recommended for the Kubernetes version of each node group.
+//
+// EKS regularly publishes new patch releases of its images (eg. with security fixes) for the same Kubernetes version.
+// Each node group is updated (one by one) using the [UpdateNodeGroup] workflow based on the current state of its stack
+// (see [LiveNodeGroupUpdate]), which replaces nodes not running the image currently recommended by EKS.
+//
+// The workflow is meant to be run on a Temporal schedule.
+func RefreshNodeGroupImages(ctx workflow.Context, input RefreshNodeGroupImagesInput) (*RefreshNodeGroupImagesOutput, error) {
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Phase: "refreshing node group images",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
+	pauser := newPauser(ctx, status)
+
+	nodeGroups := input.NodeGroups
+
+	if len(nodeGroups) == 0 {
+		var err error
+
+		nodeGroups, err = listNodeGroups(ctx, input.ClusterName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status.Total = len(nodeGroups)
+
+	for _, ng := range nodeGroups {
+		if err := pauser.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		status.Phase = fmt.Sprintf("refreshing node group %s", ng)
+
+		input := UpdateNodeGroupInput{
+			ClusterName: input.ClusterName,
+			NodeGroup: cluster.NodeGroup{
+				Name: ng,
+			},
+			Live:        &LiveNodeGroupUpdate{},
+			Maintenance: input.Maintenance,
+		}
+
+		err := runNodeGroupRotation(ctx, input.ClusterName, ng, UpdateNodeGroup, input, nil, pauser)
+		if err != nil {
+			return nil, err
+		}
+
+		status.Completed++
+	}
+
+	status.Phase = "completed"
+
+	return nil, nil
+}
+
+// listNodeGroups returns the names of the existing node groups of a cluster.
+func listNodeGroups(ctx workflow.Context, clusterName string) ([]string, error) {
+	var cfactivities awsactivities.CloudFormation
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	var stacks []cftypes.Stack
+
+	err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacksWithPrefix, clusterName+"-").Get(ctx, &stacks)
+	if err != nil {
+		return nil, err
+	}
+
+	_, ngStacks := clusterStacks(clusterName, stacks)
+
+	nodeGroups := make([]string, 0, len(ngStacks))
+
+	for name := range ngStacks {
+		nodeGroups = append(nodeGroups, name)
+	}
+
+	// Map iteration order is random, but the workflow has to be deterministic
+	sort.Strings(nodeGroups)
+
+	return nodeGroups, nil
+}
@@ -0,0 +1,285 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/cftemplates"
+)
+
+// SignalRollback is the name of the signal rolling back a node group update.
+const SignalRollback = "rollback"
+
+// ErrorTypeRolledBack is the type of the error returned by [UpdateNodeGroup] after rolling back the update.
+//
+// The details of the error contain a [RollbackReport].
+const ErrorTypeRolledBack = "RolledBack"
+
+// RollbackRequest is the payload of [SignalRollback].
+//
+// Signals are recorded in the workflow history, so the history serves as an audit log of rollback requests.
+type RollbackRequest struct {
+	// Requester identifies the person requesting the rollback.
+	Requester string
+
+	Reason string
+}
+
+// NodeGroupRollback records the state of a node group stack before an update, so that the update can be rolled back.
+type NodeGroupRollback struct {
+	StackName string
+
+	// Parameters are the stack parameters before the update.
+	Parameters []cftypes.Parameter
+
+	// Image is the image ID nodes were launched from before the update.
+	Image string
+
+	// Changes are the parameter changes applied by the update.
+	Changes []StackParameterChange
+
+	// UpdatedAt is the time the stack update started. Nodes launched after it run the updated configuration.
+	UpdatedAt time.Time
+}
+
+// stackParameters returns the parameters reverting the node group stack.
+//
+// The previous image is pinned, because the SSM parameter may point to a new image by now.
+func (r NodeGroupRollback) stackParameters() []cftypes.Parameter {
+	parameters := make([]cftypes.Parameter, 0, len(r.Parameters))
+
+	for _, parameter := range r.Parameters {
+		value := aws.ToString(parameter.ParameterValue)
+
+		if aws.ToString(parameter.ParameterKey) == "NodeImageId" && r.Image != "" {
+			value = r.Image
+		}
+
+		parameters = append(parameters, cftypes.Parameter{
+			ParameterKey:   parameter.ParameterKey,
+			ParameterValue: aws.String(value),
+		})
+	}
+
+	return parameters
+}
+
+// RollbackReport describes a rolled back node group update.
+type RollbackReport struct {
+	Reason string
+
+	// Requester identifies the person requesting the rollback (empty if the rollback was triggered by a failure).
+	Requester string
+
+	// Reverted are the stack parameter changes that were reverted.
+	Reverted []StackParameterChange
+
+	// Image is the image ID the node group was rolled back to.
+	Image string
+
+	// Nodes are the nodes replaced to roll back.
+	Nodes []string
+}
+
+// rollbackRequestedError is returned when a rollback is requested while rotating nodes.
+type rollbackRequestedError struct {
+	Request RollbackRequest
+}
+
+func (e *rollbackRequestedError) Error() string {
+	return fmt.Sprintf("rollback requested by %s: %s", e.Request.Requester, e.Request.Reason)
+}
+
+// rollbacker handles rollback signals.
+type rollbacker struct {
+	request *RollbackRequest
+
+	rollbackCh workflow.ReceiveChannel
+}
+
+// newRollbacker listens to rollback signals.
+//
+// A rollback request resumes a paused workflow, so it can proceed to roll back.
+func newRollbacker(ctx workflow.Context, pauser *pauser) *rollbacker {
+	r := &rollbacker{
+		rollbackCh: workflow.GetSignalChannel(ctx, SignalRollback),
+	}
+
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var request RollbackRequest
+
+			r.rollbackCh.Receive(ctx, &request)
+
+			r.receive(ctx, request, pauser)
+		}
+	})
+
+	return r
+}
+
+func (r *rollbacker) receive(ctx workflow.Context, request RollbackRequest, pauser *pauser) {
+	logger := workflow.GetLogger(ctx)
+
+	if r.request != nil {
+		logger.Warn("ignoring rollback request: rollback already requested", "requester", request.Requester)
+
+		return
+	}
+
+	logger.Info("rollback requested", "requester", request.Requester, "reason", request.Reason)
+
+	r.request = &request
+
+	pauser.resume(ctx)
+}
+
+// err returns a [rollbackRequestedError] if a rollback was requested.
+func (r *rollbacker) err() error {
+	if r.request == nil {
+		return nil
+	}
+
+	return &rollbackRequestedError{
+		Request: *r.request,
+	}
+}
+
+// drain processes signals that arrived but haven't been handled yet.
+//
+// Call it before continuing as new to avoid losing signals.
+func (r *rollbacker) drain(ctx workflow.Context, pauser *pauser) {
+	var request RollbackRequest
+
+	for r.rollbackCh.ReceiveAsync(&request) {
+		r.receive(ctx, request, pauser)
+	}
+}
+
+// rollbackRequest returns the rollback request warranted by an error (if any).
+//
+// Explicit rollback requests are always honored, failed health checks, readiness checks (eg. of the canary) and approvals
+// only trigger a rollback with [RolloutOptions.RollbackOnFailure].
+func rollbackRequest(err error, rollout RolloutOptions) (RollbackRequest, bool) {
+	var requested *rollbackRequestedError
+	if errors.As(err, &requested) {
+		return requested.Request, true
+	}
+
+	if rollout.RollbackOnFailure && (errors.Is(err, errWorkloadsUnhealthy) || errors.Is(err, errNodeGroupNotReady) || errors.Is(err, errApprovalRejected)) {
+		return RollbackRequest{
+			Reason: err.Error(),
+		}, true
+	}
+
+	return RollbackRequest{}, false
+}
+
+// startNodeGroupRollback reverts the node group stack to its state before the update
+// and collects the nodes launched since the update in the checkpoint, so they can be rotated back.
+func startNodeGroupRollback(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, request RollbackRequest, status *Status) error {
+	var cfactivities awsactivities.CloudFormation
+
+	previous := checkpoint.Rollback
+
+	logger := workflow.GetLogger(ctx)
+
+	logger.Warn("rolling back node group update", "stack", previous.StackName, "requester", request.Requester, "reason", request.Reason)
+
+	status.Phase = "rolling back node group stack"
+
+	report := &RollbackReport{
+		Reason:    request.Reason,
+		Requester: request.Requester,
+		Image:     previous.Image,
+	}
+
+	for _, change := range previous.Changes {
+		report.Reverted = append(report.Reverted, StackParameterChange{
+			Key:      change.Key,
+			Current:  change.Desired,
+			Desired:  change.Current,
+			Replaces: change.Replaces,
+		})
+	}
+
+	// Revert node group stack
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := &cloudformation.UpdateStackInput{
+			StackName:    aws.String(previous.StackName),
+			TemplateBody: aws.String(cftemplates.NodeGroup()),
+			Capabilities: []cftypes.Capability{
+				cftypes.CapabilityCapabilityIam,
+			},
+			Parameters: previous.stackParameters(),
+		}
+
+		err := workflow.ExecuteActivity(ctx, cfactivities.UpdateStack, input).Get(ctx, nil)
+		if isNoUpdatesError(err) {
+			logger.Info("node group stack is already rolled back")
+		} else if err != nil {
+			return err
+		} else {
+			ao := workflow.ActivityOptions{
+				StartToCloseTimeout: 10 * time.Minute,
+				HeartbeatTimeout:    30 * time.Second,
+			}
+			ctx := workflow.WithActivityOptions(ctx, ao)
+
+			err := workflow.ExecuteActivity(ctx, cfactivities.WaitForUpdateStack, previous.StackName).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, change := range report.Reverted {
+		logger.Info("node group parameter rolled back", "parameter", change.Key, "from", change.Current, "to", change.Desired)
+	}
+
+	nodes, err := listNodeGroupNodes(ctx, clusterName, checkpoint.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+
+	checkpoint.Nodes = nil
+	checkpoint.RotatedNodes = 0
+
+	for _, node := range nodes {
+		if node.CreatedAt.Before(previous.UpdatedAt) {
+			continue
+		}
+
+		checkpoint.Nodes = append(checkpoint.Nodes, RotationNode{
+			Name:       node.Name,
+			InstanceID: node.InstanceID,
+			Zone:       node.Zone,
+		})
+
+		report.Nodes = append(report.Nodes, node.Name)
+	}
+
+	logger.Info("rotating nodes back", "nodes", report.Nodes)
+
+	checkpoint.RollbackReport = report
+
+	return nil
+}
+
+// rolledBackError returns the error reporting a completed rollback.
+func rolledBackError(report RollbackReport) error {
+	return temporal.NewNonRetryableApplicationError(fmt.Sprintf("node group update rolled back: %s", report.Reason), ErrorTypeRolledBack, nil, report)
+}
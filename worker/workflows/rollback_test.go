@@ -0,0 +1,229 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+func TestRollbackRequest(t *testing.T) {
+	requested := &rollbackRequestedError{
+		Request: RollbackRequest{Requester: "mark", Reason: "broken image"},
+	}
+
+	testCases := []struct {
+		name    string
+		err     error
+		rollout RolloutOptions
+
+		request  RollbackRequest
+		rollback bool
+	}{
+		{
+			name:     "requested",
+			err:      fmt.Errorf("rotating nodes: %w", requested),
+			request:  requested.Request,
+			rollback: true,
+		},
+		{
+			name:     "requested with rollback on failure",
+			err:      requested,
+			rollout:  RolloutOptions{RollbackOnFailure: true},
+			request:  requested.Request,
+			rollback: true,
+		},
+		{
+			name: "failure",
+			err:  fmt.Errorf("canary: %w", errNodeGroupNotReady),
+		},
+		{
+			name:     "failure with rollback on failure",
+			err:      fmt.Errorf("canary: %w", errNodeGroupNotReady),
+			rollout:  RolloutOptions{RollbackOnFailure: true},
+			request:  RollbackRequest{Reason: fmt.Sprintf("canary: %s", errNodeGroupNotReady)},
+			rollback: true,
+		},
+		{
+			name:     "unhealthy workloads with rollback on failure",
+			err:      errWorkloadsUnhealthy,
+			rollout:  RolloutOptions{RollbackOnFailure: true},
+			request:  RollbackRequest{Reason: errWorkloadsUnhealthy.Error()},
+			rollback: true,
+		},
+		{
+			name:     "rejected approval with rollback on failure",
+			err:      errApprovalRejected,
+			rollout:  RolloutOptions{RollbackOnFailure: true},
+			request:  RollbackRequest{Reason: errApprovalRejected.Error()},
+			rollback: true,
+		},
+		{
+			// Other errors (eg. activity failures) are not related to the update
+			name:    "other error with rollback on failure",
+			err:     errors.New("something went wrong"),
+			rollout: RolloutOptions{RollbackOnFailure: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			request, rollback := rollbackRequest(testCase.err, testCase.rollout)
+
+			if rollback != testCase.rollback {
+				t.Fatalf("expected rollback to be %t, got %t", testCase.rollback, rollback)
+			}
+
+			if request != testCase.request {
+				t.Errorf("expected request %+v, got %+v", testCase.request, request)
+			}
+		})
+	}
+}
+
+func TestStartNodeGroupRollback(t *testing.T) {
+	updatedAt := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+
+	nodes := []corev1.Node{
+		testNode("old", "i-old", "eu-west-1a", updatedAt.Add(-time.Hour)),
+		testNode("new-1", "i-new-1", "eu-west-1a", updatedAt.Add(10*time.Minute)),
+		testNode("new-2", "i-new-2", "eu-west-1b", updatedAt.Add(20*time.Minute)),
+
+		// Belongs to a different node group
+		testNode("other", "i-other", "eu-west-1a", updatedAt.Add(30*time.Minute)),
+	}
+
+	instances := map[string]awsactivities.InstanceSummary{
+		"i-old": {ImageID: "ami-old", LaunchTime: updatedAt.Add(-time.Hour)},
+
+		// The node registered after the instance launched
+		"i-new-1": {ImageID: "ami-new", LaunchTime: updatedAt.Add(5 * time.Minute)},
+		"i-new-2": {ImageID: "ami-new", LaunchTime: updatedAt.Add(15 * time.Minute)},
+	}
+
+	testCases := []struct {
+		name      string
+		updateErr error
+	}{
+		{
+			name: "stack updated",
+		},
+		{
+			// The rollback was interrupted after updating the stack
+			name:      "stack already rolled back",
+			updateErr: temporal.NewNonRetryableApplicationError("no updates are to be performed", awsactivities.ErrorTypeNoUpdates, nil),
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			env := newTestWorkflowEnvironment(t)
+
+			env.OnActivity((&awsactivities.CloudFormation{}).UpdateStack, mock.Anything, mock.MatchedBy(func(input *cloudformation.UpdateStackInput) bool {
+				parameters := map[string]string{}
+
+				for _, parameter := range input.Parameters {
+					parameters[aws.ToString(parameter.ParameterKey)] = aws.ToString(parameter.ParameterValue)
+				}
+
+				return aws.ToString(input.StackName) == "mark-1-ng-1" &&
+					parameters["NodeInstanceType"] == "t3.medium" &&
+					parameters["NodeImageId"] == "ami-old"
+			})).Return(&cloudformation.UpdateStackOutput{}, testCase.updateErr).Once()
+
+			waitForUpdateStack := env.OnActivity((&awsactivities.CloudFormation{}).WaitForUpdateStack, mock.Anything, "mark-1-ng-1").Return(nil)
+			if testCase.updateErr != nil {
+				waitForUpdateStack.Never()
+			} else {
+				waitForUpdateStack.Once()
+			}
+
+			env.OnActivity((&awsactivities.EC2{}).DescribeInstanceSummaries, mock.Anything, mock.Anything).Return(instances, nil)
+			env.OnActivity((&kubeactivities.Nodes{}).ListNodes, mock.Anything, kubeactivities.ListNodesInput{ClusterName: "mark-1"}).
+				Return(&kubeactivities.ListNodesOutput{Nodes: nodes}, nil)
+
+			env.ExecuteWorkflow(func(ctx workflow.Context) (RotationCheckpoint, error) {
+				checkpoint := RotationCheckpoint{
+					AutoScalingGroupName: "mark-1-ng-1-asg",
+					Nodes:                []RotationNode{{Name: "old", InstanceID: "i-old"}},
+					RotatedNodes:         2,
+					Rollback: &NodeGroupRollback{
+						StackName:  "mark-1-ng-1",
+						Parameters: stackParameters("NodeInstanceType", "t3.medium", "NodeImageId", ""),
+						Image:      "ami-old",
+						Changes: []StackParameterChange{
+							{Key: "NodeInstanceType", Current: "t3.medium", Desired: "t3.large", Replaces: true},
+						},
+						UpdatedAt: updatedAt,
+					},
+				}
+
+				request := RollbackRequest{Requester: "mark", Reason: "broken image"}
+
+				err := startNodeGroupRollback(ctx, "mark-1", &checkpoint, request, &Status{})
+
+				return checkpoint, err
+			})
+
+			if err := env.GetWorkflowError(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var checkpoint RotationCheckpoint
+
+			if err := env.GetWorkflowResult(&checkpoint); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			expectedNodes := []RotationNode{
+				{Name: "new-1", InstanceID: "i-new-1", Zone: "eu-west-1a"},
+				{Name: "new-2", InstanceID: "i-new-2", Zone: "eu-west-1b"},
+			}
+
+			if !reflect.DeepEqual(checkpoint.Nodes, expectedNodes) {
+				t.Errorf("expected nodes %+v, got %+v", expectedNodes, checkpoint.Nodes)
+			}
+
+			if checkpoint.RotatedNodes != 0 {
+				t.Errorf("expected rotated nodes to be reset, got %d", checkpoint.RotatedNodes)
+			}
+
+			report := checkpoint.RollbackReport
+			if report == nil {
+				t.Fatal("expected a rollback report")
+			}
+
+			if report.Requester != "mark" || report.Reason != "broken image" || report.Image != "ami-old" {
+				t.Errorf("unexpected rollback report: %+v", report)
+			}
+
+			if want := []string{"new-1", "new-2"}; !slices.Equal(report.Nodes, want) {
+				t.Errorf("expected rolled back nodes %v, got %v", want, report.Nodes)
+			}
+
+			expectedReverted := []StackParameterChange{
+				{Key: "NodeInstanceType", Current: "t3.large", Desired: "t3.medium", Replaces: true},
+			}
+
+			if !reflect.DeepEqual(report.Reverted, expectedReverted) {
+				t.Errorf("expected reverted changes %+v, got %+v", expectedReverted, report.Reverted)
+			}
+		})
+	}
+}
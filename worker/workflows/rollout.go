@@ -0,0 +1,223 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// DefaultCanaryTimeout is the default time the replacement of the canary node has to become healthy.
+const DefaultCanaryTimeout = 15 * time.Minute
+
+// errNodeGroupNotReady is returned when a node group does not become ready after rotating a node.
+var errNodeGroupNotReady = errors.New("node group did not become ready")
+
+// RolloutOptions adds gates to node group rollouts.
+type RolloutOptions struct {
+	// Canary rotates a single node first and waits for the node group to become healthy before rotating the rest.
+	Canary bool
+
+	// CanaryTimeout is the time the node group has to become healthy after rotating the canary.
+	// Defaults to [DefaultCanaryTimeout].
+	CanaryTimeout time.Duration
+
+	// ApproveCanary waits for an [Approval] (at [ApprovalGateCanary]) after the canary proved healthy.
+	ApproveCanary bool
+
+	// ApproveNodeGroups waits for an [Approval] before updating every node group but the first
+	// (in workflows updating multiple node groups).
+	ApproveNodeGroups bool
+
+	// ApprovalTimeout is the time the workflow waits for an approval before failing.
+	// Defaults to [DefaultApprovalTimeout].
+	ApprovalTimeout time.Duration
+
+	// HealthCheck waits for workloads to recover after every node replacement (including the canary).
+	HealthCheck HealthCheckOptions
+
+	// SchedulingCheck makes sure the pods of a node fit on the remaining nodes before draining it.
+	SchedulingCheck SchedulingCheckOptions
+
+	// Order is the order nodes are rotated in.
+	Order RotationOrder
+
+	// MaxUnavailablePerZone limits the number of unavailable (not ready or cordoned) nodes per availability zone
+	// across the cluster: nodes in zones at the limit are rotated later. Zero means no limit.
+	MaxUnavailablePerZone int
+
+	// CordonOutdated taints every outdated node with a PreferNoSchedule taint up front
+	// and cordons them once there is enough replacement capacity, so that evicted pods do not land on nodes replaced later.
+	// The nodes are uncordoned if the update fails or is aborted.
+	CordonOutdated bool
+
+	// RollbackOnFailure rolls the node group update back if workloads do not recover,
+	// the canary does not become ready or it is rejected.
+	RollbackOnFailure bool
+}
+
+func (o RolloutOptions) Validate() error {
+	if o.ApproveCanary && !o.Canary {
+		return errors.New("approving the canary requires a canary")
+	}
+
+	if o.CanaryTimeout < 0 {
+		return errors.New("canary timeout must not be negative")
+	}
+
+	if o.ApprovalTimeout < 0 {
+		return errors.New("approval timeout must not be negative")
+	}
+
+	if err := o.HealthCheck.Validate(); err != nil {
+		return fmt.Errorf("health check: %w", err)
+	}
+
+	if err := o.SchedulingCheck.Validate(); err != nil {
+		return fmt.Errorf("scheduling check: %w", err)
+	}
+
+	if err := o.Order.Validate(); err != nil {
+		return err
+	}
+
+	if o.MaxUnavailablePerZone < 0 {
+		return errors.New("max unavailable nodes per zone must not be negative")
+	}
+
+	return nil
+}
+
+func (o RolloutOptions) withDefaults() RolloutOptions {
+	if o.CanaryTimeout == 0 {
+		o.CanaryTimeout = DefaultCanaryTimeout
+	}
+
+	if o.ApprovalTimeout == 0 {
+		o.ApprovalTimeout = DefaultApprovalTimeout
+	}
+
+	return o
+}
+
+// rotateCanary rotates the first node in the checkpoint and waits for the node group to become healthy (and for an approval if necessary).
+func rotateCanary(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, rollout RolloutOptions, maintenance cluster.Maintenance, status *Status, pauser *pauser) error {
+	rollout = rollout.withDefaults()
+
+	status.Phase = "rotating canary node"
+	status.Completed = checkpoint.RotatedNodes
+	status.Total = checkpoint.RotatedNodes + len(checkpoint.Nodes)
+
+	if err := waitForDisruption(ctx, maintenance, status, pauser); err != nil {
+		return err
+	}
+
+	if rollout.MaxUnavailablePerZone > 0 {
+		if err := waitForZoneBudget(ctx, clusterName, checkpoint, rollout.MaxUnavailablePerZone, status); err != nil {
+			return err
+		}
+	}
+
+	if err := prepareNodeRotation(ctx, clusterName, checkpoint, rollout.CordonOutdated); err != nil {
+		return err
+	}
+
+	var baseline []kubeactivities.HealthProblem
+
+	if rollout.HealthCheck.Enabled {
+		var err error
+
+		baseline, err = checkClusterHealth(ctx, clusterName, rollout.HealthCheck)
+		if err != nil {
+			return err
+		}
+	}
+
+	canary := checkpoint.Nodes[0]
+
+	// The replacement of the canary has to become ready within the canary timeout
+	err := rotateNode(ctx, clusterName, checkpoint, canary, rollout.SchedulingCheck, rollout.CanaryTimeout)
+	if errors.Is(err, errNodeGroupNotReady) {
+		return fmt.Errorf("canary: %w", err)
+	} else if err != nil {
+		return err
+	}
+
+	checkpoint.Nodes = checkpoint.Nodes[1:]
+	checkpoint.RotatedNodes++
+
+	status.Completed = checkpoint.RotatedNodes
+
+	if rollout.HealthCheck.Enabled {
+		err := healthGate(ctx, clusterName, "rotating canary node "+canary.Name, baseline, rollout.HealthCheck, status, pauser)
+		if err != nil {
+			return err
+		}
+
+		// Don't ask for an approval before the problems are accepted
+		if err := pauser.wait(ctx); err != nil {
+			return err
+		}
+	}
+
+	workflow.GetLogger(ctx).Info("canary is healthy", "node", canary.Name)
+
+	if rollout.ApproveCanary {
+		status.Phase = "waiting for canary approval"
+
+		if err := waitForApproval(ctx, ApprovalGateCanary, rollout.ApprovalTimeout, status); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForNodeGroupReady waits until a replacement node joined the node group, the node group is back at its previous size
+// and every node in it is ready.
+func waitForNodeGroupReady(ctx workflow.Context, clusterName string, asgName string, previousNodes []nodeGroupNode, timeout time.Duration) error {
+	deadline := workflow.Now(ctx).Add(timeout)
+
+	previous := make(map[string]bool, len(previousNodes))
+
+	for _, node := range previousNodes {
+		previous[node.Name] = true
+	}
+
+	for {
+		nodes, err := listNodeGroupNodes(ctx, clusterName, asgName)
+		if err != nil {
+			return err
+		}
+
+		var ready, replacements int
+
+		for _, node := range nodes {
+			if !node.Ready {
+				continue
+			}
+
+			ready++
+
+			if !previous[node.Name] {
+				replacements++
+			}
+		}
+
+		if replacements > 0 && ready == len(nodes) && len(nodes) >= len(previousNodes) {
+			return nil
+		}
+
+		if !workflow.Now(ctx).Before(deadline) {
+			return fmt.Errorf("%w in %s (%d/%d nodes ready, %d replacements)", errNodeGroupNotReady, timeout, ready, len(previousNodes), replacements)
+		}
+
+		if err := workflow.Sleep(ctx, 30*time.Second); err != nil {
+			return err
+		}
+	}
+}
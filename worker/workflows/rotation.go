@@ -0,0 +1,561 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"go.temporal.io/sdk/workflow"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+const (
+	// DefaultMaxNodesPerRun is the default number of nodes rotated in a single workflow run.
+	DefaultMaxNodesPerRun = 50
+
+	// DefaultMaxHistoryLength is the default number of history events after which long-running workflows continue as new.
+	DefaultMaxHistoryLength = 10000
+
+	// DefaultNodeReadyTimeout is the default time the node group has to become ready after replacing a node.
+	DefaultNodeReadyTimeout = 15 * time.Minute
+)
+
+// RotationCheckpoint records the progress of a workflow rotating the nodes of a node group.
+type RotationCheckpoint struct {
+	AutoScalingGroupName string
+
+	// Nodes that still need to be rotated.
+	Nodes []RotationNode
+
+	// RotatedNodes is the number of nodes rotated by previous runs.
+	RotatedNodes int
+
+	// Paused is true if the workflow was paused when it continued as new.
+	Paused bool
+
+	// Rollback records the node group stack before the update (if the update changed the stack).
+	Rollback *NodeGroupRollback `json:",omitempty"`
+
+	// OutdatedNodesMarked is true if the nodes left are tainted (and OutdatedNodesCordoned if they are cordoned).
+	OutdatedNodesMarked   bool `json:",omitempty"`
+	OutdatedNodesCordoned bool `json:",omitempty"`
+
+	// ScalingSuspension records the changes keeping auto scaling from interfering with the rotation (if any).
+	ScalingSuspension *ScalingSuspension `json:",omitempty"`
+
+	// RollbackReport is set once the update is being rolled back (Nodes are rotated back in this case).
+	RollbackReport *RollbackReport `json:",omitempty"`
+
+	// InFlight is the rotation of the first node in Nodes while it is in progress.
+	InFlight *InFlightRotation `json:",omitempty"`
+}
+
+// RotationStep is a step of rotating a node.
+type RotationStep string
+
+const (
+	// RotationStepScheduling waits for the pods of the node to fit on the remaining nodes (surging the node group if necessary).
+	RotationStepScheduling RotationStep = "scheduling"
+
+	// RotationStepDraining cordons the node and evicts its pods.
+	RotationStepDraining RotationStep = "draining"
+
+	// RotationStepDeleting deletes the node from the cluster.
+	RotationStepDeleting RotationStep = "deleting"
+
+	// RotationStepDetaching detaches the instance from the auto scaling group.
+	RotationStepDetaching RotationStep = "detaching"
+
+	// RotationStepTerminating terminates the instance.
+	RotationStepTerminating RotationStep = "terminating"
+)
+
+// InFlightRotation records the progress of rotating a single node.
+type InFlightRotation struct {
+	Node RotationNode
+
+	// Step is the step in progress.
+	Step RotationStep
+
+	// Surged is true if the node group was scaled up to make room for the pods of the node.
+	// It is recorded before scaling up, so that an interrupted surge is reverted as well (see [revertSurge]).
+	Surged bool `json:",omitempty"`
+
+	// SurgedFrom is the desired capacity of the auto scaling group before the surge.
+	SurgedFrom int32 `json:",omitempty"`
+
+	// SurgedAt is the time of the surge.
+	SurgedAt time.Time
+}
+
+// RotationNode identifies a node (and its backing instance) that needs to be rotated.
+type RotationNode struct {
+	Name       string
+	InstanceID string
+	Zone       string `json:",omitempty"`
+}
+
+// nodeGroupStackName returns the name of the CloudFormation stack of a node group.
+func nodeGroupStackName(clusterName string, nodeGroupName string) string {
+	return fmt.Sprintf("%s-%s", clusterName, nodeGroupName)
+}
+
+// describeNodeGroupStack returns the name of the auto scaling group and the current parameters of a node group stack.
+func describeNodeGroupStack(ctx workflow.Context, stackName string) (string, []cftypes.Parameter, error) {
+	var cfactivities awsactivities.CloudFormation
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &cloudformation.DescribeStacksInput{
+		StackName: aws.String(stackName),
+	}
+
+	var output *cloudformation.DescribeStacksOutput
+
+	err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacks, input).Get(ctx, &output)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if len(output.Stacks) == 0 {
+		return "", nil, errors.New("stack not found")
+	}
+
+	var asgName string
+
+	for _, output := range output.Stacks[0].Outputs {
+		switch aws.ToString(output.OutputKey) {
+		case "NodeAutoScalingGroup":
+			asgName = aws.ToString(output.OutputValue)
+		}
+	}
+
+	return asgName, output.Stacks[0].Parameters, nil
+}
+
+// nodeGroupNode is a node of a node group joined with the details of its backing instance.
+type nodeGroupNode struct {
+	Name       string
+	InstanceID string
+	ImageID    string
+	Zone       string
+
+	// CreatedAt is the earlier of the node creation timestamp and the instance launch time.
+	CreatedAt time.Time
+
+	Ready bool
+}
+
+// listNodeGroupNodes returns the nodes backed by running instances of an auto scaling group.
+func listNodeGroupNodes(ctx workflow.Context, clusterName string, asgName string) ([]nodeGroupNode, error) {
+	var nodeactivities kubeactivities.Nodes
+
+	instances, err := listAutoScalingGroupInstances(ctx, asgName)
+	if err != nil {
+		return nil, err
+	}
+
+	var output kubeactivities.ListNodesOutput
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.ListNodesInput{
+			ClusterName: clusterName,
+		}
+
+		err := workflow.ExecuteActivity(ctx, nodeactivities.ListNodes, input).Get(ctx, &output)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var nodes []nodeGroupNode
+
+	for _, node := range output.Nodes {
+		instanceID, err := instanceIDFromProviderID(node.Spec.ProviderID)
+		if err != nil {
+			return nil, fmt.Errorf("node %s: %w", node.Name, err)
+		}
+
+		instance, ok := instances[instanceID]
+
+		// Node belongs to a different node group
+		if !ok {
+			continue
+		}
+
+		createdAt := node.CreationTimestamp.Time
+		if !instance.LaunchTime.IsZero() && instance.LaunchTime.Before(createdAt) {
+			createdAt = instance.LaunchTime
+		}
+
+		var ready bool
+
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+
+		nodes = append(nodes, nodeGroupNode{
+			Name:       node.Name,
+			InstanceID: instanceID,
+			ImageID:    instance.ImageID,
+			Zone:       node.Labels[corev1.LabelTopologyZone],
+			CreatedAt:  createdAt,
+			Ready:      ready,
+		})
+	}
+
+	return nodes, nil
+}
+
+// rotationOptions controls how nodes are rotated.
+type rotationOptions struct {
+	// MaxNodesPerRun and MaxHistoryLength control when a rotating workflow continues as new.
+	MaxNodesPerRun   int
+	MaxHistoryLength int
+
+	Maintenance     cluster.Maintenance
+	HealthCheck     HealthCheckOptions
+	SchedulingCheck SchedulingCheckOptions
+
+	// MaxUnavailablePerZone limits the number of unavailable nodes per zone (across the cluster). Zero means no limit.
+	MaxUnavailablePerZone int
+
+	// CordonOutdated cordons the nodes left once there is enough replacement capacity.
+	CordonOutdated bool
+
+	// NodeReadyTimeout is the time the node group has to become ready after replacing a node.
+	// Defaults to [DefaultNodeReadyTimeout].
+	NodeReadyTimeout time.Duration
+
+	// Interrupt stops the rotation before the next node if it returns an error.
+	Interrupt func() error
+}
+
+func (o rotationOptions) withDefaults() rotationOptions {
+	if o.MaxNodesPerRun == 0 {
+		o.MaxNodesPerRun = DefaultMaxNodesPerRun
+	}
+
+	if o.MaxHistoryLength == 0 {
+		o.MaxHistoryLength = DefaultMaxHistoryLength
+	}
+
+	if o.NodeReadyTimeout == 0 {
+		o.NodeReadyTimeout = DefaultNodeReadyTimeout
+	}
+
+	return o
+}
+
+func (o rotationOptions) interrupted() error {
+	if o.Interrupt == nil {
+		return nil
+	}
+
+	return o.Interrupt()
+}
+
+// rotateNodes rotates the nodes in the checkpoint one by one, recording progress in the checkpoint and the status.
+//
+// Nodes are only rotated inside maintenance windows.
+// Auto scaling should be suspended beforehand (see [suspendScaling]).
+// If the health check is enabled, workloads have to recover after every node replacement.
+//
+// It returns false if the workflow should continue as new (with the updated checkpoint) before rotating the remaining nodes.
+func rotateNodes(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, options rotationOptions, status *Status, pauser *pauser) (bool, error) {
+	options = options.withDefaults()
+
+	status.Completed = checkpoint.RotatedNodes
+	status.Total = checkpoint.RotatedNodes + len(checkpoint.Nodes)
+
+	var rotatedNodes int
+
+	for len(checkpoint.Nodes) > 0 {
+		// Make sure every run makes progress before continuing as new
+		if rotatedNodes > 0 {
+			info := workflow.GetInfo(ctx)
+
+			if rotatedNodes >= options.MaxNodesPerRun || info.GetCurrentHistoryLength() >= options.MaxHistoryLength || info.GetContinueAsNewSuggested() {
+				workflow.GetLogger(ctx).Info("continuing as new", "rotatedNodes", checkpoint.RotatedNodes, "remainingNodes", len(checkpoint.Nodes))
+
+				pauser.drain(ctx)
+
+				checkpoint.Paused = status.Paused
+
+				return false, nil
+			}
+		}
+
+		if err := waitForDisruption(ctx, options.Maintenance, status, pauser); err != nil {
+			return false, err
+		}
+
+		if err := options.interrupted(); err != nil {
+			return false, err
+		}
+
+		if options.MaxUnavailablePerZone > 0 {
+			if err := waitForZoneBudget(ctx, clusterName, checkpoint, options.MaxUnavailablePerZone, status); err != nil {
+				return false, err
+			}
+		}
+
+		if err := prepareNodeRotation(ctx, clusterName, checkpoint, options.CordonOutdated); err != nil {
+			return false, err
+		}
+
+		var baseline []kubeactivities.HealthProblem
+
+		if options.HealthCheck.Enabled {
+			var err error
+
+			baseline, err = checkClusterHealth(ctx, clusterName, options.HealthCheck)
+			if err != nil {
+				return false, err
+			}
+		}
+
+		node := checkpoint.Nodes[0]
+
+		err := rotateNode(ctx, clusterName, checkpoint, node, options.SchedulingCheck, options.NodeReadyTimeout)
+		if err != nil {
+			return false, err
+		}
+
+		checkpoint.Nodes = checkpoint.Nodes[1:]
+		checkpoint.RotatedNodes++
+		rotatedNodes++
+
+		status.Completed = checkpoint.RotatedNodes
+
+		if options.HealthCheck.Enabled {
+			err := healthGate(ctx, clusterName, "rotating node "+node.Name, baseline, options.HealthCheck, status, pauser)
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+
+	// The health gate may have paused the workflow after the last node
+	if err := pauser.wait(ctx); err != nil {
+		return false, err
+	}
+
+	if err := options.interrupted(); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// prepareNodeRotation protects the node group before rotating the next node:
+// it disables scale down for the nodes that joined since the previous node (eg. replacement nodes)
+// and, with cordonOutdated, cordons the outdated nodes once there is enough replacement capacity.
+func prepareNodeRotation(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, cordonOutdated bool) error {
+	// Cover replacement nodes as well
+	if checkpoint.ScalingSuspension != nil {
+		if err := disableScaleDown(ctx, clusterName, checkpoint.AutoScalingGroupName); err != nil {
+			return err
+		}
+	}
+
+	if cordonOutdated {
+		if err := cordonOutdatedNodes(ctx, clusterName, checkpoint); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseNodeGroup reverts the changes made to a node group for the duration of a rotation
+// (outdated node taints and the auto scaling suspension).
+func releaseNodeGroup(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint) error {
+	if err := unmarkOutdatedNodes(ctx, clusterName, checkpoint); err != nil {
+		return err
+	}
+
+	return resumeScaling(ctx, clusterName, checkpoint)
+}
+
+// instanceIDFromProviderID extracts the EC2 instance ID from a provider ID in the format aws:///ZONE/INSTANCE_ID.
+func instanceIDFromProviderID(providerID string) (string, error) {
+	segments := strings.Split(strings.TrimPrefix(providerID, "aws:///"), "/")
+	if len(segments) != 2 || segments[1] == "" {
+		return "", fmt.Errorf("invalid provider ID: %q", providerID)
+	}
+
+	return segments[1], nil
+}
+
+// rotateNode replaces a single node by draining it and terminating its instance.
+//
+// If the scheduling check is enabled, the node is only drained once its pods fit on the remaining nodes.
+// Once the instance is terminated, it waits for the node group to become ready with a replacement node (see [waitForNodeGroupReady]).
+// Progress is recorded in [RotationCheckpoint.InFlight], so that an interrupted rotation can be recovered (see [recoverInFlightRotation]).
+func rotateNode(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, node RotationNode, schedulingCheck SchedulingCheckOptions, readyTimeout time.Duration) error {
+	var nodeactivities kubeactivities.Nodes
+
+	asgName := checkpoint.AutoScalingGroupName
+
+	workflow.GetLogger(ctx).Info("rotating node", "name", node.Name, "instanceId", node.InstanceID)
+
+	previousNodes, err := listNodeGroupNodes(ctx, clusterName, asgName)
+	if err != nil {
+		return err
+	}
+
+	inFlight := &InFlightRotation{
+		Node: node,
+		Step: RotationStepDraining,
+	}
+
+	checkpoint.InFlight = inFlight
+
+	if schedulingCheck.Enabled {
+		inFlight.Step = RotationStepScheduling
+
+		if err := waitForPodsToFit(ctx, clusterName, checkpoint, schedulingCheck); err != nil {
+			return err
+		}
+
+		inFlight.Step = RotationStepDraining
+	}
+
+	// Drain node
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.DrainNodeInput{
+			ClusterName: clusterName,
+			NodeName:    node.Name,
+		}
+
+		err := workflow.ExecuteActivity(ctx, nodeactivities.DrainNode, input).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	inFlight.Step = RotationStepDeleting
+
+	// Delete node
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.DeleteNodeInput{
+			ClusterName: clusterName,
+			NodeName:    node.Name,
+		}
+
+		err := workflow.ExecuteActivity(ctx, nodeactivities.DeleteNode, input).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// TODO: verify node is gone
+
+	inFlight.Step = RotationStepDetaching
+
+	// The surge node replaces the detached one
+	if err := detachInstance(ctx, asgName, node.InstanceID, inFlight.Surged); err != nil {
+		return err
+	}
+
+	inFlight.Step = RotationStepTerminating
+
+	if err := terminateInstance(ctx, node.InstanceID); err != nil {
+		return err
+	}
+
+	// The step stays at terminating while waiting: terminating the instance again is harmless if the wait is interrupted
+	if err := waitForNodeGroupReady(ctx, clusterName, asgName, previousNodes, readyTimeout); err != nil {
+		return err
+	}
+
+	checkpoint.InFlight = nil
+
+	return nil
+}
+
+// detachInstance detaches an instance from an auto scaling group.
+func detachInstance(ctx workflow.Context, asgName string, instanceID string, decrementDesiredCapacity bool) error {
+	var asgactivities awsactivities.AutoScaling
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &autoscaling.DetachInstancesInput{
+		InstanceIds:                    []string{instanceID},
+		AutoScalingGroupName:           aws.String(asgName),
+		ShouldDecrementDesiredCapacity: aws.Bool(decrementDesiredCapacity),
+	}
+
+	return workflow.ExecuteActivity(ctx, asgactivities.DetachInstances, input).Get(ctx, nil)
+}
+
+// terminateInstance terminates an instance and waits for the termination to complete.
+func terminateInstance(ctx workflow.Context, instanceID string) error {
+	var ec2activities awsactivities.EC2
+
+	// Terminate instance
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := &ec2.TerminateInstancesInput{
+			InstanceIds: []string{instanceID},
+		}
+
+		err := workflow.ExecuteActivity(ctx, ec2activities.TerminateInstances, input).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Wait for instance termination
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 5 * time.Minute,
+			HeartbeatTimeout:    30 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		err := workflow.ExecuteActivity(ctx, ec2activities.WaitForInstanceTerminated, []string{instanceID}).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
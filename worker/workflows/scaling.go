@@ -0,0 +1,193 @@
+package workflows
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+// interferingScalingProcesses are the auto scaling processes that launch or terminate instances behind the back of a rotation.
+var interferingScalingProcesses = []string{"AZRebalance", "ReplaceUnhealthy"}
+
+// ScalingSuspension records the changes keeping auto scaling from interfering with a rotation.
+type ScalingSuspension struct {
+	// Processes are the auto scaling processes suspended by the workflow.
+	// Processes that were already suspended are left alone.
+	Processes []string `json:",omitempty"`
+}
+
+// suspendScaling suspends the auto scaling processes interfering with the rotation
+// and disables cluster-autoscaler scale-down for the nodes of the node group.
+//
+// The suspension is recorded in the checkpoint, so that it can be reverted by [resumeScaling] (even in a later run).
+func suspendScaling(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint) error {
+	var asgactivities awsactivities.AutoScaling
+
+	group, err := describeAutoScalingGroup(ctx, checkpoint.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+
+	suspended := make(map[string]bool, len(group.SuspendedProcesses))
+
+	for _, process := range group.SuspendedProcesses {
+		suspended[aws.ToString(process.ProcessName)] = true
+	}
+
+	suspension := &ScalingSuspension{}
+
+	for _, process := range interferingScalingProcesses {
+		if !suspended[process] {
+			suspension.Processes = append(suspension.Processes, process)
+		}
+	}
+
+	// Record the suspension first, so it's reverted even if suspending fails halfway
+	checkpoint.ScalingSuspension = suspension
+
+	if len(suspension.Processes) > 0 {
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		workflow.GetLogger(ctx).Info("suspending auto scaling processes", "asg", checkpoint.AutoScalingGroupName, "processes", suspension.Processes)
+
+		input := &autoscaling.SuspendProcessesInput{
+			AutoScalingGroupName: aws.String(checkpoint.AutoScalingGroupName),
+			ScalingProcesses:     suspension.Processes,
+		}
+
+		err := workflow.ExecuteActivity(ctx, asgactivities.SuspendProcesses, input).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	return disableScaleDown(ctx, clusterName, checkpoint.AutoScalingGroupName)
+}
+
+// disableScaleDown keeps the cluster-autoscaler from removing the nodes of a node group.
+//
+// Call it regularly during a rotation, so that replacement nodes are covered as well.
+func disableScaleDown(ctx workflow.Context, clusterName string, asgName string) error {
+	var nodeactivities kubeactivities.Nodes
+
+	nodes, err := listNodeGroupNodes(ctx, clusterName, asgName)
+	if err != nil {
+		return err
+	}
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 5 * time.Minute,
+		HeartbeatTimeout:    30 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.DisableScaleDownInput{
+		ClusterName: clusterName,
+		NodeNames:   nodeGroupNodeNames(nodes),
+	}
+
+	return workflow.ExecuteActivity(ctx, nodeactivities.DisableScaleDown, input).Get(ctx, nil)
+}
+
+// resumeScaling reverts [suspendScaling].
+func resumeScaling(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint) error {
+	var asgactivities awsactivities.AutoScaling
+	var nodeactivities kubeactivities.Nodes
+
+	suspension := checkpoint.ScalingSuspension
+	if suspension == nil {
+		return nil
+	}
+
+	if len(suspension.Processes) > 0 {
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		workflow.GetLogger(ctx).Info("resuming auto scaling processes", "asg", checkpoint.AutoScalingGroupName, "processes", suspension.Processes)
+
+		input := &autoscaling.ResumeProcessesInput{
+			AutoScalingGroupName: aws.String(checkpoint.AutoScalingGroupName),
+			ScalingProcesses:     suspension.Processes,
+		}
+
+		err := workflow.ExecuteActivity(ctx, asgactivities.ResumeProcesses, input).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	nodes, err := listNodeGroupNodes(ctx, clusterName, checkpoint.AutoScalingGroupName)
+	if err != nil {
+		return err
+	}
+
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 5 * time.Minute,
+			HeartbeatTimeout:    30 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := kubeactivities.EnableScaleDownInput{
+			ClusterName: clusterName,
+			NodeNames:   nodeGroupNodeNames(nodes),
+		}
+
+		err := workflow.ExecuteActivity(ctx, nodeactivities.EnableScaleDown, input).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	checkpoint.ScalingSuspension = nil
+
+	return nil
+}
+
+func describeAutoScalingGroup(ctx workflow.Context, asgName string) (astypes.AutoScalingGroup, error) {
+	var asgactivities awsactivities.AutoScaling
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []string{asgName},
+	}
+
+	var output *autoscaling.DescribeAutoScalingGroupsOutput
+
+	err := workflow.ExecuteActivity(ctx, asgactivities.DescribeAutoScalingGroups, input).Get(ctx, &output)
+	if err != nil {
+		return astypes.AutoScalingGroup{}, err
+	}
+
+	if len(output.AutoScalingGroups) == 0 {
+		return astypes.AutoScalingGroup{}, fmt.Errorf("auto scaling group %s not found", asgName)
+	}
+
+	return output.AutoScalingGroups[0], nil
+}
+
+func nodeGroupNodeNames(nodes []nodeGroupNode) []string {
+	names := make([]string, 0, len(nodes))
+
+	for _, node := range nodes {
+		names = append(names, node.Name)
+	}
+
+	return names
+}
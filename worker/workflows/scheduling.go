@@ -0,0 +1,380 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+// DefaultSchedulingCheckTimeout is the default time the pods of a node have to fit on the remaining nodes before draining it.
+const DefaultSchedulingCheckTimeout = 10 * time.Minute
+
+// SchedulingCheckOptions configures the scheduling simulation before draining a node.
+//
+// The simulation checks whether the pods of the node would fit on the remaining schedulable nodes
+// (see [kubeactivities.Scheduling.SimulateDrain]). Draining is blocked until they do.
+type SchedulingCheckOptions struct {
+	Enabled bool
+
+	// Surge adds a node to the node group if the pods would not fit.
+	// The surge node replaces the drained node, so the node group is back at its original size after the replacement.
+	Surge bool
+
+	// Timeout is the time the pods have to fit (eg. once the surge node joined) before the node replacement fails.
+	// Defaults to [DefaultSchedulingCheckTimeout].
+	Timeout time.Duration
+}
+
+func (o SchedulingCheckOptions) Validate() error {
+	if o.Surge && !o.Enabled {
+		return errors.New("surge requires the scheduling check")
+	}
+
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+
+	return nil
+}
+
+func (o SchedulingCheckOptions) withDefaults() SchedulingCheckOptions {
+	if o.Timeout == 0 {
+		o.Timeout = DefaultSchedulingCheckTimeout
+	}
+
+	return o
+}
+
+// waitForPodsToFit blocks until the pods of the node being rotated (see [RotationCheckpoint.InFlight]) fit on the remaining nodes
+// (surging the node group if necessary).
+//
+// With surge, the pods have to fit without the remaining outdated nodes (those are drained later anyway),
+// so the node is only drained once the surge node joined. Without surge, they may land on outdated nodes.
+// A surge is recorded in the in-flight rotation.
+func waitForPodsToFit(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint, options SchedulingCheckOptions) error {
+	options = options.withDefaults()
+
+	logger := workflow.GetLogger(ctx)
+
+	inFlight := checkpoint.InFlight
+	node := inFlight.Node
+
+	var outdatedNodes []string
+
+	for _, n := range checkpoint.Nodes {
+		if n.Name != node.Name {
+			outdatedNodes = append(outdatedNodes, n.Name)
+		}
+	}
+
+	deadline := workflow.Now(ctx).Add(options.Timeout)
+
+	var surgeAttempted bool
+
+	for {
+		unschedulable, err := simulateDrain(ctx, clusterName, node.Name, outdatedNodes)
+		if err != nil {
+			return err
+		}
+
+		if len(unschedulable) == 0 {
+			return nil
+		}
+
+		if options.Surge && !surgeAttempted {
+			if err := surgeNodeGroup(ctx, checkpoint.AutoScalingGroupName, inFlight); err != nil {
+				return err
+			}
+
+			surgeAttempted = true
+		}
+
+		// Without a surge node, the outdated nodes are the only capacity left
+		if !inFlight.Surged {
+			unschedulable, err = simulateDrain(ctx, clusterName, node.Name, nil)
+			if err != nil {
+				return err
+			}
+
+			if len(unschedulable) == 0 {
+				return nil
+			}
+		}
+
+		pods := make([]string, 0, len(unschedulable))
+
+		for _, pod := range unschedulable {
+			pods = append(pods, pod.String())
+		}
+
+		logger.Warn("pods would not fit on the remaining nodes", "node", node.Name, "pods", pods)
+
+		if !workflow.Now(ctx).Before(deadline) {
+			return fmt.Errorf("pods of node %s would not fit on the remaining nodes: %s", node.Name, strings.Join(pods, "; "))
+		}
+
+		if err := workflow.Sleep(ctx, 30*time.Second); err != nil {
+			return err
+		}
+	}
+}
+
+func simulateDrain(ctx workflow.Context, clusterName string, nodeName string, unschedulableNodes []string) ([]kubeactivities.UnschedulablePod, error) {
+	var schedulingactivities kubeactivities.Scheduling
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := kubeactivities.SimulateDrainInput{
+		ClusterName:        clusterName,
+		NodeName:           nodeName,
+		UnschedulableNodes: unschedulableNodes,
+	}
+
+	var output kubeactivities.SimulateDrainOutput
+
+	err := workflow.ExecuteActivity(ctx, schedulingactivities.SimulateDrain, input).Get(ctx, &output)
+	if err != nil {
+		return nil, err
+	}
+
+	return output.Unschedulable, nil
+}
+
+// surgeNodeGroup increments the desired capacity of an auto scaling group (unless it's already at its maximum size).
+//
+// The surge is recorded in the in-flight rotation before scaling up.
+func surgeNodeGroup(ctx workflow.Context, asgName string, inFlight *InFlightRotation) error {
+	group, err := describeAutoScalingGroup(ctx, asgName)
+	if err != nil {
+		return err
+	}
+
+	desired := aws.ToInt32(group.DesiredCapacity)
+
+	if desired >= aws.ToInt32(group.MaxSize) {
+		workflow.GetLogger(ctx).Warn("cannot surge auto scaling group: already at its maximum size", "asg", asgName, "maxSize", aws.ToInt32(group.MaxSize))
+
+		return nil
+	}
+
+	workflow.GetLogger(ctx).Info("surging auto scaling group", "asg", asgName, "desiredCapacity", desired+1)
+
+	inFlight.Surged = true
+	inFlight.SurgedFrom = desired
+	inFlight.SurgedAt = workflow.Now(ctx)
+
+	return setDesiredCapacity(ctx, asgName, desired+1)
+}
+
+// surgeInstanceTimeout is how long [revertSurge] waits for the instance of a pending surge to be launched.
+const surgeInstanceTimeout = 10 * time.Minute
+
+// revertSurge removes the surge node of an interrupted node rotation that is not completed (because the node is kept).
+//
+// The surge instance is the instance of the auto scaling group launched after the surge that is not a node to be rotated.
+// It is drained (if it joined the cluster already), detached (decrementing the desired capacity) and terminated.
+// The desired capacity is never decremented on its own: the auto scaling group could terminate a node serving pods instead.
+func revertSurge(ctx workflow.Context, clusterName string, checkpoint *RotationCheckpoint) error {
+	var nodeactivities kubeactivities.Nodes
+
+	inFlight := checkpoint.InFlight
+	asgName := checkpoint.AutoScalingGroupName
+
+	instanceIDs, err := waitForSurgeInstances(ctx, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	if len(instanceIDs) == 0 {
+		return nil
+	}
+
+	nodes, err := listNodeGroupNodes(ctx, clusterName, asgName)
+	if err != nil {
+		return err
+	}
+
+	for _, instanceID := range instanceIDs {
+		for _, node := range nodes {
+			if node.InstanceID != instanceID || node.Name == inFlight.Node.Name {
+				continue
+			}
+
+			workflow.GetLogger(ctx).Info("removing surge node", "asg", asgName, "node", node.Name, "instanceId", node.InstanceID)
+
+			// Drain node
+			{
+				ao := workflow.ActivityOptions{
+					StartToCloseTimeout: time.Minute,
+				}
+				ctx := workflow.WithActivityOptions(ctx, ao)
+
+				input := kubeactivities.DrainNodeInput{
+					ClusterName: clusterName,
+					NodeName:    node.Name,
+				}
+
+				err := workflow.ExecuteActivity(ctx, nodeactivities.DrainNode, input).Get(ctx, nil)
+				if err != nil {
+					return err
+				}
+			}
+
+			// Delete node
+			{
+				ao := workflow.ActivityOptions{
+					StartToCloseTimeout: 15 * time.Second,
+				}
+				ctx := workflow.WithActivityOptions(ctx, ao)
+
+				input := kubeactivities.DeleteNodeInput{
+					ClusterName: clusterName,
+					NodeName:    node.Name,
+				}
+
+				err := workflow.ExecuteActivity(ctx, nodeactivities.DeleteNode, input).Get(ctx, nil)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		workflow.GetLogger(ctx).Info("removing surge instance", "asg", asgName, "instanceId", instanceID)
+
+		if err := detachInstance(ctx, asgName, instanceID, true); err != nil {
+			return err
+		}
+
+		if err := terminateInstance(ctx, instanceID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// waitForSurgeInstances returns the instances launched by the surge of an interrupted node rotation.
+//
+// If the auto scaling group is still above its size before the surge, but the surge instance is not launched yet,
+// it waits for the instance to appear (at most [surgeInstanceTimeout]).
+func waitForSurgeInstances(ctx workflow.Context, checkpoint *RotationCheckpoint) ([]string, error) {
+	inFlight := checkpoint.InFlight
+	asgName := checkpoint.AutoScalingGroupName
+
+	known := map[string]bool{
+		inFlight.Node.InstanceID: true,
+	}
+
+	for _, node := range checkpoint.Nodes {
+		known[node.InstanceID] = true
+	}
+
+	deadline := workflow.Now(ctx).Add(surgeInstanceTimeout)
+
+	for {
+		instances, err := listAutoScalingGroupInstances(ctx, asgName)
+		if err != nil {
+			return nil, err
+		}
+
+		var instanceIDs []string
+
+		for instanceID, instance := range instances {
+			if known[instanceID] || instance.LaunchTime.Before(inFlight.SurgedAt) {
+				continue
+			}
+
+			instanceIDs = append(instanceIDs, instanceID)
+		}
+
+		if len(instanceIDs) > 0 {
+			// Map iteration order is random: keep workflow code deterministic
+			slices.Sort(instanceIDs)
+
+			return instanceIDs, nil
+		}
+
+		group, err := describeAutoScalingGroup(ctx, asgName)
+		if err != nil {
+			return nil, err
+		}
+
+		// The surge never went through (or it has been reverted already)
+		if aws.ToInt32(group.DesiredCapacity) <= inFlight.SurgedFrom {
+			return nil, nil
+		}
+
+		if !workflow.Now(ctx).Before(deadline) {
+			return nil, fmt.Errorf("surge instance of auto scaling group %s was not launched within %s", asgName, surgeInstanceTimeout)
+		}
+
+		workflow.GetLogger(ctx).Info("waiting for surge instance to be launched", "asg", asgName)
+
+		if err := workflow.Sleep(ctx, 15*time.Second); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// listAutoScalingGroupInstances returns the pending and running instances of an auto scaling group (keyed by instance ID).
+func listAutoScalingGroupInstances(ctx workflow.Context, asgName string) (map[string]awsactivities.InstanceSummary, error) {
+	var ec2activities awsactivities.EC2
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: time.Minute,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &ec2.DescribeInstancesInput{
+		Filters: []ec2types.Filter{
+			{
+				Name:   aws.String("tag:aws:autoscaling:groupName"),
+				Values: []string{asgName},
+			},
+			{
+				Name:   aws.String("instance-state-name"),
+				Values: []string{"pending", "running"},
+			},
+		},
+	}
+
+	var instances map[string]awsactivities.InstanceSummary
+
+	err := workflow.ExecuteActivity(ctx, ec2activities.DescribeInstanceSummaries, input).Get(ctx, &instances)
+	if err != nil {
+		return nil, err
+	}
+
+	return instances, nil
+}
+
+func setDesiredCapacity(ctx workflow.Context, asgName string, desiredCapacity int32) error {
+	var asgactivities awsactivities.AutoScaling
+
+	ao := workflow.ActivityOptions{
+		StartToCloseTimeout: 15 * time.Second,
+	}
+	ctx = workflow.WithActivityOptions(ctx, ao)
+
+	input := &autoscaling.SetDesiredCapacityInput{
+		AutoScalingGroupName: aws.String(asgName),
+		DesiredCapacity:      aws.Int32(desiredCapacity),
+		HonorCooldown:        aws.Bool(false),
+	}
+
+	return workflow.ExecuteActivity(ctx, asgactivities.SetDesiredCapacity, input).Get(ctx, nil)
+}
@@ -0,0 +1,209 @@
+package workflows
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
+	astypes "github.com/aws/aws-sdk-go-v2/service/autoscaling/types"
+	"github.com/stretchr/testify/mock"
+	"go.temporal.io/sdk/testsuite"
+	"go.temporal.io/sdk/workflow"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+func TestRecoverInFlightRotation_Surge(t *testing.T) {
+	surgedAt := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+	launchedAt := surgedAt.Add(-24 * time.Hour)
+
+	instances := map[string]awsactivities.InstanceSummary{
+		"i-1": {ImageID: "ami-old", LaunchTime: launchedAt},
+		"i-2": {ImageID: "ami-old", LaunchTime: launchedAt},
+	}
+
+	surgedInstances := map[string]awsactivities.InstanceSummary{
+		"i-1":     {ImageID: "ami-old", LaunchTime: launchedAt},
+		"i-2":     {ImageID: "ami-old", LaunchTime: launchedAt},
+		"i-surge": {ImageID: "ami-new", LaunchTime: surgedAt.Add(30 * time.Second)},
+	}
+
+	nodes := []corev1.Node{
+		testNode("node-1", "i-1", "eu-west-1a", launchedAt),
+		testNode("node-2", "i-2", "eu-west-1b", launchedAt),
+	}
+
+	surgeNode := testNode("node-surge", "i-surge", "eu-west-1a", surgedAt.Add(2*time.Minute))
+
+	onDescribeInstances := func(env *testsuite.TestWorkflowEnvironment, instances map[string]awsactivities.InstanceSummary) *testsuite.MockCallWrapper {
+		return env.OnActivity((&awsactivities.EC2{}).DescribeInstanceSummaries, mock.Anything, mock.Anything).Return(instances, nil)
+	}
+
+	onListNodes := func(env *testsuite.TestWorkflowEnvironment, nodes ...corev1.Node) {
+		env.OnActivity((&kubeactivities.Nodes{}).ListNodes, mock.Anything, kubeactivities.ListNodesInput{ClusterName: "mark-1"}).
+			Return(&kubeactivities.ListNodesOutput{Nodes: nodes}, nil).Once()
+	}
+
+	onDescribeAutoScalingGroup := func(env *testsuite.TestWorkflowEnvironment, desiredCapacity int32) *testsuite.MockCallWrapper {
+		return env.OnActivity((&awsactivities.AutoScaling{}).DescribeAutoScalingGroups, mock.Anything, mock.Anything).
+			Return(&autoscaling.DescribeAutoScalingGroupsOutput{
+				AutoScalingGroups: []astypes.AutoScalingGroup{
+					{
+						AutoScalingGroupName: aws.String("mark-1-ng-1-asg"),
+						DesiredCapacity:      aws.Int32(desiredCapacity),
+					},
+				},
+			}, nil)
+	}
+
+	testCases := []struct {
+		name   string
+		surged bool
+		mock   func(env *testsuite.TestWorkflowEnvironment)
+
+		err string
+	}{
+		{
+			name: "not surged",
+			mock: func(env *testsuite.TestWorkflowEnvironment) {},
+		},
+		{
+			name:   "surge node joined",
+			surged: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				onDescribeInstances(env, surgedInstances)
+				onListNodes(env, nodes[0], nodes[1], surgeNode)
+
+				env.OnActivity((&kubeactivities.Nodes{}).DrainNode, mock.Anything, kubeactivities.DrainNodeInput{ClusterName: "mark-1", NodeName: "node-surge"}).
+					Return(&kubeactivities.DrainNodeOutput{}, nil).Once()
+				env.OnActivity((&kubeactivities.Nodes{}).DeleteNode, mock.Anything, kubeactivities.DeleteNodeInput{ClusterName: "mark-1", NodeName: "node-surge"}).
+					Return(&kubeactivities.DeleteNodeOutput{}, nil).Once()
+
+				onDetachInstance(env, "mark-1-ng-1-asg", "i-surge", true)
+				onTerminateInstance(env, "i-surge")
+			},
+		},
+		{
+			name:   "surge node not joined yet",
+			surged: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				onDescribeInstances(env, surgedInstances)
+				onListNodes(env, nodes...)
+
+				onDetachInstance(env, "mark-1-ng-1-asg", "i-surge", true)
+				onTerminateInstance(env, "i-surge")
+			},
+		},
+		{
+			name:   "surge instance launched later",
+			surged: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				onDescribeInstances(env, instances).Once()
+				onDescribeAutoScalingGroup(env, 3).Once()
+				onDescribeInstances(env, surgedInstances)
+				onListNodes(env, nodes...)
+
+				onDetachInstance(env, "mark-1-ng-1-asg", "i-surge", true)
+				onTerminateInstance(env, "i-surge")
+			},
+		},
+		{
+			// The desired capacity is never decremented on its own
+			name:   "surge not applied",
+			surged: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				onDescribeInstances(env, instances)
+				onDescribeAutoScalingGroup(env, 2).Once()
+
+				env.OnActivity((&awsactivities.AutoScaling{}).SetDesiredCapacity, mock.Anything, mock.Anything).
+					Return(&autoscaling.SetDesiredCapacityOutput{}, nil).Never()
+				env.OnActivity((&awsactivities.AutoScaling{}).DetachInstances, mock.Anything, mock.Anything).
+					Return(&autoscaling.DetachInstancesOutput{}, nil).Never()
+			},
+		},
+		{
+			name:   "surge instance never launched",
+			surged: true,
+			mock: func(env *testsuite.TestWorkflowEnvironment) {
+				onDescribeInstances(env, instances)
+				onDescribeAutoScalingGroup(env, 3)
+			},
+			err: "surge instance of auto scaling group mark-1-ng-1-asg was not launched within 10m0s",
+		},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.name, func(t *testing.T) {
+			env := newTestWorkflowEnvironment(t)
+
+			env.SetStartTime(surgedAt.Add(time.Minute))
+
+			testCase.mock(env)
+
+			env.ExecuteWorkflow(func(ctx workflow.Context) (RotationCheckpoint, error) {
+				checkpoint := RotationCheckpoint{
+					AutoScalingGroupName: "mark-1-ng-1-asg",
+					Nodes: []RotationNode{
+						{Name: "node-1", InstanceID: "i-1", Zone: "eu-west-1a"},
+						{Name: "node-2", InstanceID: "i-2", Zone: "eu-west-1b"},
+					},
+				}
+
+				checkpoint.InFlight = &InFlightRotation{
+					Node: checkpoint.Nodes[0],
+					Step: RotationStepScheduling,
+				}
+
+				if testCase.surged {
+					checkpoint.InFlight.Surged = true
+					checkpoint.InFlight.SurgedFrom = 2
+					checkpoint.InFlight.SurgedAt = surgedAt
+				}
+
+				outcome, err := recoverInFlightRotation(ctx, "mark-1", &checkpoint)
+				if err != nil {
+					return checkpoint, err
+				}
+
+				if outcome != InFlightOutcomeKept {
+					t.Errorf("expected outcome %q, got %q", InFlightOutcomeKept, outcome)
+				}
+
+				return checkpoint, nil
+			})
+
+			if testCase.err != "" {
+				if err := env.GetWorkflowError(); err == nil || !strings.Contains(err.Error(), testCase.err) {
+					t.Fatalf("expected error containing %q, got %v", testCase.err, err)
+				}
+
+				return
+			}
+
+			if err := env.GetWorkflowError(); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var checkpoint RotationCheckpoint
+
+			if err := env.GetWorkflowResult(&checkpoint); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if checkpoint.InFlight != nil {
+				t.Errorf("expected the in-flight rotation to be cleared, got %+v", checkpoint.InFlight)
+			}
+
+			// The node is kept
+			if len(checkpoint.Nodes) != 2 || checkpoint.RotatedNodes != 0 {
+				t.Errorf("expected nodes to be left untouched, got %+v (%d rotated)", checkpoint.Nodes, checkpoint.RotatedNodes)
+			}
+		})
+	}
+}
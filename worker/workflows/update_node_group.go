@@ -3,26 +3,46 @@ package workflows
 import (
 	"errors"
 	"fmt"
-	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/autoscaling"
 	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
-	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
 	"github.com/sagikazarmark/thesis/worker/cftemplates"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
-	v1 "k8s.io/api/core/v1"
 )
 
 // UpdateNodeGroupInput contains the input parameters for the [UpdateNodeGroup] workflow.
 type UpdateNodeGroupInput struct {
-	ClusterName       string
-	NodeGroupName     string
-	KubernetesVersion string
+	ClusterName string
+
+	// NodeGroup is the desired state of the node group.
+	// Only its name is used with Live.
+	NodeGroup cluster.NodeGroup
+
+	// Live derives the desired state of the node group from its current stack instead of NodeGroup.
+	Live *LiveNodeGroupUpdate `json:",omitempty"`
+
+	// Maintenance restricts node rotation to maintenance windows.
+	Maintenance cluster.Maintenance
+
+	// Rollout adds a canary node and approval gates to the node rotation.
+	Rollout RolloutOptions
+
+	// MaxNodesPerRun is the number of nodes rotated in a single workflow run before continuing as new.
+	// Defaults to [DefaultMaxNodesPerRun].
+	MaxNodesPerRun int
+
+	// MaxHistoryLength is the number of history events after which the workflow continues as new.
+	// Defaults to [DefaultMaxHistoryLength].
+	MaxHistoryLength int
+
+	// Checkpoint carries rotation progress over to the next run when the workflow continues as new.
+	// It is populated by the workflow itself and should be left empty when starting a new update.
+	Checkpoint *RotationCheckpoint
 }
 
 func (i UpdateNodeGroupInput) Validate() error {
@@ -30,252 +50,480 @@ func (i UpdateNodeGroupInput) Validate() error {
 		return errors.New("cluster name is required")
 	}
 
-	if i.NodeGroupName == "" {
-		return errors.New("node group name is required")
+	if i.Live != nil {
+		if i.NodeGroup.Name == "" {
+			return errors.New("node group name is required")
+		}
+	} else if err := i.NodeGroup.Validate(); err != nil {
+		return fmt.Errorf("node group(%s): %w", i.NodeGroup.Name, err)
 	}
 
-	if i.KubernetesVersion == "" {
-		return errors.New("kubernetes version is required")
+	if err := i.Maintenance.Validate(); err != nil {
+		return fmt.Errorf("maintenance: %w", err)
+	}
+
+	if err := i.Rollout.Validate(); err != nil {
+		return fmt.Errorf("rollout: %w", err)
+	}
+
+	if i.MaxNodesPerRun < 0 {
+		return errors.New("max nodes per run must not be negative")
+	}
+
+	if i.MaxHistoryLength < 0 {
+		return errors.New("max history length must not be negative")
 	}
 
 	return nil
 }
 
+// LiveNodeGroupUpdate updates a node group based on its current stack parameters.
+//
+// Only the image changes: nodes are rotated to the image currently recommended for the Kubernetes version of the node group
+// (or a new Kubernetes version). Workflows started long before they run (eg. by a schedule) use it
+// to avoid reverting changes made to the node group in the meantime.
+type LiveNodeGroupUpdate struct {
+	// KubernetesVersion is the Kubernetes version to upgrade the node group to.
+	// Defaults to the current version of the node group.
+	KubernetesVersion string `json:",omitempty"`
+}
+
 // UpdateNodeGroupOutput contains the return parameters for the [UpdateNodeGroup] workflow.
+//
+// A rolled back update fails with an error of type [ErrorTypeRolledBack] instead.
 type UpdateNodeGroupOutput struct{}
 
-// UpdateNodeGroup updates a node group in an EKS cluster to a new Kubernetes version.
-func UpdateNodeGroup(ctx workflow.Context, input UpdateNodeGroupInput) (*UpdateNodeGroupOutput, error) {
+// UpdateNodeGroup reconciles a node group in an EKS cluster with its desired state.
+//
+// The node group stack is only updated if any of its parameters differ from the desired state.
+// Changes to the auto scaling group (eg. scaling bounds) are applied in place,
+// every other change (eg. Kubernetes version, instance type) requires replacing the existing nodes.
+// Nodes not running the desired image (eg. because EKS published a new patch release of the image) are replaced as well.
+//
+// Nodes are rotated one by one. With [RolloutOptions.Canary], the first node is rotated separately
+// and the rest of the nodes are only rotated once the node group is healthy again (and the canary is approved if necessary).
+// To keep the workflow history within Temporal's limits,
+// the workflow periodically continues as new, carrying its progress over in [UpdateNodeGroupInput.Checkpoint].
+//
+// Updates changing the node group stack can be rolled back using [SignalRollback]
+// (or automatically with [RolloutOptions.RollbackOnFailure]): the stack is reverted to its previous parameters
+// (pinning the previous image) and nodes launched since the update are rotated back.
+//
+// Cancelling the workflow brings the node being rotated to a consistent state and releases the node group;
+// the workflow is cancelled with a [CancellationReport] describing the state left behind.
+//
+// The workflow runs under [NodeGroupRotationID]. When started under a different ID, it runs itself as a child workflow under that ID.
+func UpdateNodeGroup(ctx workflow.Context, input UpdateNodeGroupInput) (_ *UpdateNodeGroupOutput, err error) {
+	input.NodeGroup.Default()
+
 	if err := input.Validate(); err != nil {
 		return nil, err
 	}
 
-	var cfactivities awsactivities.CloudFormation
-	var nodeactivities kubeactivities.Nodes
-	var asgactivities awsactivities.AutoScaling
-	var ec2activities awsactivities.EC2
-
-	ngStackName := fmt.Sprintf("%s-%s", input.ClusterName, input.NodeGroupName)
+	// Started under a different ID (eg. by a schedule): take the node group lock by running as a child workflow
+	if workflow.GetInfo(ctx).WorkflowExecution.ID != NodeGroupRotationID(input.ClusterName, input.NodeGroup.Name) {
+		var output UpdateNodeGroupOutput
 
-	// Update self-managed node group (using cloudformation)
-	{
-		ao := workflow.ActivityOptions{
-			StartToCloseTimeout: 15 * time.Second,
+		err := runNodeGroupRotation(ctx, input.ClusterName, input.NodeGroup.Name, UpdateNodeGroup, input, &output, nil)
+		if err != nil {
+			return nil, err
 		}
-		ctx := workflow.WithActivityOptions(ctx, ao)
-
-		stackParameters := []cftypes.Parameter{
-			{
-				ParameterKey:     aws.String("ClusterName"),
-				UsePreviousValue: aws.Bool(true),
-			},
-			{
-				ParameterKey:     aws.String("NodeGroupName"),
-				UsePreviousValue: aws.Bool(true),
-			},
-			{
-				ParameterKey:     aws.String("VpcId"),
-				UsePreviousValue: aws.Bool(true),
-			},
-			{
-				ParameterKey:     aws.String("Subnets"),
-				UsePreviousValue: aws.Bool(true),
-			},
-			{
-				ParameterKey:     aws.String("ClusterControlPlaneSecurityGroup"),
-				UsePreviousValue: aws.Bool(true),
-			},
-			{
-				ParameterKey:     aws.String("KeyName"),
-				UsePreviousValue: aws.Bool(true),
-			},
-			{
-				ParameterKey:   aws.String("NodeImageIdSSMParam"),
-				ParameterValue: aws.String(fmt.Sprintf("/aws/service/eks/optimized-ami/%s/amazon-linux-2/recommended/image_id", input.KubernetesVersion)),
-			},
+
+		return &output, nil
+	}
+
+	checkpoint := input.Checkpoint
+
+	status := &Status{
+		Phase: "reconciling node group stack",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil {
+		status.Paused = checkpoint.Paused
+	}
+
+	pauser := newPauser(ctx, status)
+	rollbacker := newRollbacker(ctx, pauser)
+
+	defer func() {
+		err = releaseNodeGroupOnFailure(ctx, input.ClusterName, checkpoint, err)
+	}()
+
+	// rollbackOrFail starts rolling back the update if the error warrants it
+	rollbackOrFail := func(err error) error {
+		request, ok := rollbackRequest(err, input.Rollout)
+		if !ok {
+			return err
 		}
 
-		input := &cloudformation.UpdateStackInput{
-			StackName:    aws.String(ngStackName),
-			TemplateBody: aws.String(cftemplates.NodeGroup()),
-			Capabilities: []cftypes.Capability{
-				cftypes.CapabilityCapabilityIam,
-			},
-			Parameters: stackParameters,
+		if checkpoint.Rollback == nil {
+			return fmt.Errorf("%w (the node group stack was not updated, there is nothing to roll back)", err)
 		}
 
-		// TODO: handle no update error
-		err := workflow.ExecuteActivity(ctx, cfactivities.UpdateStack, input).Get(ctx, nil)
-		if err != nil {
-			return nil, err
+		// Nodes running the previous configuration are kept
+		if err := unmarkOutdatedNodes(ctx, input.ClusterName, checkpoint); err != nil {
+			return err
 		}
+
+		return startNodeGroupRollback(ctx, input.ClusterName, checkpoint, request, status)
 	}
 
-	// Wait for cloudformation stack
-	{
-		ao := workflow.ActivityOptions{
-			StartToCloseTimeout: 10 * time.Minute,
-			HeartbeatTimeout:    30 * time.Second,
-		}
-		ctx := workflow.WithActivityOptions(ctx, ao)
+	continueAsNew := func() error {
+		input.Checkpoint = checkpoint
+
+		return workflow.NewContinueAsNewError(ctx, UpdateNodeGroup, input)
+	}
 
-		err := workflow.ExecuteActivity(ctx, cfactivities.WaitForUpdateStack, ngStackName).Get(ctx, nil)
+	// Update the node group stack during the first run only
+	if checkpoint == nil {
+		var err error
+
+		checkpoint, err = prepareNodeGroupUpdate(ctx, input, status, pauser)
 		if err != nil {
 			return nil, err
 		}
-	}
 
-	// Grab node group details
-	var asgName string
-	{
-		ao := workflow.ActivityOptions{
-			StartToCloseTimeout: 15 * time.Second,
+		if len(checkpoint.Nodes) > 0 {
+			if err := suspendScaling(ctx, input.ClusterName, checkpoint); err != nil {
+				return nil, err
+			}
 		}
-		ctx := workflow.WithActivityOptions(ctx, ao)
 
-		input := &cloudformation.DescribeStacksInput{
-			StackName: aws.String(ngStackName),
+		if input.Rollout.CordonOutdated && len(checkpoint.Nodes) > 0 {
+			if err := markOutdatedNodes(ctx, input.ClusterName, checkpoint, false); err != nil {
+				return nil, err
+			}
 		}
 
-		var output *cloudformation.DescribeStacksOutput
+		if input.Rollout.Canary && len(checkpoint.Nodes) > 0 {
+			err := rotateCanary(ctx, input.ClusterName, checkpoint, input.Rollout, input.Maintenance, status, pauser)
+			if err == nil {
+				err = rollbacker.err()
+			}
 
-		err := workflow.ExecuteActivity(ctx, cfactivities.DescribeStacks, input).Get(ctx, &output)
-		if err != nil {
-			return nil, err
+			if err != nil {
+				if err := rollbackOrFail(err); err != nil {
+					return nil, err
+				}
+			}
 		}
+	}
 
-		if len(output.Stacks) == 0 {
-			return nil, errors.New("stack not found")
+	if checkpoint.RollbackReport == nil {
+		status.Phase = "rotating nodes"
+
+		options := rotationOptions{
+			MaxNodesPerRun:   input.MaxNodesPerRun,
+			MaxHistoryLength: input.MaxHistoryLength,
+			Maintenance:      input.Maintenance,
+			HealthCheck:      input.Rollout.HealthCheck,
+			SchedulingCheck:  input.Rollout.SchedulingCheck,
+			Interrupt:        rollbacker.err,
+
+			MaxUnavailablePerZone: input.Rollout.MaxUnavailablePerZone,
+			CordonOutdated:        input.Rollout.CordonOutdated,
+		}
+
+		done, err := rotateNodes(ctx, input.ClusterName, checkpoint, options, status, pauser)
+		if err == nil && !done {
+			rollbacker.drain(ctx, pauser)
+
+			err = rollbacker.err()
 		}
 
-		for _, output := range output.Stacks[0].Outputs {
-			switch aws.ToString(output.OutputKey) {
-			case "NodeAutoScalingGroup":
-				asgName = aws.ToString(output.OutputValue)
+		if err != nil {
+			if err := rollbackOrFail(err); err != nil {
+				return nil, err
 			}
+		} else if !done {
+			return nil, continueAsNew()
 		}
 	}
 
-	workflow.GetLogger(ctx).Info("node group details", "asg", asgName)
+	if report := checkpoint.RollbackReport; report != nil {
+		status.Phase = "rotating nodes back"
 
-	var nodes []v1.Node
-	{
-		ao := workflow.ActivityOptions{
-			StartToCloseTimeout: 15 * time.Second,
+		// Rolling back should not be stopped by the problems it is supposed to fix
+		options := rotationOptions{
+			MaxNodesPerRun:   input.MaxNodesPerRun,
+			MaxHistoryLength: input.MaxHistoryLength,
+			Maintenance:      input.Maintenance,
+			SchedulingCheck:  input.Rollout.SchedulingCheck,
+
+			MaxUnavailablePerZone: input.Rollout.MaxUnavailablePerZone,
 		}
-		ctx := workflow.WithActivityOptions(ctx, ao)
 
-		input := kubeactivities.ListNodesInput{
-			ClusterName: input.ClusterName,
+		done, err := rotateNodes(ctx, input.ClusterName, checkpoint, options, status, pauser)
+		if err != nil {
+			return nil, err
 		}
 
-		var output kubeactivities.ListNodesOutput
+		if !done {
+			return nil, continueAsNew()
+		}
 
-		err := workflow.ExecuteActivity(ctx, nodeactivities.ListNodes, input).Get(ctx, &output)
-		if err != nil {
+		if err := releaseNodeGroup(ctx, input.ClusterName, checkpoint); err != nil {
 			return nil, err
 		}
 
-		nodes = output.Nodes
+		status.Phase = "rolled back"
+
+		workflow.GetLogger(ctx).Info("node group update rolled back", "reason", report.Reason, "requester", report.Requester, "image", report.Image, "nodes", report.Nodes)
+
+		return nil, rolledBackError(*report)
 	}
 
-	for _, node := range nodes {
-		providerIDSegments := strings.Split(strings.TrimPrefix(node.Spec.ProviderID, "aws:///"), "/")
-		region, instanceID := providerIDSegments[0], providerIDSegments[1]
+	if err := releaseNodeGroup(ctx, input.ClusterName, checkpoint); err != nil {
+		return nil, err
+	}
 
-		workflow.GetLogger(ctx).Info("node details", "name", node.Name, "providerID", node.Spec.ProviderID, "region", region, "instanceId", instanceID)
+	status.Phase = "completed"
 
-		// Drain node
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: time.Minute,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
+	return nil, nil
+}
 
-			input := kubeactivities.DrainNodeInput{
-				ClusterName: input.ClusterName,
-				NodeName:    node.Name,
-			}
+// nodeGroupUpdatePlan describes the changes necessary to reconcile a node group with its desired state.
+type nodeGroupUpdatePlan struct {
+	StackName            string
+	AutoScalingGroupName string
 
-			err := workflow.ExecuteActivity(ctx, nodeactivities.DrainNode, input).Get(ctx, nil)
-			if err != nil {
-				return nil, err
-			}
+	CurrentParameters []cftypes.Parameter
+	DesiredParameters []cftypes.Parameter
+	DesiredImage      string
+
+	Changes []StackParameterChange
+
+	// Nodes are the current nodes of the node group.
+	Nodes []nodeGroupNode
+}
+
+// outdatedNodes returns the nodes that need to be rotated after applying the changes.
+//
+// Nodes are rotated if the stack changes require replacing them or if they don't run the desired image
+// (eg. because EKS published a new patch release of the image or a previous update was interrupted).
+func (p nodeGroupUpdatePlan) outdatedNodes() []nodeGroupNode {
+	replaceAll := requiresReplacement(p.Changes)
+
+	var nodes []nodeGroupNode
+
+	for _, node := range p.Nodes {
+		if !replaceAll && node.ImageID == p.DesiredImage {
+			continue
 		}
 
-		// Delete node
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: 15 * time.Second,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
+		nodes = append(nodes, node)
+	}
 
-			input := kubeactivities.DeleteNodeInput{
-				ClusterName: input.ClusterName,
-				NodeName:    node.Name,
-			}
+	return nodes
+}
+
+// planNodeGroupUpdate compares the node group stack and its nodes with the desired state of the node group
+// (or its current state with live, see [LiveNodeGroupUpdate]).
+func planNodeGroupUpdate(ctx workflow.Context, clusterName string, ng cluster.NodeGroup, live *LiveNodeGroupUpdate) (*nodeGroupUpdatePlan, error) {
+	plan := &nodeGroupUpdatePlan{
+		StackName: nodeGroupStackName(clusterName, ng.Name),
+	}
+
+	// Grab node group details
+	asgName, currentParameters, err := describeNodeGroupStack(ctx, plan.StackName)
+	if err != nil {
+		return nil, err
+	}
+
+	plan.AutoScalingGroupName = asgName
+	plan.CurrentParameters = currentParameters
+
+	workflow.GetLogger(ctx).Info("node group details", "asg", asgName)
 
-			err := workflow.ExecuteActivity(ctx, nodeactivities.DeleteNode, input).Get(ctx, nil)
+	if live != nil {
+		plan.DesiredParameters, plan.DesiredImage, err = liveNodeGroupStackParameters(ctx, currentParameters, live.KubernetesVersion)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// AL2 nodes are bootstrapped using the cluster name only
+		var controlPlane cluster.ControlPlane
+
+		if ng.AMI.Family != cluster.AMIFamilyAL2 {
+			controlPlane, err = describeControlPlane(ctx, clusterName)
 			if err != nil {
 				return nil, err
 			}
 		}
 
-		// TODO: verify node is gone
+		plan.DesiredParameters, err = nodeGroupStackParameters(clusterName, ng, controlPlane)
+		if err != nil {
+			return nil, err
+		}
 
-		// Detach instance from ASG
-		{
-			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: time.Minute,
-			}
-			ctx := workflow.WithActivityOptions(ctx, ao)
+		plan.DesiredImage, err = resolveNodeGroupImage(ctx, ng)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-			input := &autoscaling.DetachInstancesInput{
-				InstanceIds:                    []string{instanceID},
-				AutoScalingGroupName:           aws.String(asgName),
-				ShouldDecrementDesiredCapacity: aws.Bool(false),
-			}
+	plan.Changes = diffStackParameters(currentParameters, plan.DesiredParameters)
+
+	// The SSM parameter may point to a new image even if the parameter itself did not change
+	// (the stack resolves it during every update)
+	if currentImage := stackImage(currentParameters); len(plan.Changes) == 0 && currentImage != plan.DesiredImage {
+		plan.Changes = append(plan.Changes, StackParameterChange{
+			Key:      resolvedImageParameter,
+			Current:  currentImage,
+			Desired:  plan.DesiredImage,
+			Replaces: true,
+		})
+	}
+
+	plan.Nodes, err = listNodeGroupNodes(ctx, clusterName, asgName)
+	if err != nil {
+		return nil, err
+	}
 
-			err := workflow.ExecuteActivity(ctx, asgactivities.DetachInstances, input).Get(ctx, nil)
+	return plan, nil
+}
+
+// prepareNodeGroupUpdate updates the node group stack (if necessary) and collects the nodes that need to be rotated.
+//
+// Stack updates change the auto scaling group right away (eg. its size), so they are only applied inside maintenance windows.
+func prepareNodeGroupUpdate(ctx workflow.Context, input UpdateNodeGroupInput, status *Status, pauser *pauser) (*RotationCheckpoint, error) {
+	var cfactivities awsactivities.CloudFormation
+
+	plan, err := planNodeGroupUpdate(ctx, input.ClusterName, input.NodeGroup, input.Live)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(plan.Changes) > 0 {
+		waitingSince := workflow.Now(ctx)
+
+		if err := waitForDisruption(ctx, input.Maintenance, status, pauser); err != nil {
+			return nil, err
+		}
+
+		// The node group may have changed while waiting for the maintenance window
+		if workflow.Now(ctx).After(waitingSince) {
+			plan, err = planNodeGroupUpdate(ctx, input.ClusterName, input.NodeGroup, input.Live)
 			if err != nil {
 				return nil, err
 			}
 		}
+	}
+
+	for _, change := range plan.Changes {
+		workflow.GetLogger(ctx).Info("node group parameter changed", "parameter", change.Key, "current", change.Current, "desired", change.Desired, "replaces", change.Replaces)
+	}
 
-		// Terminate instance
+	// Nodes launched after this point may run the updated configuration
+	updatedAt := workflow.Now(ctx)
+
+	if len(plan.Changes) > 0 {
+		// Update self-managed node group (using cloudformation)
 		{
 			ao := workflow.ActivityOptions{
 				StartToCloseTimeout: 15 * time.Second,
 			}
 			ctx := workflow.WithActivityOptions(ctx, ao)
 
-			input := &ec2.TerminateInstancesInput{
-				InstanceIds: []string{instanceID},
+			var stackParameters []cftypes.Parameter
+
+			for _, key := range nodeGroupPreviousValueParameters {
+				stackParameters = append(stackParameters, cftypes.Parameter{
+					ParameterKey:     aws.String(key),
+					UsePreviousValue: aws.Bool(true),
+				})
 			}
 
-			err := workflow.ExecuteActivity(ctx, ec2activities.TerminateInstances, input).Get(ctx, nil)
-			if err != nil {
+			stackParameters = append(stackParameters, plan.DesiredParameters...)
+
+			input := &cloudformation.UpdateStackInput{
+				StackName:    aws.String(plan.StackName),
+				TemplateBody: aws.String(cftemplates.NodeGroup()),
+				Capabilities: []cftypes.Capability{
+					cftypes.CapabilityCapabilityIam,
+				},
+				Parameters: stackParameters,
+			}
+
+			err := workflow.ExecuteActivity(ctx, cfactivities.UpdateStack, input).Get(ctx, nil)
+			if isNoUpdatesError(err) {
+				// Rotating nodes would not make a difference if the stack did not pick up the new image
+				if plan.Changes[0].Key == resolvedImageParameter {
+					return nil, fmt.Errorf("node group stack did not pick up the new image %s", plan.DesiredImage)
+				}
+
+				// Changed parameters may still resolve to the same values
+				workflow.GetLogger(ctx).Info("node group stack is already up to date")
+
+				plan.Changes = nil
+			} else if err != nil {
 				return nil, err
 			}
 		}
 
-		// Wait for instance termination
-		{
+		// Wait for cloudformation stack
+		if len(plan.Changes) > 0 {
 			ao := workflow.ActivityOptions{
-				StartToCloseTimeout: 5 * time.Minute,
+				StartToCloseTimeout: 10 * time.Minute,
 				HeartbeatTimeout:    30 * time.Second,
 			}
 			ctx := workflow.WithActivityOptions(ctx, ao)
 
-			err := workflow.ExecuteActivity(ctx, ec2activities.WaitForInstanceTerminated, []string{instanceID}).Get(ctx, nil)
+			err := workflow.ExecuteActivity(ctx, cfactivities.WaitForUpdateStack, plan.StackName).Get(ctx, nil)
 			if err != nil {
 				return nil, err
 			}
 		}
+	}
 
-		// TODO: wait for node to rejoin
-		workflow.Sleep(ctx, time.Minute)
+	checkpoint := &RotationCheckpoint{
+		AutoScalingGroupName: plan.AutoScalingGroupName,
 	}
 
-	return nil, nil
+	// Record the previous state of the stack, so the update can be rolled back
+	if len(plan.Changes) > 0 {
+		checkpoint.Rollback = &NodeGroupRollback{
+			StackName: plan.StackName,
+			Image:     stackImage(plan.CurrentParameters),
+			Changes:   plan.Changes,
+			UpdatedAt: updatedAt,
+		}
+
+		for _, parameter := range plan.CurrentParameters {
+			checkpoint.Rollback.Parameters = append(checkpoint.Rollback.Parameters, cftypes.Parameter{
+				ParameterKey:   parameter.ParameterKey,
+				ParameterValue: parameter.ParameterValue,
+			})
+		}
+	}
+
+	nodes, err := orderNodes(ctx, input.ClusterName, plan.outdatedNodes(), input.Rollout.Order)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, node := range nodes {
+		checkpoint.Nodes = append(checkpoint.Nodes, RotationNode{
+			Name:       node.Name,
+			InstanceID: node.InstanceID,
+			Zone:       node.Zone,
+		})
+	}
+
+	if len(checkpoint.Nodes) == 0 {
+		workflow.GetLogger(ctx).Info("node group is already up to date")
+	}
+
+	return checkpoint, nil
+}
+
+// isNoUpdatesError reports whether a stack update failed because the stack is already up to date.
+func isNoUpdatesError(err error) bool {
+	var appErr *temporal.ApplicationError
+
+	return errors.As(err, &appErr) && appErr.Type() == awsactivities.ErrorTypeNoUpdates
 }
@@ -0,0 +1,282 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// UpgradeClusterInput contains the input parameters for the [UpgradeCluster] workflow.
+type UpgradeClusterInput struct {
+	Cluster cluster.Cluster
+
+	// Rollout adds a canary node and approval gates to node group updates.
+	Rollout RolloutOptions
+
+	// Live upgrades the existing node groups of the cluster (instead of the ones in Cluster) to the version of the control plane,
+	// keeping their current configuration (see [LiveNodeGroupUpdate]).
+	// Only the name, the Kubernetes version and the maintenance windows of Cluster are used.
+	Live bool
+}
+
+func (i UpgradeClusterInput) Validate() error {
+	if i.Live {
+		if i.Cluster.Name == "" {
+			return errors.New("cluster name is required")
+		}
+
+		if i.Cluster.Kubernetes.Version == "" {
+			return errors.New("kubernetes version is required")
+		}
+
+		if err := i.Cluster.Maintenance.Validate(); err != nil {
+			return fmt.Errorf("maintenance: %w", err)
+		}
+	} else if err := i.Cluster.Validate(); err != nil {
+		return err
+	}
+
+	if err := i.Rollout.Validate(); err != nil {
+		return fmt.Errorf("rollout: %w", err)
+	}
+
+	return nil
+}
+
+// UpgradeClusterOutput contains the return parameters for the [UpgradeCluster] workflow.
+type UpgradeClusterOutput struct{}
+
+// UpgradeCluster upgrades the control plane and the node groups of an EKS cluster to the versions in the cluster spec.
+//
+// EKS only supports upgrading the control plane one minor version at a time,
+// so the control plane is upgraded through every intermediate version.
+// Node groups are upgraded (one by one) after the control plane using the [UpdateNodeGroup] workflow.
+// With [RolloutOptions.ApproveNodeGroups], every node group but the first waits for an [Approval].
+// With [UpgradeClusterInput.Live], node groups are upgraded based on their current state instead of the spec.
+func UpgradeCluster(ctx workflow.Context, input UpgradeClusterInput) (*UpgradeClusterOutput, error) {
+	input.Cluster.Default()
+
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	status := &Status{
+		Phase: "upgrading control plane",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
+	pauser := newPauser(ctx, status)
+
+	var eksactivities awsactivities.EKS
+
+	// Grab cluster details
+	var currentVersion string
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: 15 * time.Second,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		input := &eks.DescribeClusterInput{
+			Name: aws.String(input.Cluster.Name),
+		}
+
+		var output *eks.DescribeClusterOutput
+
+		err := workflow.ExecuteActivity(ctx, eksactivities.DescribeCluster, input).Get(ctx, &output)
+		if err != nil {
+			return nil, err
+		}
+
+		currentVersion = aws.ToString(output.Cluster.Version)
+	}
+
+	err := upgradeControlPlane(ctx, input.Cluster.Name, currentVersion, input.Cluster.Kubernetes.Version, input.Cluster.Maintenance, status, pauser)
+	if err != nil {
+		return nil, err
+	}
+
+	status.Phase = "upgrading node groups"
+
+	var updates []UpdateNodeGroupInput
+
+	if input.Live {
+		nodeGroups, err := listNodeGroups(ctx, input.Cluster.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, name := range nodeGroups {
+			updates = append(updates, UpdateNodeGroupInput{
+				ClusterName: input.Cluster.Name,
+				NodeGroup: cluster.NodeGroup{
+					Name: name,
+				},
+				Live: &LiveNodeGroupUpdate{
+					KubernetesVersion: input.Cluster.Kubernetes.Version,
+				},
+				Maintenance: input.Cluster.Maintenance,
+				Rollout:     input.Rollout,
+			})
+		}
+	} else {
+		for _, ng := range input.Cluster.NodeGroups {
+			updates = append(updates, UpdateNodeGroupInput{
+				ClusterName: input.Cluster.Name,
+				NodeGroup:   ng,
+				Maintenance: input.Cluster.Maintenance,
+				Rollout:     input.Rollout,
+			})
+		}
+	}
+
+	status.Total = len(updates)
+
+	for i, update := range updates {
+		ng := update.NodeGroup
+
+		if err := pauser.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		if i > 0 && input.Rollout.ApproveNodeGroups {
+			status.Phase = fmt.Sprintf("waiting for approval to upgrade node group %s", ng.Name)
+
+			err := waitForApproval(ctx, nodeGroupApprovalGate(ng.Name), input.Rollout.withDefaults().ApprovalTimeout, status)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		status.Phase = fmt.Sprintf("upgrading node group %s", ng.Name)
+
+		err := runNodeGroupRotation(ctx, update.ClusterName, ng.Name, UpdateNodeGroup, update, nil, pauser)
+		if err != nil {
+			return nil, err
+		}
+
+		status.Completed++
+	}
+
+	status.Phase = "completed"
+
+	return nil, nil
+}
+
+// upgradeControlPlane upgrades the control plane of an EKS cluster through every minor version up to the desired one.
+//
+// Version updates are only started inside maintenance windows.
+func upgradeControlPlane(ctx workflow.Context, clusterName string, currentVersion string, desiredVersion string, maintenance cluster.Maintenance, status *Status, pauser *pauser) error {
+	var eksactivities awsactivities.EKS
+
+	for currentVersion != desiredVersion {
+		version, err := nextMinorVersion(currentVersion, desiredVersion)
+		if err != nil {
+			return err
+		}
+
+		status.Phase = fmt.Sprintf("upgrading control plane to %s", version)
+
+		if err := waitForDisruption(ctx, maintenance, status, pauser); err != nil {
+			return err
+		}
+
+		workflow.GetLogger(ctx).Info("upgrading control plane", "from", currentVersion, "to", version)
+
+		// Update cluster version
+		var updateID string
+		{
+			ao := workflow.ActivityOptions{
+				StartToCloseTimeout: 15 * time.Second,
+			}
+			ctx := workflow.WithActivityOptions(ctx, ao)
+
+			input := &eks.UpdateClusterVersionInput{
+				Name:    aws.String(clusterName),
+				Version: aws.String(version),
+			}
+
+			var output *eks.UpdateClusterVersionOutput
+
+			err := workflow.ExecuteActivity(ctx, eksactivities.UpdateClusterVersion, input).Get(ctx, &output)
+			if err != nil {
+				return err
+			}
+
+			updateID = aws.ToString(output.Update.Id)
+		}
+
+		// Wait for update
+		{
+			ao := workflow.ActivityOptions{
+				StartToCloseTimeout: time.Hour,
+				HeartbeatTimeout:    time.Minute,
+			}
+			ctx := workflow.WithActivityOptions(ctx, ao)
+
+			input := &eks.DescribeUpdateInput{
+				Name:     aws.String(clusterName),
+				UpdateId: aws.String(updateID),
+			}
+
+			err := workflow.ExecuteActivity(ctx, eksactivities.WaitForUpdate, input).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+		}
+
+		currentVersion = version
+	}
+
+	return nil
+}
+
+// nextMinorVersion returns the next Kubernetes minor version on the way from current to desired.
+func nextMinorVersion(current string, desired string) (string, error) {
+	currentMajor, currentMinor, err := parseMinorVersion(current)
+	if err != nil {
+		return "", err
+	}
+
+	desiredMajor, desiredMinor, err := parseMinorVersion(desired)
+	if err != nil {
+		return "", err
+	}
+
+	if currentMajor != desiredMajor || currentMinor > desiredMinor {
+		return "", fmt.Errorf("cannot upgrade from %s to %s", current, desired)
+	}
+
+	return fmt.Sprintf("%d.%d", currentMajor, currentMinor+1), nil
+}
+
+func parseMinorVersion(version string) (int, int, error) {
+	major, minor, ok := strings.Cut(version, ".")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid version: %q", version)
+	}
+
+	majorNum, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid version: %q", version)
+	}
+
+	minorNum, err := strconv.Atoi(minor)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid version: %q", version)
+	}
+
+	return majorNum, minorNum, nil
+}
@@ -0,0 +1,51 @@
+package workflows
+
+import (
+	"testing"
+)
+
+func TestNextMinorVersion(t *testing.T) {
+	testCases := []struct {
+		current string
+		desired string
+
+		next string
+		err  bool
+	}{
+		{current: "1.27", desired: "1.28", next: "1.28"},
+		{current: "1.27", desired: "1.30", next: "1.28"},
+		{current: "1.9", desired: "1.10", next: "1.10"},
+
+		// Callers stop once the desired version is reached
+		{current: "1.28", desired: "1.28", next: "1.29"},
+
+		{current: "1.28", desired: "1.27", err: true},
+		{current: "1.28", desired: "2.0", err: true},
+		{current: "1.28.3", desired: "1.29", err: true},
+		{current: "1", desired: "1.29", err: true},
+		{current: "1.28", desired: "latest", err: true},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.current+"->"+testCase.desired, func(t *testing.T) {
+			next, err := nextMinorVersion(testCase.current, testCase.desired)
+			if testCase.err {
+				if err == nil {
+					t.Fatalf("expected an error, got %q", next)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if next != testCase.next {
+				t.Errorf("expected %q, got %q", testCase.next, next)
+			}
+		})
+	}
+}
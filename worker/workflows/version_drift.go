@@ -0,0 +1,478 @@
+package workflows
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/workflow"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+// DefaultUpgradeBefore is the time before the end of standard support clusters are proposed for an upgrade.
+const DefaultUpgradeBefore = 60 * 24 * time.Hour
+
+// Support states of a Kubernetes version reported by [DetectVersionDrift].
+const (
+	VersionSupportStandard = "Standard"
+
+	// VersionSupportEndingSoon means standard support ends within [DetectVersionDriftInput.UpgradeBefore].
+	VersionSupportEndingSoon = "EndingSoon"
+
+	// VersionSupportExtended means the cluster is billed for extended support.
+	VersionSupportExtended = "Extended"
+
+	VersionSupportUnsupported = "Unsupported"
+
+	// VersionSupportUnknown means EKS supports the version, but its support dates are unknown
+	// (EKS did not return them and the version is not in the release calendar, see [cluster.LookupVersionSupport]).
+	VersionSupportUnknown = "Unknown"
+)
+
+// DetectVersionDriftInput contains the input parameters for the [DetectVersionDrift] workflow.
+type DetectVersionDriftInput struct {
+	// Clusters are the specs of clusters that may be upgraded automatically.
+	// Their desired versions are included in the report as well.
+	Clusters []cluster.Cluster
+
+	// AutoUpgrade starts an [UpgradeCluster] workflow for clusters (with a spec) proposed for an upgrade.
+	AutoUpgrade bool
+
+	// UpgradeBefore is the time before the end of standard support clusters are proposed for an upgrade.
+	// Defaults to [DefaultUpgradeBefore].
+	UpgradeBefore time.Duration
+}
+
+func (i DetectVersionDriftInput) Validate() error {
+	for _, c := range i.Clusters {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("cluster(%s): %w", c.Name, err)
+		}
+	}
+
+	if i.UpgradeBefore < 0 {
+		return errors.New("upgrade before must not be negative")
+	}
+
+	return nil
+}
+
+// DetectVersionDriftOutput contains the return parameters for the [DetectVersionDrift] workflow.
+type DetectVersionDriftOutput struct {
+	Report VersionDriftReport
+}
+
+// VersionDriftReport describes the Kubernetes versions of managed clusters compared to the versions supported by EKS.
+type VersionDriftReport struct {
+	GeneratedAt time.Time
+
+	// SupportedVersions are the versions currently supported by EKS (oldest first).
+	SupportedVersions []string
+
+	Clusters []ClusterVersionDrift
+
+	// CalendarFallback is set if the support dates of any reported version come from the release calendar built into the worker.
+	CalendarFallback *VersionCalendarFallback `json:",omitempty"`
+}
+
+// VersionCalendarFallback describes the use of the release calendar built into the worker (see [cluster.LookupVersionSupport]).
+//
+// The calendar is only used for versions EKS does not return support dates for, and it may be outdated.
+type VersionCalendarFallback struct {
+	// Versions are the reported versions whose support dates come from the calendar.
+	Versions []string
+
+	// UpdatedAt is the date the calendar was last updated.
+	UpdatedAt time.Time
+
+	// Age is the age of the calendar when the report was generated.
+	Age time.Duration
+}
+
+// ClusterVersionDrift describes the Kubernetes versions of a cluster.
+type ClusterVersionDrift struct {
+	Name string
+
+	// Version is the version of the control plane.
+	Version string
+
+	// DesiredVersion is the version in the cluster spec (if the spec is known).
+	DesiredVersion string `json:",omitempty"`
+
+	// Support is the support state of the control plane version.
+	Support string
+
+	// Support dates of the control plane version (zero if unknown).
+	cluster.VersionSupport
+
+	NodeGroups []NodeGroupVersionDrift
+
+	// ProposedVersion is the oldest version with standard support beyond [DetectVersionDriftInput.UpgradeBefore].
+	// It is empty if the cluster does not need an upgrade.
+	ProposedVersion string `json:",omitempty"`
+
+	// UpgradeWorkflowID is the ID of the upgrade started automatically (if any).
+	UpgradeWorkflowID string `json:",omitempty"`
+
+	// Error is set if the cluster could not be checked or upgraded.
+	Error string `json:",omitempty"`
+}
+
+// NodeGroupVersionDrift describes the Kubernetes version of a node group.
+type NodeGroupVersionDrift struct {
+	Name    string
+	Version string
+	Support string
+}
+
+// DetectVersionDrift compares the Kubernetes versions of managed clusters with the versions supported by EKS.
+//
+// Clusters created by this project are found by their VPC stack. Clusters approaching the end of standard support
+// (and clusters already in extended support) are proposed for an upgrade. With [DetectVersionDriftInput.AutoUpgrade],
+// an [UpgradeCluster] workflow is started for them (if their spec is known) with every existing node group upgraded to the proposed version.
+// Upgrades are not waited for: they outlive the workflow.
+//
+// The workflow is meant to be run on a Temporal schedule.
+func DetectVersionDrift(ctx workflow.Context, input DetectVersionDriftInput) (*DetectVersionDriftOutput, error) {
+	specs := make(map[string]cluster.Cluster, len(input.Clusters))
+
+	for i := range input.Clusters {
+		input.Clusters[i].Default()
+
+		specs[input.Clusters[i].Name] = input.Clusters[i]
+	}
+
+	if err := input.Validate(); err != nil {
+		return nil, err
+	}
+
+	upgradeBefore := input.UpgradeBefore
+	if upgradeBefore == 0 {
+		upgradeBefore = DefaultUpgradeBefore
+	}
+
+	status := &Status{
+		Phase: "listing clusters",
+	}
+
+	if err := setStatusQueryHandler(ctx, status); err != nil {
+		return nil, err
+	}
+
+	var eksactivities awsactivities.EKS
+
+	var clusterNames []string
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		err := workflow.ExecuteActivity(ctx, eksactivities.ListClusters).Get(ctx, &clusterNames)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(clusterNames)
+
+	var clusterVersions []awsactivities.ClusterVersion
+	{
+		ao := workflow.ActivityOptions{
+			StartToCloseTimeout: time.Minute,
+		}
+		ctx := workflow.WithActivityOptions(ctx, ao)
+
+		err := workflow.ExecuteActivity(ctx, eksactivities.ListClusterVersions).Get(ctx, &clusterVersions)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	supportedVersions := make([]string, 0, len(clusterVersions))
+	supportDates := make(map[string]cluster.VersionSupport, len(clusterVersions))
+
+	for _, version := range clusterVersions {
+		supportedVersions = append(supportedVersions, version.Version)
+
+		if !version.StandardSupportEnd.IsZero() && !version.ExtendedSupportEnd.IsZero() {
+			supportDates[version.Version] = cluster.VersionSupport{
+				StandardSupportEnd: version.StandardSupportEnd,
+				ExtendedSupportEnd: version.ExtendedSupportEnd,
+			}
+		}
+	}
+
+	sort.Slice(supportedVersions, func(i, j int) bool {
+		return compareMinorVersions(supportedVersions[i], supportedVersions[j]) < 0
+	})
+
+	checker := versionSupportChecker{
+		now:           workflow.Now(ctx),
+		upgradeBefore: upgradeBefore,
+		supported:     supportedVersions,
+		supportDates:  supportDates,
+	}
+
+	report := VersionDriftReport{
+		GeneratedAt:       checker.now,
+		SupportedVersions: supportedVersions,
+	}
+
+	status.Phase = "checking clusters"
+	status.Total = len(clusterNames)
+
+	for _, name := range clusterNames {
+		drift, err := checkClusterVersionDrift(ctx, name, checker)
+
+		status.Completed++
+
+		if errors.Is(err, errVPCStackNotFound) {
+			// Not managed by this project
+			continue
+		} else if err != nil {
+			workflow.GetLogger(ctx).Error("checking cluster failed", "cluster", name, "error", err)
+
+			drift.Error = err.Error()
+		}
+
+		if spec, ok := specs[name]; ok {
+			drift.DesiredVersion = spec.Kubernetes.Version
+
+			if input.AutoUpgrade && drift.ProposedVersion != "" {
+				drift.UpgradeWorkflowID, err = startProposedUpgrade(ctx, spec, drift.ProposedVersion)
+				if err != nil {
+					workflow.GetLogger(ctx).Error("starting upgrade failed", "cluster", name, "error", err)
+
+					drift.Error = err.Error()
+				}
+			}
+		}
+
+		if drift.ProposedVersion != "" {
+			workflow.GetLogger(ctx).Warn(
+				"cluster should be upgraded",
+				"cluster", name,
+				"version", drift.Version,
+				"support", drift.Support,
+				"proposedVersion", drift.ProposedVersion,
+			)
+		}
+
+		report.Clusters = append(report.Clusters, drift)
+	}
+
+	report.CalendarFallback = checker.calendarFallback(report.Clusters)
+
+	if fallback := report.CalendarFallback; fallback != nil {
+		workflow.GetLogger(ctx).Warn("support dates taken from the built-in release calendar", "versions", fallback.Versions, "calendarAge", fallback.Age)
+	}
+
+	status.Phase = "completed"
+
+	return &DetectVersionDriftOutput{Report: report}, nil
+}
+
+// checkClusterVersionDrift describes the versions of a cluster.
+//
+// It returns [errVPCStackNotFound] if the cluster is not managed by this project.
+func checkClusterVersionDrift(ctx workflow.Context, clusterName string, checker versionSupportChecker) (ClusterVersionDrift, error) {
+	drift := ClusterVersionDrift{
+		Name: clusterName,
+	}
+
+	observed, ngStacks, err := observeCluster(ctx, clusterName)
+	if err != nil {
+		return drift, err
+	}
+
+	drift.Version = observed.Version
+	drift.Support = checker.support(observed.Version)
+	drift.VersionSupport, _, _ = checker.lookup(observed.Version)
+
+	for _, name := range observed.NodeGroups {
+		version := stackKubernetesVersion(ngStacks[name].Parameters)
+
+		drift.NodeGroups = append(drift.NodeGroups, NodeGroupVersionDrift{
+			Name:    name,
+			Version: version,
+			Support: checker.support(version),
+		})
+	}
+
+	switch drift.Support {
+	case VersionSupportEndingSoon, VersionSupportExtended, VersionSupportUnsupported:
+		drift.ProposedVersion = checker.proposeVersion(observed.Version)
+	}
+
+	return drift, nil
+}
+
+// startProposedUpgrade starts an [UpgradeCluster] workflow upgrading the cluster (and every node group) to the proposed version.
+//
+// The version in the spec is used instead if it is newer. Node groups are upgraded based on their current state
+// (see [UpgradeClusterInput.Live]): the spec may be outdated by the time the workflow runs.
+func startProposedUpgrade(ctx workflow.Context, spec cluster.Cluster, proposedVersion string) (string, error) {
+	version := proposedVersion
+	if compareMinorVersions(spec.Kubernetes.Version, version) > 0 {
+		version = spec.Kubernetes.Version
+	}
+
+	input := UpgradeClusterInput{
+		Cluster: cluster.Cluster{
+			Name: spec.Name,
+			Kubernetes: cluster.ClusterKubernetes{
+				Version: version,
+			},
+			Maintenance: spec.Maintenance,
+		},
+		Live: true,
+	}
+
+	if err := input.Validate(); err != nil {
+		return "", err
+	}
+
+	id := WorkflowID(spec.Name, "upgrade")
+
+	cwo := workflow.ChildWorkflowOptions{
+		WorkflowID: id,
+
+		// The upgrade may take much longer than the schedule interval
+		ParentClosePolicy: enumspb.PARENT_CLOSE_POLICY_ABANDON,
+	}
+	ctx = workflow.WithChildOptions(ctx, cwo)
+
+	future := workflow.ExecuteChildWorkflow(ctx, UpgradeCluster, input)
+
+	// Fails if an upgrade is already running
+	if err := future.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		return "", err
+	}
+
+	workflow.GetLogger(ctx).Info("started upgrade", "cluster", spec.Name, "version", version, "workflowID", id)
+
+	return id, nil
+}
+
+// versionSupportChecker determines the support state of Kubernetes versions at a point in time.
+type versionSupportChecker struct {
+	now           time.Time
+	upgradeBefore time.Duration
+
+	// supported are the versions currently supported by EKS (oldest first).
+	supported []string
+
+	// supportDates are the support dates returned by EKS.
+	supportDates map[string]cluster.VersionSupport
+}
+
+// lookup returns the support dates of a version returned by EKS,
+// falling back to the release calendar built into the worker (see [cluster.LookupVersionSupport]).
+func (c versionSupportChecker) lookup(version string) (support cluster.VersionSupport, ok bool, fallback bool) {
+	if support, ok := c.supportDates[version]; ok {
+		return support, true, false
+	}
+
+	support, ok = cluster.LookupVersionSupport(version)
+
+	return support, ok, ok
+}
+
+// calendarFallback describes the use of the release calendar for the versions in the report (nil if it was not used).
+func (c versionSupportChecker) calendarFallback(clusters []ClusterVersionDrift) *VersionCalendarFallback {
+	var versions []string
+
+	add := func(version string) {
+		if _, _, fallback := c.lookup(version); fallback && !slices.Contains(versions, version) {
+			versions = append(versions, version)
+		}
+	}
+
+	for _, drift := range clusters {
+		add(drift.Version)
+
+		for _, ng := range drift.NodeGroups {
+			add(ng.Version)
+		}
+	}
+
+	if len(versions) == 0 {
+		return nil
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return compareMinorVersions(versions[i], versions[j]) < 0
+	})
+
+	return &VersionCalendarFallback{
+		Versions:  versions,
+		UpdatedAt: cluster.VersionCalendarUpdatedAt,
+		Age:       c.now.Sub(cluster.VersionCalendarUpdatedAt),
+	}
+}
+
+func (c versionSupportChecker) support(version string) string {
+	support, ok, _ := c.lookup(version)
+	if !ok {
+		for _, v := range c.supported {
+			if v == version {
+				return VersionSupportUnknown
+			}
+		}
+
+		return VersionSupportUnsupported
+	}
+
+	switch {
+	case !c.now.Before(support.ExtendedSupportEnd):
+		return VersionSupportUnsupported
+
+	case !c.now.Before(support.StandardSupportEnd):
+		return VersionSupportExtended
+
+	case !c.now.Before(support.StandardSupportEnd.Add(-c.upgradeBefore)):
+		return VersionSupportEndingSoon
+
+	default:
+		return VersionSupportStandard
+	}
+}
+
+// proposeVersion returns the oldest supported version newer than the current one that is not approaching the end of standard support.
+func (c versionSupportChecker) proposeVersion(current string) string {
+	for _, version := range c.supported {
+		if compareMinorVersions(version, current) <= 0 {
+			continue
+		}
+
+		switch c.support(version) {
+		case VersionSupportStandard, VersionSupportUnknown:
+			return version
+		}
+	}
+
+	return ""
+}
+
+// compareMinorVersions compares two Kubernetes minor versions (invalid versions are compared as strings).
+func compareMinorVersions(a string, b string) int {
+	aMajor, aMinor, aErr := parseMinorVersion(a)
+	bMajor, bMinor, bErr := parseMinorVersion(b)
+
+	if aErr != nil || bErr != nil {
+		return strings.Compare(a, b)
+	}
+
+	if aMajor != bMajor {
+		return aMajor - bMajor
+	}
+
+	return aMinor - bMinor
+}
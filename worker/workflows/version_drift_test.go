@@ -0,0 +1,190 @@
+package workflows
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sagikazarmark/thesis/worker/cluster"
+)
+
+func TestCompareMinorVersions(t *testing.T) {
+	testCases := []struct {
+		a string
+		b string
+
+		sign int
+	}{
+		{a: "1.28", b: "1.28", sign: 0},
+		{a: "1.27", b: "1.28", sign: -1},
+		{a: "1.10", b: "1.9", sign: 1},
+		{a: "2.0", b: "1.30", sign: 1},
+
+		// Invalid versions are compared as strings
+		{a: "latest", b: "1.28", sign: 1},
+		{a: "1.28", b: "latest", sign: -1},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.a+"<=>"+testCase.b, func(t *testing.T) {
+			result := compareMinorVersions(testCase.a, testCase.b)
+
+			if sign(result) != testCase.sign {
+				t.Errorf("expected a result with sign %d, got %d", testCase.sign, result)
+			}
+		})
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func newTestVersionSupportChecker() versionSupportChecker {
+	return versionSupportChecker{
+		now:           time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC),
+		upgradeBefore: DefaultUpgradeBefore,
+		supported:     []string{"1.31", "1.32", "1.33", "1.34", "1.35"},
+		supportDates: map[string]cluster.VersionSupport{
+			"1.31": {
+				StandardSupportEnd: time.Date(2025, time.November, 26, 0, 0, 0, 0, time.UTC),
+				ExtendedSupportEnd: time.Date(2026, time.November, 26, 0, 0, 0, 0, time.UTC),
+			},
+			"1.32": {
+				StandardSupportEnd: time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC),
+				ExtendedSupportEnd: time.Date(2027, time.November, 1, 0, 0, 0, 0, time.UTC),
+			},
+			"1.33": {
+				StandardSupportEnd: time.Date(2027, time.June, 1, 0, 0, 0, 0, time.UTC),
+				ExtendedSupportEnd: time.Date(2028, time.June, 1, 0, 0, 0, 0, time.UTC),
+			},
+			// 1.34 is missing: it comes from the release calendar
+			// 1.35 is missing: it's not in the release calendar either
+		},
+	}
+}
+
+func TestVersionSupportChecker_Support(t *testing.T) {
+	checker := newTestVersionSupportChecker()
+
+	testCases := []struct {
+		version string
+		support string
+	}{
+		{version: "1.31", support: VersionSupportExtended},
+		{version: "1.32", support: VersionSupportEndingSoon},
+		{version: "1.33", support: VersionSupportStandard},
+
+		// Release calendar: standard support ends on 2 December 2026
+		{version: "1.34", support: VersionSupportEndingSoon},
+
+		{version: "1.35", support: VersionSupportUnknown},
+
+		// Release calendar: extended support ended on 23 March 2026
+		{version: "1.29", support: VersionSupportUnsupported},
+
+		{version: "1.20", support: VersionSupportUnsupported},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.version, func(t *testing.T) {
+			support := checker.support(testCase.version)
+
+			if support != testCase.support {
+				t.Errorf("expected %q, got %q", testCase.support, support)
+			}
+		})
+	}
+}
+
+func TestVersionSupportChecker_ProposeVersion(t *testing.T) {
+	checker := newTestVersionSupportChecker()
+
+	testCases := []struct {
+		current  string
+		proposed string
+	}{
+		{current: "1.30", proposed: "1.33"},
+		{current: "1.31", proposed: "1.33"},
+
+		// Versions with unknown support dates are proposed rather than nothing
+		{current: "1.33", proposed: "1.35"},
+
+		{current: "1.35", proposed: ""},
+	}
+
+	for _, testCase := range testCases {
+		testCase := testCase
+
+		t.Run(testCase.current, func(t *testing.T) {
+			proposed := checker.proposeVersion(testCase.current)
+
+			if proposed != testCase.proposed {
+				t.Errorf("expected %q, got %q", testCase.proposed, proposed)
+			}
+		})
+	}
+}
+
+func TestVersionSupportChecker_CalendarFallback(t *testing.T) {
+	checker := newTestVersionSupportChecker()
+
+	t.Run("not used", func(t *testing.T) {
+		clusters := []ClusterVersionDrift{
+			{
+				Version: "1.33",
+				NodeGroups: []NodeGroupVersionDrift{
+					{Version: "1.32"},
+					{Version: "1.35"},
+				},
+			},
+		}
+
+		if fallback := checker.calendarFallback(clusters); fallback != nil {
+			t.Errorf("expected no fallback, got %+v", fallback)
+		}
+	})
+
+	t.Run("used", func(t *testing.T) {
+		clusters := []ClusterVersionDrift{
+			{
+				Version: "1.34",
+				NodeGroups: []NodeGroupVersionDrift{
+					{Version: "1.34"},
+					{Version: "1.29"},
+				},
+			},
+			{
+				Version: "1.33",
+			},
+		}
+
+		fallback := checker.calendarFallback(clusters)
+		if fallback == nil {
+			t.Fatal("expected a fallback")
+		}
+
+		if want := []string{"1.29", "1.34"}; !slices.Equal(fallback.Versions, want) {
+			t.Errorf("expected versions %v, got %v", want, fallback.Versions)
+		}
+
+		if !fallback.UpdatedAt.Equal(cluster.VersionCalendarUpdatedAt) {
+			t.Errorf("expected calendar date %s, got %s", cluster.VersionCalendarUpdatedAt, fallback.UpdatedAt)
+		}
+
+		if want := checker.now.Sub(cluster.VersionCalendarUpdatedAt); fallback.Age != want {
+			t.Errorf("expected age %s, got %s", want, fallback.Age)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package workflows
+
+import "go.temporal.io/sdk/workflow"
+
+// patched reports whether the workflow execution should follow the code path introduced by an in-place change
+// (see "Versioning" in the package documentation):
+//
+//	if patched(ctx, "node-rejoin-check") {
+//		// new code
+//	} else {
+//		// old code
+//	}
+//
+// Executions that reached this point before the change was deployed keep following the old code path.
+func patched(ctx workflow.Context, changeID string) bool {
+	return workflow.GetVersion(ctx, changeID, workflow.DefaultVersion, 1) == 1
+}
+
+// deprecatePatch marks an in-place change as permanent, once executions following the old code path are gone.
+//
+// It replaces the [patched] condition (and the old code path). Once no running executions depend on the marker,
+// the call can be removed as well.
+func deprecatePatch(ctx workflow.Context, changeID string) {
+	_ = workflow.GetVersion(ctx, changeID, 1, 1)
+}
@@ -1,9 +1,92 @@
 package workflows
 
-import "go.temporal.io/sdk/worker"
+import (
+	"fmt"
+	"strings"
 
-func RegisterWorkflows(w worker.Worker) {
+	enumspb "go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/worker"
+	"go.temporal.io/sdk/workflow"
+)
+
+// TaskQueue is the task queue workflows and activities are registered on.
+const TaskQueue = "thesis"
+
+// RegisterWorkflows registers workflows in a Temporal Worker (or a [worker.WorkflowReplayer]).
+func RegisterWorkflows(w worker.WorkflowRegistry) {
 	w.RegisterWorkflow(CreateCluster)
 	w.RegisterWorkflow(DeleteCluster)
+	w.RegisterWorkflow(UpgradeCluster)
 	w.RegisterWorkflow(UpdateNodeGroup)
+	w.RegisterWorkflow(RefreshNodeGroupImages)
+	w.RegisterWorkflow(RecycleNodes)
+	w.RegisterWorkflow(ReconcileCluster)
+	w.RegisterWorkflow(ClusterEntity)
+	w.RegisterWorkflow(DetectVersionDrift)
+}
+
+// WorkflowID returns a deterministic workflow ID for an operation on a cluster.
+//
+// Using deterministic IDs prevents running the same operation on a cluster more than once at the same time.
+func WorkflowID(clusterName string, operation ...string) string {
+	id := fmt.Sprintf("cluster/%s", clusterName)
+
+	for _, op := range operation {
+		id = fmt.Sprintf("%s/%s", id, op)
+	}
+
+	return id
+}
+
+// NodeGroupRotationID returns the workflow ID of the workflow rotating the nodes of a node group.
+//
+// Workflows replacing nodes ([UpdateNodeGroup] and [RecycleNodes]) always run under this ID (regardless of how they are started),
+// so at most one of them rotates the nodes of a node group at a time.
+func NodeGroupRotationID(clusterName string, nodeGroupName string) string {
+	return WorkflowID(clusterName, "nodegroup", nodeGroupName, "rotation")
+}
+
+// IsNodeGroupRotationID reports whether a workflow ID is a [NodeGroupRotationID].
+//
+// Workflows started under a different ID only start the actual rotation as a child workflow.
+func IsNodeGroupRotationID(workflowID string) bool {
+	rest, ok := strings.CutPrefix(workflowID, "cluster/")
+	if !ok {
+		return false
+	}
+
+	rest, ok = strings.CutSuffix(rest, "/rotation")
+	if !ok {
+		return false
+	}
+
+	clusterName, nodeGroupName, ok := strings.Cut(rest, "/nodegroup/")
+
+	return ok && clusterName != "" && nodeGroupName != "" && !strings.Contains(nodeGroupName, "/")
+}
+
+// runNodeGroupRotation runs a node group rotation as a child workflow under [NodeGroupRotationID].
+//
+// The child fails to start if the nodes of the node group are already being rotated.
+// It keeps the versioning intent set on the context (see [workflow.WithWorkflowVersioningIntent]).
+// Pause and resume signals of the parent are forwarded to the rotation (if pauser is not nil).
+func runNodeGroupRotation(ctx workflow.Context, clusterName string, nodeGroupName string, fn any, input any, output any, pauser *pauser) error {
+	cwo := workflow.ChildWorkflowOptions{
+		WorkflowID: NodeGroupRotationID(clusterName, nodeGroupName),
+
+		// Let the rotation release the node group when the parent is cancelled
+		ParentClosePolicy:   enumspb.PARENT_CLOSE_POLICY_REQUEST_CANCEL,
+		WaitForCancellation: true,
+
+		VersioningIntent: workflow.GetChildWorkflowOptions(ctx).VersioningIntent,
+	}
+	ctx = workflow.WithChildOptions(ctx, cwo)
+
+	future := workflow.ExecuteChildWorkflow(ctx, fn, input)
+
+	if pauser == nil {
+		return future.Get(ctx, output)
+	}
+
+	return pauser.runChild(ctx, future, output)
 }
@@ -0,0 +1,54 @@
+package workflows
+
+import (
+	"testing"
+	"time"
+
+	"go.temporal.io/sdk/testsuite"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/sagikazarmark/thesis/worker/activities/awsactivities"
+	"github.com/sagikazarmark/thesis/worker/activities/kubeactivities"
+)
+
+// newTestWorkflowEnvironment returns a test environment with the activities used by node group workflows registered.
+//
+// Activities have no clients in tests, so every activity a test executes has to be mocked.
+func newTestWorkflowEnvironment(t *testing.T) *testsuite.TestWorkflowEnvironment {
+	var suite testsuite.WorkflowTestSuite
+
+	env := suite.NewTestWorkflowEnvironment()
+
+	env.RegisterActivity(&awsactivities.AutoScaling{})
+	env.RegisterActivity(&awsactivities.CloudFormation{})
+	env.RegisterActivity(&awsactivities.EC2{})
+	env.RegisterActivity(&kubeactivities.Nodes{})
+
+	t.Cleanup(func() {
+		env.AssertExpectations(t)
+	})
+
+	return env
+}
+
+// testNode returns a ready node backed by an EC2 instance.
+func testNode(name string, instanceID string, zone string, createdAt time.Time) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			CreationTimestamp: metav1.NewTime(createdAt),
+			Labels: map[string]string{
+				corev1.LabelTopologyZone: zone,
+			},
+		},
+		Spec: corev1.NodeSpec{
+			ProviderID: "aws:///" + zone + "/" + instanceID,
+		},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{
+				{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+			},
+		},
+	}
+}